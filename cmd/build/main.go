@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/convox/convox/pkg/build"
@@ -106,6 +107,11 @@ func execute() error {
 		flagUrl = v
 	}
 
+	secrets, err := readSecrets(os.Getenv("BUILD_SECRETS"))
+	if err != nil {
+		return err
+	}
+
 	opts := build.Options{
 		App:         flagApp,
 		Auth:        flagAuth,
@@ -117,6 +123,7 @@ func execute() error {
 		Manifest:    flagManifest,
 		Push:        flagPush,
 		Rack:        flagRack,
+		Secrets:     secrets,
 		Source:      flagUrl,
 	}
 
@@ -137,3 +144,34 @@ func execute() error {
 
 	return nil
 }
+
+// readSecrets reads the build secrets mounted in dir, one file per secret
+// named by its id
+func readSecrets(dir string) (map[string]string, error) {
+	secrets := map[string]string{}
+
+	if dir == "" {
+		return secrets, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read build secrets: %s", err)
+	}
+
+	for _, e := range entries {
+		// skip the timestamped directories that kubernetes links the files to
+		if strings.HasPrefix(e.Name(), "..") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read build secret %s: %s", e.Name(), err)
+		}
+
+		secrets[e.Name()] = string(data)
+	}
+
+	return secrets, nil
+}
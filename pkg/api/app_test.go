@@ -2,6 +2,7 @@ package api_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -187,6 +188,22 @@ func TestAppLogs(t *testing.T) {
 	})
 }
 
+func TestAppLogsCompress(t *testing.T) {
+	testServer(t, func(c *stdsdk.Client, p *structs.MockProvider) {
+		d1 := []byte("test")
+		r1 := ioutil.NopCloser(bytes.NewReader(d1))
+		opts := structs.LogsOptions{Compress: options.Bool(true), Since: options.Duration(2 * time.Minute)}
+		p.On("AppLogs", "app1", opts).Return(r1, nil)
+		r2, err := c.Websocket("/apps/app1/logs", stdsdk.RequestOptions{Headers: stdsdk.Headers{"Compress": "true"}})
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(r2)
+		require.NoError(t, err)
+		d2, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+		require.Equal(t, d1, d2)
+	})
+}
+
 func TestAppLogsError(t *testing.T) {
 	testServer(t, func(c *stdsdk.Client, p *structs.MockProvider) {
 		opts := structs.LogsOptions{Since: options.Duration(2 * time.Minute)}
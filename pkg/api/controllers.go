@@ -168,7 +168,13 @@ func (s *Server) AppLogs(c *stdapi.Context) error {
 		defer c.Close()
 	}
 
-	if _, err := io.Copy(c, v); err != nil {
+	w, flush := streamWriter(c, opts.Compress)
+
+	if _, err := io.Copy(w, v); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
 		return err
 	}
 
@@ -370,7 +376,13 @@ func (s *Server) BuildLogs(c *stdapi.Context) error {
 		defer c.Close()
 	}
 
-	if _, err := io.Copy(c, v); err != nil {
+	w, flush := streamWriter(c, opts.Compress)
+
+	if _, err := io.Copy(w, v); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
 		return err
 	}
 
@@ -927,7 +939,13 @@ func (s *Server) ProcessLogs(c *stdapi.Context) error {
 		defer c.Close()
 	}
 
-	if _, err := io.Copy(c, v); err != nil {
+	w, flush := streamWriter(c, opts.Compress)
+
+	if _, err := io.Copy(w, v); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
 		return err
 	}
 
@@ -1331,7 +1349,13 @@ func (s *Server) ServiceLogs(c *stdapi.Context) error {
 		defer c.Close()
 	}
 
-	if _, err := io.Copy(c, v); err != nil {
+	w, flush := streamWriter(c, opts.Compress)
+
+	if _, err := io.Copy(w, v); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
 		return err
 	}
 
@@ -1423,7 +1447,13 @@ func (s *Server) SystemLogs(c *stdapi.Context) error {
 		defer c.Close()
 	}
 
-	if _, err := io.Copy(c, v); err != nil {
+	w, flush := streamWriter(c, opts.Compress)
+
+	if _, err := io.Copy(w, v); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
 		return err
 	}
 
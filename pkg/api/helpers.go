@@ -1,6 +1,7 @@
 package api
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 )
@@ -9,3 +10,29 @@ func renderStatusCode(w io.Writer, code int) error {
 	_, err := fmt.Fprintf(w, "F1E49A85-0AD7-4AEF-A618-C249C6E6568D:%d\n", code)
 	return err
 }
+
+// streamWriter returns the writer for a stream along with a func to call once
+// it ends, the stream is gzipped when compress is set and each write is
+// flushed so that logs still reach the client as they come
+func streamWriter(w io.Writer, compress *bool) (io.Writer, func() error) {
+	if compress == nil || !*compress {
+		return w, func() error { return nil }
+	}
+
+	gz := &flushWriter{gzip.NewWriter(w)}
+
+	return gz, gz.Close
+}
+
+type flushWriter struct {
+	*gzip.Writer
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.Flush()
+}
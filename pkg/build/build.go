@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 
 	"path/filepath"
@@ -28,6 +29,7 @@ type Options struct {
 	Auth        string
 	BuildArgs   []string
 	Cache       bool
+	Concurrency int
 	Development bool
 	EnvWrapper  bool
 	Id          string
@@ -35,6 +37,7 @@ type Options struct {
 	Output      io.Writer
 	Push        string
 	Rack        string
+	Secrets     map[string]string
 	Source      string
 	Terminal    bool
 }
@@ -45,6 +48,8 @@ type Build struct {
 	Provider structs.Provider
 	Engine   Engine
 	logs     bytes.Buffer
+	redact   *common.RedactWriter
+	secrets  map[string]string
 	writer   io.Writer
 }
 
@@ -62,9 +67,21 @@ func New(rack structs.Provider, opts Options, engine Engine) (*Build, error) {
 	b.logs = bytes.Buffer{}
 
 	if opts.Output != nil {
-		b.writer = io.MultiWriter(opts.Output, &b.logs)
+		b.writer = &lockedWriter{writer: io.MultiWriter(opts.Output, &b.logs)}
 	} else {
-		b.writer = io.MultiWriter(os.Stdout, &b.logs)
+		b.writer = &lockedWriter{writer: io.MultiWriter(os.Stdout, &b.logs)}
+	}
+
+	// secrets echoed by the build are kept out of its output and stored logs
+	if len(opts.Secrets) > 0 {
+		values := []string{}
+
+		for _, v := range opts.Secrets {
+			values = append(values, v)
+		}
+
+		b.redact = common.NewRedactWriter(b.writer, values)
+		b.writer = &lockedWriter{writer: b.redact}
 	}
 
 	return b, nil
@@ -104,10 +121,11 @@ func (bb *Build) execute() error {
 		return err
 	}
 
-	dir, err := bb.prepareSource()
+	dir, clone, err := bb.prepareSource()
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(clone)
 
 	data, err := os.ReadFile(filepath.Join(dir, bb.Manifest))
 	if err != nil {
@@ -118,6 +136,12 @@ func (bb *Build) execute() error {
 		return err
 	}
 
+	secrets, err := bb.writeSecrets()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(secrets)
+
 	if err := bb.Engine.Build(bb, dir); err != nil {
 		return err
 	}
@@ -129,22 +153,111 @@ func (bb *Build) execute() error {
 	return nil
 }
 
-func (bb *Build) prepareSource() (string, error) {
-	u, err := url.Parse(bb.Source)
+// writeSecrets writes each secret to its own file in a temporary directory,
+// outside of the build context, to be mounted into the build by id
+func (bb *Build) writeSecrets() (string, error) {
+	bb.secrets = map[string]string{}
+
+	if len(bb.Secrets) == 0 {
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), "")
 	if err != nil {
 		return "", err
 	}
 
+	for id, v := range bb.Secrets {
+		file := filepath.Join(dir, fmt.Sprintf("secret-%d", len(bb.secrets)))
+
+		if err := os.WriteFile(file, []byte(v), 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+
+		bb.secrets[id] = file
+	}
+
+	return dir, nil
+}
+
+// secretArgs returns the arguments that mount the secrets into a build, in a
+// stable order
+func (bb *Build) secretArgs() []string {
+	ids := []string{}
+
+	for id := range bb.secrets {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	args := []string{}
+
+	for _, id := range ids {
+		args = append(args, "--secret", fmt.Sprintf("id=%s,src=%s", id, bb.secrets[id]))
+	}
+
+	return args
+}
+
+// prepareSource returns the directory of the source, along with the clone of
+// a git source that is removed once the build is done
+func (bb *Build) prepareSource() (string, string, error) {
+	u, err := url.Parse(bb.Source)
+	if err != nil {
+		return "", "", err
+	}
+
 	switch u.Scheme {
 	case "dir":
-		return u.Path, nil
+		return u.Path, "", nil
+	case "git", "git+file", "git+https", "git+ssh":
+		dir, err := bb.prepareSourceGit(u)
+		if err != nil {
+			return "", "", err
+		}
+		return dir, dir, nil
 	case "object":
-		return bb.prepareSourceObject(u.Host, u.Path)
+		dir, err := bb.prepareSourceObject(u.Host, u.Path)
+		if err != nil {
+			return "", "", err
+		}
+		return dir, "", nil
 	default:
-		return "", fmt.Errorf("unknown source type")
+		return "", "", fmt.Errorf("unknown source type")
 	}
 }
 
+// prepareSourceGit clones the branch or tag in the fragment of the url, or
+// the default branch, into a temporary directory. git clone --branch does not
+// take a commit
+func (bb *Build) prepareSourceGit(u *url.URL) (string, error) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		return "", err
+	}
+
+	repo := *u
+	repo.Fragment = ""
+	repo.Scheme = strings.TrimPrefix(repo.Scheme, "git+")
+
+	args := []string{"clone", "--depth", "1"}
+
+	if u.Fragment != "" {
+		args = append(args, "--branch", u.Fragment)
+	}
+
+	args = append(args, repo.String(), dir)
+
+	if err := bb.Exec.Run(bb.writer, "git", args...); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return dir, nil
+}
+
 func (bb *Build) prepareSourceObject(app, key string) (string, error) {
 	dir, err := os.MkdirTemp(os.TempDir(), "")
 	if err != nil {
@@ -179,6 +292,8 @@ func (bb *Build) prepareSourceObject(app, key string) (string, error) {
 }
 
 func (bb *Build) success() error {
+	bb.flush()
+
 	logs, err := bb.Provider.ObjectStore(bb.App, fmt.Sprintf("build/%s/logs", bb.Id), bytes.NewReader(bb.logs.Bytes()), structs.ObjectStoreOptions{})
 	if err != nil {
 		return err
@@ -212,9 +327,19 @@ func (bb *Build) success() error {
 	return nil
 }
 
+// flush writes the output held back by the redaction of secrets before the
+// logs are stored
+func (bb *Build) flush() {
+	if bb.redact != nil {
+		bb.redact.Flush()
+	}
+}
+
 func (bb *Build) fail(buildError error) error {
 	bb.Printf("ERROR: %s\n", buildError)
 
+	bb.flush()
+
 	bb.Provider.EventSend("build:create", structs.EventSendOptions{Data: map[string]string{"app": bb.App, "id": bb.Id}, Error: options.String(buildError.Error())})
 
 	logs, err := bb.Provider.ObjectStore(bb.App, fmt.Sprintf("build/%s/logs", bb.Id), bytes.NewReader(bb.logs.Bytes()), structs.ObjectStoreOptions{})
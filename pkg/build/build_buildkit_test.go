@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/convox/convox/pkg/build"
@@ -242,6 +243,80 @@ func TestBuildOptions(t *testing.T) {
 	})
 }
 
+func TestBuildSecrets(t *testing.T) {
+	opts := build.Options{
+		App:      "app1",
+		Auth:     "{}",
+		Cache:    true,
+		Id:       "build1",
+		Rack:     "rack1",
+		Secrets:  map[string]string{"npm": "token1"},
+		Source:   "object://app1/object.tgz",
+		Push:     "registry.test.com",
+		Manifest: "convox2.yml",
+	}
+
+	t.Setenv("PROVIDER", "do")
+	testBuild(t, opts, bkEngine, func(b *build.Build, p *structs.MockProvider, e *exec.MockInterface, out *bytes.Buffer) {
+		p.On("BuildGet", "app1", "build1").Return(fxBuildStarted(), nil).Once()
+
+		bdata, err := os.ReadFile("testdata/httpd.tgz")
+		require.NoError(t, err)
+		p.On("ObjectFetch", "app1", "/object.tgz").Return(io.NopCloser(bytes.NewReader(bdata)), nil)
+
+		p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(fxBuildStarted(), nil)
+		p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{*fxRelease()}, nil)
+		p.On("ReleaseGet", "app1", "release1").Return(fxRelease(), nil)
+
+		e.On(
+			"Run",
+			mock.Anything,
+			"buildctl", "build", "--frontend", "dockerfile.v0", "--local", mock.MatchedBy(matchContext), "--local", mock.MatchedBy(matchDockerfile),
+			"--opt", mock.MatchedBy(matchFilename), "--output", mock.MatchedBy(matchTag),
+			"--export-cache", "type=registry,ref=registry.test.com:web.buildcache",
+			"--import-cache", "type=registry,ref=registry.test.com:web.buildcache",
+			"--opt", "build-arg:FOO=bar",
+			"--secret", mock.MatchedBy(func(arg string) bool { return strings.HasPrefix(arg, "id=npm,src=") }),
+		).Return(nil).Run(func(args mock.Arguments) {
+			src := strings.TrimPrefix(args.Get(20).(string), "id=npm,src=")
+
+			data, err := os.ReadFile(src)
+			require.NoError(t, err)
+			require.Equal(t, "token1", string(data))
+
+			w := args.Get(0).(io.Writer)
+
+			// a secret split across writes is still redacted
+			fmt.Fprintf(w, "using tok")
+			fmt.Fprintf(w, "en1\n")
+			fmt.Fprintf(w, "done tok")
+		})
+
+		e.On(
+			"Execute",
+			"skopeo",
+			"inspect",
+			"--config",
+			"docker://registry.test.com:web.build1",
+		).Return([]byte("''"), nil)
+
+		p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(fxObject(), nil).Run(func(args mock.Arguments) {
+			data, err := io.ReadAll(args.Get(2).(io.Reader))
+			require.NoError(t, err)
+			require.NotContains(t, string(data), "token1")
+		})
+		p.On("ReleaseCreate", "app1", structs.ReleaseCreateOptions{Build: options.String("build1")}).Return(fxRelease2(), nil)
+		p.On("EventSend", "build:create", structs.EventSendOptions{Data: map[string]string{"app": "app1", "id": "build1", "release_id": "release2"}}).Return(nil)
+
+		err = b.Execute()
+		require.NoError(t, err)
+
+		require.Contains(t, out.String(), "using [redacted]\n")
+		require.Contains(t, out.String(), "done tok")
+		require.NotContains(t, out.String(), "token1")
+	})
+}
+
 func TestLogin(t *testing.T) {
 	tmp, err := os.MkdirTemp(os.TempDir(), "convox-tests")
 	if err != nil {
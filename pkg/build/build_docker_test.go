@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -85,6 +87,112 @@ func TestBuildGeneration2(t *testing.T) {
 	})
 }
 
+func TestBuildGeneration2Concurrency(t *testing.T) {
+	opts := build.Options{
+		App:         "app1",
+		Auth:        "{}",
+		Cache:       true,
+		Concurrency: 2,
+		Id:          "build1",
+		Rack:        "rack1",
+		Source:      "object://app1/object.tgz",
+	}
+
+	testBuild(t, opts, dockerEngine, func(b *build.Build, p *structs.MockProvider, e *exec.MockInterface, out *bytes.Buffer) {
+		p.On("BuildGet", "app1", "build1").Return(fxBuildStarted(), nil).Once()
+		bdata, err := os.ReadFile("testdata/httpd.tgz")
+		require.NoError(t, err)
+		p.On("ObjectFetch", "app1", "/object.tgz").Return(io.NopCloser(bytes.NewReader(bdata)), nil)
+		mdata, err := os.ReadFile("testdata/httpd/convox.yml")
+		require.NoError(t, err)
+		p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{*fxRelease()}, nil)
+		p.On("ReleaseGet", "app1", "release1").Return(fxRelease(), nil)
+		e.On("Run", mock.Anything, "docker", "build", "-t", "e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445", "-f", mock.MatchedBy(matchTempdirFile("Dockerfile")), "--network", "host", mock.MatchedBy(matchTempdir)).Return(nil).Run(func(args mock.Arguments) {
+			fmt.Fprintf(args.Get(0).(io.Writer), "build1\nbuild2\n")
+		})
+		e.On("Execute", "docker", "inspect", "e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445", "--format", "{{json .Config.Entrypoint}}").Return([]byte("[]"), nil)
+		e.On("Execute", "docker", "pull", "httpd").Return([]byte("pulling\n"), nil)
+		e.On("Execute", "docker", "tag", "httpd", "rack1/app1:web.build1").Return([]byte("tagging\n"), nil)
+		e.On("Execute", "docker", "tag", "e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445", "rack1/app1:web2.build1").Return([]byte("tagging\n"), nil)
+		p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(fxObject(), nil).Run(func(args mock.Arguments) {
+			data, err := io.ReadAll(args.Get(2).(io.Reader))
+			require.NoError(t, err)
+			require.Equal(t, "web2: Building: .\nweb2: build1\nweb2: build2\nRunning: docker pull httpd\nRunning: docker tag e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445 rack1/app1:web2.build1\nRunning: docker tag httpd rack1/app1:web.build1\n", string(data))
+		})
+		p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(fxBuildStarted(), nil).Run(func(args mock.Arguments) {
+			opts := args.Get(2).(structs.BuildUpdateOptions)
+			if opts.Ended != nil {
+				require.False(t, opts.Ended.IsZero())
+			}
+			if opts.Logs != nil {
+				require.NotNil(t, opts.Logs)
+			}
+			if opts.Manifest != nil {
+				require.Equal(t, string(mdata), *opts.Manifest)
+			}
+		})
+		p.On("ReleaseCreate", "app1", structs.ReleaseCreateOptions{Build: options.String("build1")}).Return(fxRelease2(), nil)
+		p.On("EventSend", "build:create", structs.EventSendOptions{Data: map[string]string{"app": "app1", "id": "build1", "release_id": "release2"}}).Return(nil)
+
+		err = b.Execute()
+		require.NoError(t, err)
+
+		require.Equal(t,
+			[]string{
+				"web2: Building: .",
+				"web2: build1",
+				"web2: build2",
+				"Running: docker pull httpd",
+				"Running: docker tag e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445 rack1/app1:web2.build1",
+				"Running: docker tag httpd rack1/app1:web.build1",
+			},
+			strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"),
+		)
+	})
+}
+
+func TestBuildGeneration2ConcurrencyFailure(t *testing.T) {
+	opts := build.Options{
+		App:         "app1",
+		Auth:        "{}",
+		Cache:       true,
+		Concurrency: 2,
+		Id:          "build1",
+		Rack:        "rack1",
+		Source:      "object://app1/object.tgz",
+	}
+
+	testBuild(t, opts, dockerEngine, func(b *build.Build, p *structs.MockProvider, e *exec.MockInterface, out *bytes.Buffer) {
+		p.On("BuildGet", "app1", "build1").Return(fxBuildStarted(), nil).Once()
+		bdata, err := os.ReadFile("testdata/multi.tgz")
+		require.NoError(t, err)
+		p.On("ObjectFetch", "app1", "/object.tgz").Return(io.NopCloser(bytes.NewReader(bdata)), nil)
+		p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{*fxRelease()}, nil)
+		p.On("ReleaseGet", "app1", "release1").Return(fxRelease(), nil)
+
+		var started sync.WaitGroup
+		started.Add(2)
+
+		var runs int32
+
+		// both slots fail once they are taken, the third build is never started
+		e.On("Run", mock.Anything, "docker", "build", "-t", mock.Anything, "-f", mock.Anything, "--network", "host", mock.MatchedBy(matchTempdir)).Return(fmt.Errorf("err1")).Run(func(args mock.Arguments) {
+			atomic.AddInt32(&runs, 1)
+			started.Done()
+			started.Wait()
+		})
+
+		p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(fxObject(), nil)
+		p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(fxBuildStarted(), nil)
+		p.On("EventSend", "build:create", structs.EventSendOptions{Data: map[string]string{"app": "app1", "id": "build1"}, Error: options.String("err1")}).Return(nil)
+
+		err = b.Execute()
+		require.EqualError(t, err, "err1")
+
+		require.Equal(t, int32(2), atomic.LoadInt32(&runs))
+	})
+}
+
 func TestBuildGeneration2Development(t *testing.T) {
 	opts := build.Options{
 		App:         "app1",
@@ -250,6 +358,34 @@ func TestBuildGeneration2Failure(t *testing.T) {
 	})
 }
 
+func TestBuildGeneration2Secrets(t *testing.T) {
+	opts := build.Options{
+		App:     "app1",
+		Auth:    "{}",
+		Cache:   true,
+		Id:      "build1",
+		Rack:    "rack1",
+		Secrets: map[string]string{"npm": "token1"},
+		Source:  "object://app1/object.tgz",
+	}
+
+	testBuild(t, opts, dockerEngine, func(b *build.Build, p *structs.MockProvider, e *exec.MockInterface, out *bytes.Buffer) {
+		p.On("BuildGet", "app1", "build1").Return(fxBuildStarted(), nil)
+
+		data, err := os.ReadFile("testdata/httpd.tgz")
+		require.NoError(t, err)
+		p.On("ObjectFetch", "app1", "/object.tgz").Return(io.NopCloser(bytes.NewReader(data)), nil)
+
+		p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(fxObject(), nil)
+		p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(fxBuildStarted(), nil)
+		p.On("EventSend", "build:create", structs.EventSendOptions{Data: map[string]string{"app": "app1", "id": "build1"}, Error: options.String("build secrets require the buildkit engine")}).Return(nil)
+
+		err = b.Execute()
+		require.EqualError(t, err, "build secrets require the buildkit engine")
+		require.NotContains(t, out.String(), "token1")
+	})
+}
+
 func TestBuildGeneration2Options(t *testing.T) {
 	opts := build.Options{
 		App:         "app1",
@@ -330,6 +466,95 @@ func TestBuildGeneration2Options(t *testing.T) {
 	})
 }
 
+func TestBuildGeneration2GitSource(t *testing.T) {
+	opts := build.Options{
+		App:    "app1",
+		Auth:   "{}",
+		Cache:  true,
+		Id:     "build1",
+		Rack:   "rack1",
+		Source: "git+https://github.com/example/app.git#main",
+	}
+
+	testBuild(t, opts, dockerEngine, func(b *build.Build, p *structs.MockProvider, e *exec.MockInterface, out *bytes.Buffer) {
+		clone := ""
+
+		p.On("BuildGet", "app1", "build1").Return(fxBuildStarted(), nil).Once()
+		e.On("Run", mock.Anything, "git", "clone", "--depth", "1", "--branch", "main", "https://github.com/example/app.git", mock.MatchedBy(matchTempdir)).Return(nil).Run(func(args mock.Arguments) {
+			clone = args.Get(8).(string)
+			fmt.Fprintf(args.Get(0).(io.Writer), "cloning\n")
+			require.NoError(t, os.CopyFS(clone, os.DirFS("testdata/httpd")))
+		})
+		mdata, err := os.ReadFile("testdata/httpd/convox.yml")
+		require.NoError(t, err)
+		p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{*fxRelease()}, nil)
+		p.On("ReleaseGet", "app1", "release1").Return(fxRelease(), nil)
+		e.On("Run", mock.Anything, "docker", "build", "-t", "e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445", "-f", mock.MatchedBy(matchTempdirFile("Dockerfile")), "--network", "host", mock.MatchedBy(matchTempdir)).Return(nil).Run(func(args mock.Arguments) {
+			fmt.Fprintf(args.Get(0).(io.Writer), "build1\n")
+		})
+		e.On("Execute", "docker", "inspect", "e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445", "--format", "{{json .Config.Entrypoint}}").Return([]byte("[]"), nil)
+		e.On("Execute", "docker", "pull", "httpd").Return([]byte("pulling\n"), nil)
+		e.On("Execute", "docker", "tag", "httpd", "rack1/app1:web.build1").Return([]byte("tagging\n"), nil)
+		e.On("Execute", "docker", "tag", "e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445", "rack1/app1:web2.build1").Return([]byte("tagging\n"), nil)
+		p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(fxObject(), nil)
+		p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(fxBuildStarted(), nil).Run(func(args mock.Arguments) {
+			// the manifest comes from the clone rather than the local directory
+			if opts := args.Get(2).(structs.BuildUpdateOptions); opts.Manifest != nil {
+				require.Equal(t, string(mdata), *opts.Manifest)
+			}
+		})
+		p.On("ReleaseCreate", "app1", structs.ReleaseCreateOptions{Build: options.String("build1")}).Return(fxRelease2(), nil)
+		p.On("EventSend", "build:create", structs.EventSendOptions{Data: map[string]string{"app": "app1", "id": "build1", "release_id": "release2"}}).Return(nil)
+
+		err = b.Execute()
+		require.NoError(t, err)
+
+		require.NotEmpty(t, clone)
+		require.NoDirExists(t, clone)
+
+		require.Equal(t,
+			[]string{
+				"cloning",
+				"Building: .",
+				"build1",
+				"Running: docker pull httpd",
+				"Running: docker tag e00bc968ebe3f5b4c934a1f3c00fcfba74384f944f6f9fa2ba819445 rack1/app1:web2.build1",
+				"Running: docker tag httpd rack1/app1:web.build1",
+			},
+			strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"),
+		)
+	})
+}
+
+func TestBuildGeneration2GitSourceFailure(t *testing.T) {
+	opts := build.Options{
+		App:    "app1",
+		Auth:   "{}",
+		Cache:  true,
+		Id:     "build1",
+		Rack:   "rack1",
+		Source: "git+https://github.com/example/app.git#nosuch",
+	}
+
+	testBuild(t, opts, dockerEngine, func(b *build.Build, p *structs.MockProvider, e *exec.MockInterface, out *bytes.Buffer) {
+		clone := ""
+
+		p.On("BuildGet", "app1", "build1").Return(fxBuildStarted(), nil)
+		e.On("Run", mock.Anything, "git", "clone", "--depth", "1", "--branch", "nosuch", "https://github.com/example/app.git", mock.MatchedBy(matchTempdir)).Return(fmt.Errorf("exit status 128")).Run(func(args mock.Arguments) {
+			clone = args.Get(8).(string)
+		})
+		p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(fxObject(), nil)
+		p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(fxBuildStarted(), nil)
+		p.On("EventSend", "build:create", structs.EventSendOptions{Data: map[string]string{"app": "app1", "id": "build1"}, Error: options.String("exit status 128")}).Return(nil)
+
+		err := b.Execute()
+		require.EqualError(t, err, "exit status 128")
+
+		require.NotEmpty(t, clone)
+		require.NoDirExists(t, clone)
+	})
+}
+
 func fxBuildStarted() *structs.Build {
 	return &structs.Build{
 		Id:          "build1",
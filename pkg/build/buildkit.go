@@ -251,6 +251,8 @@ func (bk *BuildKit) build(bb *Build, path, dockerfile, tag string, env map[strin
 
 	args = append(args, ba...)
 
+	args = append(args, bb.secretArgs()...)
+
 	if !bb.Cache {
 		args = append(args, "--no-cache")
 	}
@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/convox/convox/pkg/common"
 	"github.com/convox/convox/pkg/manifest"
@@ -21,6 +23,11 @@ import (
 type Docker struct{}
 
 func (d *Docker) Build(bb *Build, dir string) error {
+	// docker build can only mount secrets with buildkit, which is not enabled here
+	if len(bb.Secrets) > 0 {
+		return fmt.Errorf("build secrets require the buildkit engine")
+	}
+
 	config := filepath.Join(dir, bb.Manifest)
 
 	if _, err := os.Stat(config); os.IsNotExist(err) {
@@ -58,6 +65,7 @@ func (d *Docker) Build(bb *Build, dir string) error {
 	prefix := fmt.Sprintf("%s/%s", bb.Rack, bb.App)
 
 	builds := map[string]manifest.ServiceBuild{}
+	names := map[string][]string{}
 	pulls := map[string]bool{}
 	pushes := map[string]string{}
 	tags := map[string][]string{}
@@ -71,6 +79,7 @@ func (d *Docker) Build(bb *Build, dir string) error {
 			tags[m.Services[i].Image] = append(tags[m.Services[i].Image], to)
 		} else {
 			builds[hash] = m.Services[i].Build
+			names[hash] = append(names[hash], m.Services[i].Name)
 			tags[hash] = append(tags[hash], to)
 		}
 
@@ -79,12 +88,8 @@ func (d *Docker) Build(bb *Build, dir string) error {
 		}
 	}
 
-	for hash, b := range builds {
-		bb.Printf("Building: %s\n", b.Path)
-
-		if err := d.build(bb, filepath.Join(dir, b.Path), b.Manifest, hash, env); err != nil {
-			return err
-		}
+	if err := d.buildAll(bb, dir, builds, names, env); err != nil {
+		return err
 	}
 
 	for image := range pulls {
@@ -165,8 +170,74 @@ func (*Docker) Login(bb *Build) error {
 	return nil
 }
 
+// buildAll runs the builds one at a time, or up to bb.Concurrency at once with
+// the output of each prefixed by the services that use it. concurrent builds
+// share the layer cache of the local docker daemon. once a build fails the
+// builds still waiting for a slot are not started and its error is returned
+// when the running ones have finished
+func (d *Docker) buildAll(bb *Build, dir string, builds map[string]manifest.ServiceBuild, names map[string][]string, env map[string]string) error {
+	if bb.Concurrency <= 1 {
+		for hash, b := range builds {
+			bb.Printf("Building: %s\n", b.Path)
+
+			if err := d.build(bb, nil, filepath.Join(dir, b.Path), b.Manifest, hash, env); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	sem := make(chan struct{}, bb.Concurrency)
+	failed := make(chan struct{})
+
+	var first error
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for hash, b := range builds {
+		wg.Add(1)
+
+		go func(hash string, b manifest.ServiceBuild) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-failed:
+				return
+			}
+			defer func() { <-sem }()
+
+			// a slot can free up at the same time as a build fails
+			select {
+			case <-failed:
+				return
+			default:
+			}
+
+			w := &lineWriter{prefix: strings.Join(names[hash], ","), writer: bb.writer}
+			defer w.Flush()
+
+			fmt.Fprintf(w, "Building: %s\n", b.Path)
+
+			if err := d.build(bb, w, filepath.Join(dir, b.Path), b.Manifest, hash, env); err != nil {
+				once.Do(func() {
+					first = err
+					close(failed)
+				})
+			}
+		}(hash, b)
+	}
+
+	wg.Wait()
+
+	return first
+}
+
+// build runs docker build for a tag, writing its output to w when set and
+// otherwise to the terminal or the build output
 // skipcq
-func (*Docker) build(bb *Build, path, dockerfile, tag string, env map[string]string) error {
+func (*Docker) build(bb *Build, w io.Writer, path, dockerfile, tag string, env map[string]string) error {
 	if path == "" {
 		return fmt.Errorf("must have path to build")
 	}
@@ -192,11 +263,16 @@ func (*Docker) build(bb *Build, path, dockerfile, tag string, env map[string]str
 
 	args = append(args, path)
 
-	if bb.Terminal {
+	switch {
+	case w != nil:
+		if err := bb.Exec.Run(w, "docker", args...); err != nil {
+			return err
+		}
+	case bb.Terminal:
 		if err := bb.Exec.Terminal("docker", args...); err != nil {
 			return err
 		}
-	} else {
+	default:
 		if err := bb.Exec.Run(bb.writer, "docker", args...); err != nil {
 			return err
 		}
@@ -0,0 +1,56 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// lockedWriter serializes writes from concurrent builds
+type lockedWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.writer.Write(p)
+}
+
+// lineWriter writes whole lines to writer with a prefix so the output of
+// concurrent builds can be told apart
+type lineWriter struct {
+	buf    []byte
+	prefix string
+	writer io.Writer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if _, err := fmt.Fprintf(w.writer, "%s: %s\n", w.prefix, w.buf[:i]); err != nil {
+			return 0, err
+		}
+
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes any trailing partial line
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		fmt.Fprintf(w.writer, "%s: %s\n", w.prefix, w.buf)
+		w.buf = nil
+	}
+}
@@ -45,6 +45,7 @@ func New(name, version string) *Engine {
 	e.Writer.Tags["service"] = stdcli.RenderColors(33)
 	e.Writer.Tags["setting"] = stdcli.RenderColors(246)
 	e.Writer.Tags["system"] = stdcli.RenderColors(15)
+	e.Writer.Tags["warning"] = stdcli.RenderColors(214)
 
 	for i := 0; i < 18; i++ {
 		e.Writer.Tags[fmt.Sprintf("color%d", i)] = stdcli.RenderColors(237 + i)
@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/convox/convox/pkg/start"
 	"github.com/convox/convox/sdk"
@@ -17,13 +19,66 @@ func init() {
 		Flags: []stdcli.Flag{
 			flagRack,
 			flagApp,
+			stdcli.StringSliceFlag("additional-app", "", "also stream the logs of this app, prefixed by app/service"),
+			stdcli.BoolFlag("attach", "", "attach sync and logs to an app already in development mode without building"),
+			stdcli.IntFlag("auto-reclaim", "", "promote the development release again this many times when it is deployed over"),
+			stdcli.IntFlag("build-concurrency", "", "number of services to build at once with an external build"),
+			stdcli.StringSliceFlag("build-secret", "", "secret to mount into a build on the rack, read from the environment (id) or a file (id=path)"),
+			stdcli.StringFlag("build-source", "", "git url for an external build to clone instead of uploading the local directory, with an optional #branch or #tag"),
+			stdcli.BoolFlag("build-summary", "", "print the size and largest layers of each image after an external build"),
+			stdcli.BoolFlag("compress-logs", "", "ask the rack to gzip the app log stream"),
+			stdcli.BoolFlag("compress-sync", "", "gzip files synced into the running containers"),
+			stdcli.BoolFlag("context-cache", "", "cache the compressed build context between runs and only re-read changed files"),
+			stdcli.BoolFlag("continue-on-build-failure", "", "keep running and retry the build on changes when it fails"),
+			stdcli.StringFlag("dir", "", "directory of the app to build and sync, defaults to the current directory"),
+			stdcli.BoolFlag("delta-sync", "", "only upload the changed blocks of large synced files"),
 			stdcli.BoolFlag("external", "e", "use external build"),
+			stdcli.IntFlag("initial-sync", "", "send every synced file to the containers when sync starts, to this many processes at a time"),
+			stdcli.BoolFlag("json-logs", "", "format structured json log lines"),
+			stdcli.IntFlag("log-buffer", "", "number of recent log lines of each service to show again when start fails"),
+			stdcli.StringFlag("log-line-regex", "", "regex with named groups used to parse app log lines"),
+			stdcli.IntFlag("log-rate-limit", "", "maximum log lines per second to show for each service"),
+			stdcli.DurationFlag("logs-since", "", "show app logs from this long before start"),
 			stdcli.StringFlag("manifest", "m", "manifest file"),
+			stdcli.DurationFlag("max-duration", "", "stop and take the app out of development mode after this long"),
 			stdcli.StringFlag("generation", "g", "generation"),
+			stdcli.DurationFlag("heartbeat", "", "print a status line after this long without activity"),
 			stdcli.BoolFlag("no-build", "", "skip build"),
 			stdcli.BoolFlag("no-cache", "", "build withoit layer cache"),
+			stdcli.BoolFlag("no-logs", "", "do not stream app logs"),
 			stdcli.BoolFlag("no-sync", "", "do not sync local changes into the running containers"),
+			stdcli.BoolFlag("no-sync-deletes", "", "do not remove files from the containers when they are deleted locally"),
+			stdcli.BoolFlag("no-sync-hidden", "", "do not sync hidden files and directories"),
+			stdcli.StringFlag("plan-output", "", "write a json description of what start will do to this file, - for stdout"),
+			stdcli.StringSliceFlag("post-sync", "", "run a command in a service after files are synced to it (service=command)"),
+			stdcli.StringFlag("pre-start", "", "local command to run before the build and sync, e.g. to generate a Dockerfile"),
+			stdcli.BoolFlag("preserve-mtime", "", "set the modification time of synced files in the containers to that of the local files"),
+			stdcli.BoolFlag("production", "", "build and promote a regular release instead of a development one, then exit without syncing or streaming logs"),
+			stdcli.BoolFlag("raw-logs", "", "show app log lines without stripping terminal escape sequences"),
+			stdcli.DurationFlag("reconnect-after", "", "pause sync and wait for the rack once it has failed to answer for this long (default 10s)"),
+			stdcli.BoolFlag("release-env", "", "interpolate the manifest with the env of the promoted release"),
+			stdcli.StringFlag("remote-temp-dir", "", "absolute directory in the containers to stage sync uploads in instead of the default"),
+			stdcli.StringSliceFlag("remote-workdir", "", "absolute directory that relative sync paths resolve to in a service (service=path)"),
+			stdcli.DurationFlag("running-timeout", "", "fail with the state of each process if the app is not running after this long"),
 			stdcli.IntFlag("shift", "s", "shift local port numbers (generation 1 only)"),
+			stdcli.BoolFlag("sparse-sync", "", "leave the holes in large sparse files out of sync uploads, needs gnu tar in the containers"),
+			stdcli.BoolFlag("strict-env", "", "fail when the manifest references environment variables that are not set"),
+			stdcli.BoolFlag("strict-sync", "", "do not sync sources outside of the app directory"),
+			stdcli.StringSliceFlag("sync-allowed-root", "", "only sync files to this container directory or below it"),
+			stdcli.IntFlag("sync-buffer-size", "", "size in bytes of the buffer used to upload synced files"),
+			stdcli.StringSliceFlag("sync-container", "", "container of a service to sync files into instead of the main one (service=container)"),
+			stdcli.StringFlag("sync-content-filter", "", "only sync text files whose contents match this regex"),
+			stdcli.StringSliceFlag("sync-ignore-ext", "", "do not sync files with these comma separated extensions to a service (service=.map,.log)"),
+			stdcli.BoolFlag("sync-mirror", "", "delete files in the containers that do not exist locally when sync starts"),
+			stdcli.StringSliceFlag("sync-mirror-exclude", "", "pattern of container files that sync-mirror must not delete"),
+			stdcli.StringSliceFlag("sync-root", "", "writable directory of a service to sync into instead of its working directory (service=path)"),
+			stdcli.DurationFlag("sync-startup-delay", "", "ignore local changes for this long after a container starts"),
+			stdcli.StringFlag("sync-trigger", "", "hold local changes until this file changes"),
+			stdcli.BoolFlag("sync-xattrs", "", "carry the extended attributes of synced files into the containers"),
+			stdcli.BoolFlag("test", "", "run the test command of each service and exit with its status"),
+			stdcli.BoolFlag("verbose", "", "print the sources watched for each service"),
+			stdcli.BoolFlag("verify-sync", "", "read synced files back from the containers and check that they match"),
+			stdcli.BoolFlag("watch-git", "", "watch and sync the .git, .hg and .svn directories"),
 		},
 		Usage: "[service] [service...]",
 	})
@@ -39,13 +94,133 @@ func Start(rack sdk.Interface, c *stdcli.Context) error {
 	}
 
 	opts := start.Options2{
-		App:      app(c),
-		Build:    !c.Bool("no-build"),
-		Cache:    !c.Bool("no-cache"),
-		External: c.Bool("external"),
-		Manifest: c.String("manifest"),
-		Provider: rack,
-		Sync:     !c.Bool("no-sync"),
+		App:                    app(c),
+		Attach:                 c.Bool("attach"),
+		AutoReclaim:            c.Int("auto-reclaim"),
+		Build:                  !c.Bool("no-build") && !c.Bool("attach"),
+		BuildConcurrency:       c.Int("build-concurrency"),
+		BuildSource:            c.String("build-source"),
+		BuildSummary:           c.Bool("build-summary"),
+		Cache:                  !c.Bool("no-cache"),
+		CompressLogs:           c.Bool("compress-logs"),
+		CompressSync:           c.Bool("compress-sync"),
+		ContextCache:           c.Bool("context-cache"),
+		ContinueOnBuildFailure: c.Bool("continue-on-build-failure"),
+		Dir:                    c.String("dir"),
+		DeltaSync:              c.Bool("delta-sync"),
+		External:               c.Bool("external"),
+		InitialSync:            c.Int("initial-sync"),
+		Input:                  os.Stdin,
+		LogBuffer:              c.Int("log-buffer"),
+		LogLineRegex:           c.String("log-line-regex"),
+		LogMaxLinesPerSecond:   c.Int("log-rate-limit"),
+		Manifest:               c.String("manifest"),
+		NoLogs:                 c.Bool("no-logs"),
+		NoSyncDeletes:          c.Bool("no-sync-deletes"),
+		NoSyncHidden:           c.Bool("no-sync-hidden"),
+		ParseJSONLogs:          c.Bool("json-logs"),
+		PlanOutput:             c.String("plan-output"),
+		PreStart:               c.String("pre-start"),
+		PreserveMtime:          c.Bool("preserve-mtime"),
+		Production:             c.Bool("production"),
+		Provider:               rack,
+		RawLogs:                c.Bool("raw-logs"),
+		ReleaseEnv:             c.Bool("release-env"),
+		RemoteTempDir:          c.String("remote-temp-dir"),
+		SparseSync:             c.Bool("sparse-sync"),
+		StrictEnv:              c.Bool("strict-env"),
+		StrictSync:             c.Bool("strict-sync"),
+		Sync:                   !c.Bool("no-sync"),
+		SyncBufferSize:         c.Int("sync-buffer-size"),
+		SyncContentFilter:      c.String("sync-content-filter"),
+		SyncMirror:             c.Bool("sync-mirror"),
+		SyncTrigger:            c.String("sync-trigger"),
+		SyncXattrs:             c.Bool("sync-xattrs"),
+		Test:                   c.Bool("test"),
+		Verbose:                c.Bool("verbose"),
+		VerifySync:             c.Bool("verify-sync"),
+		WatchGit:               c.Bool("watch-git"),
+	}
+
+	if v, ok := c.Value("heartbeat").(time.Duration); ok {
+		opts.Heartbeat = v
+	}
+
+	if v, ok := c.Value("logs-since").(time.Duration); ok {
+		opts.LogsSince = v
+	}
+
+	if v, ok := c.Value("max-duration").(time.Duration); ok {
+		opts.MaxDuration = v
+	}
+
+	if v, ok := c.Value("reconnect-after").(time.Duration); ok {
+		opts.ReconnectAfter = v
+	}
+
+	if v, ok := c.Value("running-timeout").(time.Duration); ok {
+		opts.RunningTimeout = v
+	}
+
+	if v, ok := c.Value("sync-startup-delay").(time.Duration); ok {
+		opts.SyncStartupDelay = v
+	}
+
+	pse, err := serviceValues(c, "post-sync", "command")
+	if err != nil {
+		return err
+	}
+
+	opts.PostSyncExec = pse
+
+	rwd, err := serviceValues(c, "remote-workdir", "path")
+	if err != nil {
+		return err
+	}
+
+	opts.RemoteWorkdir = rwd
+
+	sr, err := serviceValues(c, "sync-root", "path")
+	if err != nil {
+		return err
+	}
+
+	opts.SyncRoot = sr
+
+	sc, err := serviceValues(c, "sync-container", "container")
+	if err != nil {
+		return err
+	}
+
+	opts.SyncContainer = sc
+
+	sie, err := serviceValues(c, "sync-ignore-ext", "extensions")
+	if err != nil {
+		return err
+	}
+
+	for service, exts := range sie {
+		if opts.SyncIgnoreExtensions == nil {
+			opts.SyncIgnoreExtensions = map[string][]string{}
+		}
+
+		opts.SyncIgnoreExtensions[service] = strings.Split(exts, ",")
+	}
+
+	if v := c.StringSlice("additional-app"); len(v) > 0 {
+		opts.AdditionalApps = v
+	}
+
+	if v := c.StringSlice("build-secret"); len(v) > 0 {
+		opts.BuildSecrets = v
+	}
+
+	if v := c.StringSlice("sync-allowed-root"); len(v) > 0 {
+		opts.SyncAllowedRoots = v
+	}
+
+	if v := c.StringSlice("sync-mirror-exclude"); len(v) > 0 {
+		opts.SyncMirrorExclude = v
 	}
 
 	if len(c.Args) > 0 {
@@ -55,6 +230,28 @@ func Start(rack sdk.Interface, c *stdcli.Context) error {
 	return Starter.Start2(ctx, c, opts)
 }
 
+// serviceValues parses a repeated service=value flag, it returns nil when the
+// flag is not used
+func serviceValues(c *stdcli.Context, flag, value string) (map[string]string, error) {
+	var values map[string]string
+
+	for _, sv := range c.StringSlice(flag) {
+		parts := strings.SplitN(sv, "=", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s must be service=%s", flag, value)
+		}
+
+		if values == nil {
+			values = map[string]string{}
+		}
+
+		values[parts[0]] = parts[1]
+	}
+
+	return values, nil
+}
+
 func handleInterrupt(cancel context.CancelFunc) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, os.Kill)
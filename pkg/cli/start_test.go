@@ -2,7 +2,9 @@ package cli_test
 
 import (
 	"fmt"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/convox/convox/pkg/cli"
 	mocksdk "github.com/convox/convox/pkg/mock/sdk"
@@ -26,6 +28,7 @@ func TestStart2(t *testing.T) {
 			App:      "app1",
 			Build:    true,
 			Cache:    true,
+			Input:    os.Stdin,
 			Provider: i,
 			Sync:     true,
 		}
@@ -53,6 +56,7 @@ func TestStart2Error(t *testing.T) {
 			App:      "app1",
 			Build:    true,
 			Cache:    true,
+			Input:    os.Stdin,
 			Provider: i,
 			Sync:     true,
 		}
@@ -77,18 +81,69 @@ func TestStart2Options(t *testing.T) {
 		cli.Starter = ms
 
 		opts := start.Options2{
-			App:      "app1",
-			Build:    false,
-			Cache:    false,
-			Manifest: "manifest1",
-			Provider: i,
-			Services: []string{"service1", "service2"},
-			Sync:     false,
+			AdditionalApps:   []string{"app2", "app3"},
+			App:              "app1",
+			Attach:           true,
+			AutoReclaim:      3,
+			Build:            false,
+			BuildConcurrency: 4,
+			BuildSecrets:     []string{"NPM_TOKEN", "npmrc=.npmrc"},
+			BuildSource:      "git+https://github.com/example/app.git#main",
+			BuildSummary:     true,
+			Cache:            false,
+			CompressLogs:     true,
+			ContextCache:     true,
+			Dir:              "app",
+			InitialSync:      4,
+			Input:            os.Stdin,
+			LogBuffer:        50,
+			Manifest:         "manifest1",
+			MaxDuration:      time.Hour,
+			NoLogs:           true,
+			NoSyncDeletes:    true,
+			NoSyncHidden:     true,
+			PlanOutput:       "plan.json",
+			PostSyncExec: map[string]string{
+				"service1": "kill -HUP 1",
+				"service2": "make reload",
+			},
+			PreStart:       "make Dockerfile",
+			PreserveMtime:  true,
+			Production:     true,
+			Provider:       i,
+			RawLogs:        true,
+			ReconnectAfter: 30 * time.Second,
+			RemoteTempDir:  "/var/tmp",
+			RemoteWorkdir: map[string]string{
+				"service1": "/srv/app",
+			},
+			RunningTimeout:   5 * time.Minute,
+			Services:         []string{"service1", "service2"},
+			SparseSync:       true,
+			StrictEnv:        true,
+			Sync:             false,
+			SyncAllowedRoots: []string{"/app", "/srv"},
+			SyncContainer: map[string]string{
+				"service1": "sidecar",
+			},
+			SyncContentFilter: "ready: true",
+			SyncIgnoreExtensions: map[string][]string{
+				"service1": {".map", ".log"},
+			},
+			SyncMirror:        true,
+			SyncMirrorExclude: []string{"node_modules"},
+			SyncRoot: map[string]string{
+				"service1": "/mnt/code",
+			},
+			SyncXattrs: true,
+			Test:       true,
+			VerifySync: true,
+			WatchGit:   true,
 		}
 
 		ms.On("Start2", mock.Anything, mock.Anything, opts).Return(nil)
 
-		res, err := testExecute(e, "start -g 2 -a app1 -m manifest1 --no-build --no-cache --no-sync service1 service2", nil)
+		res, err := testExecute(e, "start -g 2 -a app1 -m manifest1 --additional-app app2 --additional-app app3 --attach --auto-reclaim 3 --build-concurrency 4 --build-secret NPM_TOKEN --build-secret npmrc=.npmrc --build-source git+https://github.com/example/app.git#main --build-summary --compress-logs --context-cache --dir app --initial-sync 4 --log-buffer 50 --max-duration 1h --no-build --no-logs --no-cache --no-sync --no-sync-deletes --no-sync-hidden --plan-output plan.json --pre-start 'make Dockerfile' --preserve-mtime --production --raw-logs --reconnect-after 30s --remote-temp-dir /var/tmp --running-timeout 5m --sparse-sync --strict-env --sync-allowed-root /app --sync-allowed-root /srv --sync-container service1=sidecar --sync-content-filter 'ready: true' --sync-ignore-ext service1=.map,.log --sync-mirror --sync-mirror-exclude node_modules --sync-root service1=/mnt/code --sync-xattrs --test --verify-sync --watch-git --remote-workdir service1=/srv/app --post-sync 'service1=kill -HUP 1' --post-sync service2='make reload' service1 service2", nil)
 		require.NoError(t, err)
 		require.Equal(t, 0, res.Code)
 		res.RequireStderr(t, []string{""})
@@ -36,6 +36,21 @@ func AppEnvironment(p structs.Provider, app string) (structs.Environment, error)
 	return env, nil
 }
 
+func ReleaseEnvironment(p structs.Provider, app, release string) (structs.Environment, error) {
+	r, err := p.ReleaseGet(app, release)
+	if err != nil {
+		return nil, err
+	}
+
+	env := structs.Environment{}
+
+	if err := env.Load([]byte(r.Env)); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func AppManifest(p structs.Provider, app string) (*manifest.Manifest, *structs.Release, error) {
 	a, err := p.AppGet(app)
 	if err != nil {
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"bytes"
+	"io"
+)
+
+// RedactWriter replaces each secret with [redacted] in what is written to the
+// underlying writer so that secrets echoed by a command are not shown or kept,
+// the end of a write that could be the start of a secret is held back until
+// the next write so that a secret split across writes is redacted too
+type RedactWriter struct {
+	pending []byte
+	secrets [][]byte
+	writer  io.Writer
+}
+
+func NewRedactWriter(w io.Writer, secrets []string) *RedactWriter {
+	rw := &RedactWriter{writer: w}
+
+	for _, s := range secrets {
+		if s != "" {
+			rw.secrets = append(rw.secrets, []byte(s))
+		}
+	}
+
+	return rw
+}
+
+func (w *RedactWriter) Write(p []byte) (int, error) {
+	data := append(w.pending, p...)
+
+	for _, s := range w.secrets {
+		data = bytes.ReplaceAll(data, s, []byte("[redacted]"))
+	}
+
+	n := len(data) - w.partial(data)
+
+	w.pending = append([]byte{}, data[n:]...)
+
+	if _, err := w.writer.Write(data[:n]); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the end of the last write that was held back
+func (w *RedactWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.writer.Write(w.pending)
+		w.pending = nil
+	}
+}
+
+// partial returns the length of the longest end of data that is the start of
+// a secret
+func (w *RedactWriter) partial(data []byte) int {
+	longest := 0
+
+	for _, s := range w.secrets {
+		for i := len(s) - 1; i > longest; i-- {
+			if bytes.HasSuffix(data, s[:i]) {
+				longest = i
+				break
+			}
+		}
+	}
+
+	return longest
+}
@@ -67,6 +67,12 @@ func RebaseArchive(r io.Reader, src, dst string) (io.Reader, error) {
 }
 
 func Tarball(dir string) ([]byte, error) {
+	return TarballExcluding(dir, nil)
+}
+
+// TarballExcluding returns a gzipped tarball of dir that leaves out the files
+// matching the patterns of its .dockerignore along with excludes
+func TarballExcluding(dir string, excludes []string) ([]byte, error) {
 	abs, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, err
@@ -82,11 +88,13 @@ func Tarball(dir string) ([]byte, error) {
 		return nil, err
 	}
 
-	excludes, err := dockerignore.ReadAll(bytes.NewReader(data))
+	ignores, err := dockerignore.ReadAll(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 
+	excludes = append(ignores, excludes...)
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, err
@@ -131,6 +131,19 @@ func (m *Method) Option() *Arg {
 	return nil
 }
 
+// Compressible returns true when the options of the method can ask for the
+// stream it returns to be gzipped
+func (m *Method) Compressible() bool {
+	o := m.Option()
+	if o == nil {
+		return false
+	}
+
+	_, ok := o.Type.FieldByName("Compress")
+
+	return ok
+}
+
 func (m *Method) Reader() bool {
 	for _, r := range m.Returns {
 		if r.Implements(readerType) {
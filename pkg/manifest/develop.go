@@ -0,0 +1,14 @@
+package manifest
+
+// Develop configures convox start so that a team shares the same local
+// development settings, options passed to convox start take precedence
+type Develop struct {
+	LogLineRegex         string              `yaml:"logLineRegex,omitempty"`
+	NoSyncHidden         bool                `yaml:"noSyncHidden,omitempty"`
+	PostSync             map[string]string   `yaml:"postSync,omitempty"`
+	RemoteWorkdir        map[string]string   `yaml:"remoteWorkdir,omitempty"`
+	Services             []string            `yaml:"services,omitempty"`
+	SyncContainer        map[string]string   `yaml:"syncContainer,omitempty"`
+	SyncIgnoreExtensions map[string][]string `yaml:"syncIgnoreExtensions,omitempty"`
+	SyncMirrorExclude    []string            `yaml:"syncMirrorExclude,omitempty"`
+}
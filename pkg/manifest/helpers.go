@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"regexp"
+	"sort"
 )
 
 var regexpInterpolation = regexp.MustCompile(`\$\{([^}]*?)\}`)
@@ -14,6 +15,28 @@ func interpolate(data []byte, env map[string]string) ([]byte, error) {
 	return p, nil
 }
 
+// MissingEnv returns the sorted names of the variables interpolated in data
+// that are not set in env, which Load would replace with empty strings
+func MissingEnv(data []byte, env map[string]string) []string {
+	missing := []string{}
+	seen := map[string]bool{}
+
+	for _, m := range regexpInterpolation.FindAllSubmatch(data, -1) {
+		name := string(m[1])
+
+		if _, ok := env[name]; ok || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		missing = append(missing, name)
+	}
+
+	sort.Strings(missing)
+
+	return missing
+}
+
 // if s string is in ss slice
 func containsInStringSlice(ss []string, s string) bool {
 	for _, v := range ss {
@@ -28,6 +28,7 @@ type Manifest struct {
 	AppSettings AppSettings `yaml:"appSettings,omitempty"`
 	Balancers   Balancers   `yaml:"balancers,omitempty"`
 	Configs     AppConfigs  `yaml:"configs,omitempty"`
+	Develop     Develop     `yaml:"develop,omitempty"`
 	Environment Environment `yaml:"environment,omitempty"`
 	Labels      Labels      `yaml:"labels,omitempty"`
 	Params      Params      `yaml:"params,omitempty"`
@@ -422,15 +423,10 @@ func (m *Manifest) SetEnv(env map[string]string) {
 	m.env = env
 }
 
+// Validate returns ValidationErrors listing every problem with the manifest
 func (m *Manifest) Validate() error {
 	if errs := m.validate(); len(errs) > 0 {
-		messages := []string{}
-
-		for _, err := range errs {
-			messages = append(messages, err.Error())
-		}
-
-		return fmt.Errorf("validation errors:\n%s", strings.Join(messages, "\n"))
+		return ValidationErrors(errs)
 	}
 
 	return nil
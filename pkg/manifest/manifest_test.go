@@ -664,6 +664,13 @@ func TestManifestLoadInvalid(t *testing.T) {
 	require.Len(t, m.Services, 0)
 }
 
+func TestMissingEnv(t *testing.T) {
+	data := []byte("services:\n  web:\n    image: app:${TAG}\n    environment:\n      - URL=${SCHEME}://${HOST}\n      - EMPTY=${EMPTY}\n      - AGAIN=${TAG}\n")
+
+	require.Equal(t, []string{"HOST", "TAG"}, manifest.MissingEnv(data, map[string]string{"EMPTY": "", "SCHEME": "https"}))
+	require.Equal(t, []string{}, manifest.MissingEnv(data, map[string]string{"EMPTY": "", "HOST": "h", "SCHEME": "https", "TAG": "1"}))
+}
+
 func TestManifestEnvManipulation(t *testing.T) {
 	m, err := testdataManifest("env", map[string]string{})
 	require.NotNil(t, m)
@@ -700,6 +707,9 @@ func TestManifestValidate(t *testing.T) {
 		"balancer alpha has blank service",
 		"balancer alpha whitelist 1.1.1.1 is not a valid cidr range",
 		"balancer bravo refers to unknown service nosuch",
+		"develop references a service that does not exist: nosuch",
+		"develop references a service that does not exist: nosuch",
+		"develop references a service that does not exist: nosuch",
 		"resource name 1resource invalid, must contain only lowercase alphanumeric and dashes",
 		"service deployment-invalid-low deployment minimum can not be less than 0",
 		"service deployment-invalid-low deployment maximum can not be less than 100",
@@ -714,3 +724,45 @@ func TestManifestValidate(t *testing.T) {
 
 	require.EqualError(t, err, fmt.Sprintf("validation errors:\n%s", strings.Join(errors, "\n")))
 }
+
+func TestManifestValidateLocate(t *testing.T) {
+	data, err := common.Testdata("validate")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	ves, ok := m.Validate().(manifest.ValidationErrors)
+	require.True(t, ok)
+
+	located, err := ves.Locate(data)
+	require.NoError(t, err)
+
+	positions := []string{}
+
+	for _, err := range located {
+		ve, ok := err.(manifest.ValidationError)
+		require.True(t, ok)
+		positions = append(positions, fmt.Sprintf("%d:%d %s %s", ve.Line, ve.Column, ve.Service, ve.Field))
+	}
+
+	require.Equal(t, []string{
+		"2:3  balancers.alpha.ports",
+		"2:3  balancers.alpha.service",
+		"3:5  balancers.alpha.whitelist",
+		"7:5  balancers.bravo.service",
+		"11:3  develop.services",
+		"10:5  develop.postSync.nosuch",
+		"14:5  develop.syncIgnoreExtensions.nosuch",
+		"17:3  resources.1resource",
+		"22:7 deployment-invalid-low services.deployment-invalid-low.deployment.minimum",
+		"23:7 deployment-invalid-low services.deployment-invalid-low.deployment.maximum",
+		"26:7 deployment-invalid-high services.deployment-invalid-high.deployment.minimum",
+		"27:7 deployment-invalid-high services.deployment-invalid-high.deployment.maximum",
+		"30:5 internal-router-invalid services.internal-router-invalid.internalRouter",
+		"31:3 serviceF services.serviceF",
+		"33:5 serviceF services.serviceF.resources",
+		"36:3  timers.timer_1",
+		"37:5  timers.timer_1.service",
+	}, positions)
+}
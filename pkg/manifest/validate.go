@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -15,17 +16,98 @@ var (
 	nameValidator = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
 )
 
+// ValidationError is a problem with a field of the manifest, Line and Column
+// are set by ValidationErrors.Locate
+type ValidationError struct {
+	Column  int
+	Field   string
+	Line    int
+	Message string
+	Service string
+}
+
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors are all of the problems found validating a manifest
+type ValidationErrors []error
+
+func (es ValidationErrors) Error() string {
+	messages := []string{}
+
+	for _, err := range es {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Sprintf("validation errors:\n%s", strings.Join(messages, "\n"))
+}
+
+// Locate sets the position of each ValidationError from the manifest source,
+// using the closest part of the field path that is present when the field
+// itself is not
+func (es ValidationErrors) Locate(data []byte) (ValidationErrors, error) {
+	positions, err := yamlPositions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	located := ValidationErrors{}
+
+	for _, err := range es {
+		e, ok := err.(ValidationError)
+		if !ok {
+			located = append(located, err)
+			continue
+		}
+
+		for p := e.Field; p != ""; {
+			if pos, ok := positions[p]; ok {
+				e.Line = pos.Line
+				e.Column = pos.Column
+				break
+			}
+
+			i := strings.LastIndex(p, ".")
+			if i < 0 {
+				break
+			}
+
+			p = p[:i]
+		}
+
+		located = append(located, e)
+	}
+
+	return located, nil
+}
+
+// fieldError returns a ValidationError for a field given by its dotted path
+func fieldError(field string, format string, args ...interface{}) error {
+	e := ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	}
+
+	if parts := strings.Split(field, "."); len(parts) > 1 && parts[0] == "services" {
+		e.Service = parts[1]
+	}
+
+	return e
+}
+
 func (m *Manifest) validate() []error {
 	errs := []error{}
 
 	for i := range m.Configs {
 		if err := m.Configs[i].Validate(); err != nil {
-			errs = append(errs, err)
+			errs = append(errs, fieldError(fmt.Sprintf("configs.%d", i), "%s", err))
 			break
 		}
 	}
 
 	errs = append(errs, m.validateBalancers()...)
+	errs = append(errs, m.validateDevelop()...)
 	errs = append(errs, m.validateEnv()...)
 	errs = append(errs, m.validateResources()...)
 	errs = append(errs, m.validateServices()...)
@@ -39,11 +121,11 @@ func (m *Manifest) validateBalancers() []error {
 
 	for _, b := range m.Balancers {
 		if len(b.Ports) == 0 {
-			errs = append(errs, fmt.Errorf("balancer %s has no ports", b.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("balancers.%s.ports", b.Name), "balancer %s has no ports", b.Name))
 		}
 
 		if b.Service == "" {
-			errs = append(errs, fmt.Errorf("balancer %s has blank service", b.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("balancers.%s.service", b.Name), "balancer %s has blank service", b.Name))
 		} else {
 			serviceFound := false
 
@@ -55,13 +137,57 @@ func (m *Manifest) validateBalancers() []error {
 			}
 
 			if !serviceFound {
-				errs = append(errs, fmt.Errorf("balancer %s refers to unknown service %s", b.Name, b.Service))
+				errs = append(errs, fieldError(fmt.Sprintf("balancers.%s.service", b.Name), "balancer %s refers to unknown service %s", b.Name, b.Service))
 			}
 		}
 
 		for _, w := range b.Whitelist {
 			if _, _, err := net.ParseCIDR(w); err != nil {
-				errs = append(errs, fmt.Errorf("balancer %s whitelist %s is not a valid cidr range", b.Name, w))
+				errs = append(errs, fieldError(fmt.Sprintf("balancers.%s.whitelist", b.Name), "balancer %s whitelist %s is not a valid cidr range", b.Name, w))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (m *Manifest) validateDevelop() []error {
+	errs := []error{}
+
+	for _, name := range m.Develop.Services {
+		if _, err := m.Service(name); err != nil {
+			errs = append(errs, fieldError("develop.services", "develop references a service that does not exist: %s", name))
+		}
+	}
+
+	extensions := map[string]string{}
+
+	for name := range m.Develop.SyncIgnoreExtensions {
+		extensions[name] = ""
+	}
+
+	settings := []struct {
+		field  string
+		values map[string]string
+	}{
+		{"postSync", m.Develop.PostSync},
+		{"remoteWorkdir", m.Develop.RemoteWorkdir},
+		{"syncContainer", m.Develop.SyncContainer},
+		{"syncIgnoreExtensions", extensions},
+	}
+
+	for _, s := range settings {
+		names := []string{}
+
+		for name := range s.values {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			if _, err := m.Service(name); err != nil {
+				errs = append(errs, fieldError(fmt.Sprintf("develop.%s.%s", s.field, name), "develop references a service that does not exist: %s", name))
 			}
 		}
 	}
@@ -74,7 +200,7 @@ func (m *Manifest) validateEnv() []error {
 
 	for _, s := range m.Services {
 		if _, err := m.ServiceEnvironment(s.Name); err != nil {
-			errs = append(errs, err)
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s.environment", s.Name), "%s", err))
 		}
 	}
 
@@ -86,11 +212,11 @@ func (m *Manifest) validateResources() []error {
 
 	for _, r := range m.Resources {
 		if !nameValidator.MatchString(r.Name) {
-			errs = append(errs, fmt.Errorf("resource name %s invalid, %s", r.Name, ValidNameDescription))
+			errs = append(errs, fieldError(fmt.Sprintf("resources.%s", r.Name), "resource name %s invalid, %s", r.Name, ValidNameDescription))
 		}
 
 		if strings.TrimSpace(r.Type) == "" {
-			errs = append(errs, fmt.Errorf("resource %q has blank type", r.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("resources.%s.type", r.Name), "resource %q has blank type", r.Name))
 		}
 	}
 
@@ -107,51 +233,51 @@ func (m *Manifest) validateServices() []error {
 
 	for _, s := range m.Services {
 		if !nameValidator.MatchString(s.Name) {
-			errs = append(errs, fmt.Errorf("service name %s invalid, %s", s.Name, ValidNameDescription))
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s", s.Name), "service name %s invalid, %s", s.Name, ValidNameDescription))
 		}
 
 		if s.Deployment.Minimum < 0 {
-			errs = append(errs, fmt.Errorf("service %s deployment minimum can not be less than 0", s.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s.deployment.minimum", s.Name), "service %s deployment minimum can not be less than 0", s.Name))
 		}
 
 		if s.Deployment.Minimum > 100 {
-			errs = append(errs, fmt.Errorf("service %s deployment minimum can not be greater than 100", s.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s.deployment.minimum", s.Name), "service %s deployment minimum can not be greater than 100", s.Name))
 		}
 
 		if s.Deployment.Maximum < 100 {
-			errs = append(errs, fmt.Errorf("service %s deployment maximum can not be less than 100", s.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s.deployment.maximum", s.Name), "service %s deployment maximum can not be less than 100", s.Name))
 		}
 
 		if s.Deployment.Maximum > 200 {
-			errs = append(errs, fmt.Errorf("service %s deployment maximum can not be greater than 200", s.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s.deployment.maximum", s.Name), "service %s deployment maximum can not be greater than 200", s.Name))
 		}
 
 		if s.Internal && s.InternalRouter {
-			errs = append(errs, fmt.Errorf("service %s can not have both internal and internalRouter set as true", s.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("services.%s.internalRouter", s.Name), "service %s can not have both internal and internalRouter set as true", s.Name))
 		}
 
 		for _, r := range s.ResourcesName() {
 			if _, err := m.Resource(r); err != nil {
 				if strings.HasPrefix(err.Error(), "no such resource") {
-					errs = append(errs, fmt.Errorf("service %s references a resource that does not exist: %s", s.Name, r))
+					errs = append(errs, fieldError(fmt.Sprintf("services.%s.resources", s.Name), "service %s references a resource that does not exist: %s", s.Name, r))
 				}
 			}
 		}
 
 		for i := range s.VolumeOptions {
 			if err := s.VolumeOptions[i].Validate(); err != nil {
-				errs = append(errs, err)
+				errs = append(errs, fieldError(fmt.Sprintf("services.%s.volumeOptions.%d", s.Name, i), "%s", err))
 			}
 		}
 
 		for i := range s.ConfigMounts {
 			cm := &s.ConfigMounts[i]
 			if err := cm.Validate(); err != nil {
-				errs = append(errs, err)
+				errs = append(errs, fieldError(fmt.Sprintf("services.%s.configMounts.%d", s.Name, i), "%s", err))
 			}
 
 			if _, has := configMap[cm.Id]; !has {
-				errs = append(errs, fmt.Errorf("config id: '%s' not found", cm.Id))
+				errs = append(errs, fieldError(fmt.Sprintf("services.%s.configMounts.%d.id", s.Name, i), "config id: '%s' not found", cm.Id))
 			}
 		}
 
@@ -164,17 +290,17 @@ func (m *Manifest) validateTimers() []error {
 
 	for _, t := range m.Timers {
 		if !nameValidator.MatchString(t.Name) {
-			errs = append(errs, fmt.Errorf("timer name %s invalid, %s", t.Name, ValidNameDescription))
+			errs = append(errs, fieldError(fmt.Sprintf("timers.%s", t.Name), "timer name %s invalid, %s", t.Name, ValidNameDescription))
 		}
 
 		if _, err := m.Service(t.Service); err != nil {
 			if strings.HasPrefix(err.Error(), "no such service") {
-				errs = append(errs, fmt.Errorf("timer %s references a service that does not exist: %s", t.Name, t.Service))
+				errs = append(errs, fieldError(fmt.Sprintf("timers.%s.service", t.Name), "timer %s references a service that does not exist: %s", t.Name, t.Service))
 			}
 		}
 
 		if strings.Contains(t.Schedule, "?") {
-			errs = append(errs, fmt.Errorf("timer %s invalid, schedule cannot contain ?", t.Name))
+			errs = append(errs, fieldError(fmt.Sprintf("timers.%s.schedule", t.Name), "timer %s invalid, schedule cannot contain ?", t.Name))
 		}
 	}
 
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 type DefaultsSetter interface {
@@ -738,3 +739,52 @@ func yamlAttributes(data []byte) (map[string]bool, error) {
 
 	return attrs, nil
 }
+
+// position is the line and column of a field in the manifest
+type position struct {
+	Line   int
+	Column int
+}
+
+// yamlPositions returns the position of each key in the same dotted form as
+// yamlAttributes, with the index of sequence items as a path element
+func yamlPositions(data []byte) (map[string]position, error) {
+	positions := map[string]position{}
+
+	var doc yamlv3.Node
+
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) > 0 {
+		yamlNodePositions(doc.Content[0], "", positions)
+	}
+
+	return positions, nil
+}
+
+func yamlNodePositions(n *yamlv3.Node, path string, positions map[string]position) {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+
+		return fmt.Sprintf("%s.%s", path, k)
+	}
+
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := join(n.Content[i].Value)
+			positions[k] = position{Line: n.Content[i].Line, Column: n.Content[i].Column}
+			yamlNodePositions(n.Content[i+1], k, positions)
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			k := join(strconv.Itoa(i))
+			positions[k] = position{Line: c.Line, Column: c.Column}
+			yamlNodePositions(c, k, positions)
+		}
+	}
+}
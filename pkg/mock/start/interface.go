@@ -29,3 +29,17 @@ func (_m *Interface) Start2(_a0 context.Context, _a1 io.Writer, _a2 start.Option
 
 	return r0
 }
+
+// Validate2 provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Interface) Validate2(_a0 context.Context, _a1 io.Writer, _a2 start.Options2) error {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, io.Writer, start.Options2) error); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -16,10 +16,17 @@ type Writer struct {
 	lock     sync.Mutex
 	max      int
 	prefixes map[string]string
+	routes   map[string]io.Writer
 	writer   io.Writer
 }
 
 func NewWriter(w io.Writer, prefixes map[string]string) Writer {
+	return NewRoutedWriter(w, prefixes, nil)
+}
+
+// NewRoutedWriter returns a Writer that writes the lines of each prefix in
+// routes to its own writer and the lines of every other prefix to w
+func NewRoutedWriter(w io.Writer, prefixes map[string]string, routes map[string]io.Writer) Writer {
 	max := 0
 
 	for k := range prefixes {
@@ -28,7 +35,7 @@ func NewWriter(w io.Writer, prefixes map[string]string) Writer {
 		}
 	}
 
-	return Writer{max: max, prefixes: prefixes, writer: w}
+	return Writer{max: max, prefixes: prefixes, routes: routes, writer: w}
 }
 
 func (w Writer) Write(prefix string, r io.Reader) {
@@ -59,7 +66,13 @@ func (w Writer) Writef(prefix string, format string, args ...interface{}) {
 
 	line := fmt.Sprintf(w.format(prefix), prefix, fmt.Sprintf(format, args...))
 
-	fmt.Fprintf(w.writer, "%s", line)
+	out := w.writer
+
+	if r, ok := w.routes[prefix]; ok && r != nil {
+		out = r
+	}
+
+	fmt.Fprintf(out, "%s", line)
 }
 
 func (w Writer) format(prefix string) string {
@@ -0,0 +1,61 @@
+package start
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/pkg/errors"
+)
+
+// additionalServices returns the services of each of AdditionalApps from the
+// manifest of its promoted release
+func (opts Options2) additionalServices() (map[string]map[string]bool, error) {
+	apps := map[string]map[string]bool{}
+
+	for _, app := range opts.AdditionalApps {
+		if app == opts.App {
+			continue
+		}
+
+		m, _, err := common.AppManifest(opts.Provider, app)
+		if err != nil {
+			return nil, errors.WithStack(fmt.Errorf("could not stream logs of %s: %s", app, err))
+		}
+
+		apps[app] = map[string]bool{}
+
+		for i := range m.Services {
+			apps[app][m.Services[i].Name] = true
+		}
+	}
+
+	return apps, nil
+}
+
+// streamAdditionalLogs streams the logs of each additional app alongside those
+// of the app being started, prefixed by app/service so that services of the
+// same name can be told apart
+func (opts Options2) streamAdditionalLogs(ctx context.Context, pw prefix.Writer, apps map[string]map[string]bool) {
+	for app, services := range apps {
+		o := opts
+		o.App = app
+		o.logApp = app
+
+		if opts.logLimit != nil {
+			o.logLimit = &logLimiter{max: opts.logLimit.max, windows: map[string]*logWindow{}}
+		}
+
+		go o.streamLogs(ctx, pw, services)
+	}
+}
+
+// logPrefix returns the prefix that the logs of a service are written with
+func (opts Options2) logPrefix(service string) string {
+	if opts.logApp == "" {
+		return service
+	}
+
+	return fmt.Sprintf("%s/%s", opts.logApp, service)
+}
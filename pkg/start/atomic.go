@@ -0,0 +1,68 @@
+package start
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/convox/changes"
+)
+
+// editorTempNames are the names of the files that vim writes to check that a
+// directory is writable before an atomic save
+var editorTempNames = []string{"4913"}
+
+// editorTempSuffixes are the suffixes of the backup, swap and temporary files
+// that editors write next to a file while saving it
+var editorTempSuffixes = []string{"~", ".swp", ".swx", "___jb_old___", "___jb_tmp___"}
+
+// atomicSaves drops the changes to editor temporary files and keeps only the
+// last change to each path, a file that is removed but exists again has been
+// replaced by a rename and is synced as an add instead of being removed from
+// the containers for a moment
+func atomicSaves(chgs []changes.Change) []changes.Change {
+	last := map[string]int{}
+
+	for i, c := range chgs {
+		if !editorTemp(c.Path) {
+			last[filepath.Join(c.Base, c.Path)] = i
+		}
+	}
+
+	var cs []changes.Change
+
+	for i, c := range chgs {
+		if editorTemp(c.Path) || last[filepath.Join(c.Base, c.Path)] != i {
+			continue
+		}
+
+		if c.Operation == "remove" {
+			if _, err := os.Lstat(filepath.Join(c.Base, c.Path)); err == nil {
+				c.Operation = "add"
+			}
+		}
+
+		cs = append(cs, c)
+	}
+
+	return cs
+}
+
+// editorTemp returns true when path is a file an editor writes while saving
+func editorTemp(path string) bool {
+	name := filepath.Base(path)
+
+	for _, n := range editorTempNames {
+		if name == n {
+			return true
+		}
+	}
+
+	for _, s := range editorTempSuffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
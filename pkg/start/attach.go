@@ -0,0 +1,62 @@
+package start
+
+import (
+	"fmt"
+
+	"github.com/convox/convox/pkg/structs"
+	"github.com/pkg/errors"
+)
+
+// attach checks that the app is already running a release promoted for
+// development so that watchers and logs can be attached to it without a build
+func (opts Options2) attach(a *structs.App, err error) error {
+	if opts.Build {
+		return errors.WithStack(fmt.Errorf("attach can not be used with a build"))
+	}
+
+	if opts.Test {
+		return errors.WithStack(fmt.Errorf("attach can not be used with test"))
+	}
+
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("can not attach to %s: %s", opts.App, err))
+	}
+
+	if a.Release == "" {
+		return errors.WithStack(fmt.Errorf("can not attach to %s: it has no release, run start with a build first", opts.App))
+	}
+
+	if a.Status != "running" {
+		return errors.WithStack(fmt.Errorf("can not attach to %s: it is %s, wait for it to be running", opts.App, a.Status))
+	}
+
+	r, err := opts.Provider.ReleaseGet(opts.App, a.Release)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("can not attach to %s: %s", opts.App, err))
+	}
+
+	// a release deployed over the development one must not receive syncs
+	if !opts.developmentRelease(r) {
+		return errors.WithStack(fmt.Errorf("can not attach to %s: release %s is not in development mode, run start with a build first", opts.App, a.Release))
+	}
+
+	opts.promoted.Store(a.Release)
+
+	return nil
+}
+
+// developmentRelease returns true when a release comes from a build that
+// start made with Development set, releases made from it by changing the
+// environment keep its build
+func (opts Options2) developmentRelease(r *structs.Release) bool {
+	if r.Build == "" {
+		return false
+	}
+
+	b, err := opts.Provider.BuildGet(opts.App, r.Build)
+	if err != nil {
+		return false
+	}
+
+	return b.Development
+}
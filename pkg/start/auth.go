@@ -0,0 +1,126 @@
+package start
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/pkg/errors"
+)
+
+// registryAuth is a registry login in the format of builder.Options.Auth
+type registryAuth struct {
+	Username string
+	Password string
+}
+
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// dockerConfigAuth reads the registry logins of the local docker config so
+// that external builds can pull private base images, entries kept by a
+// credential helper are fetched from it
+func dockerConfigAuth(pw *prefix.Writer) (map[string]registryAuth, error) {
+	auth := map[string]registryAuth{}
+
+	dir := os.Getenv("DOCKER_CONFIG")
+
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return auth, nil
+		}
+
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return auth, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var c dockerConfig
+
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("could not read docker config: %s", err))
+	}
+
+	helpers := map[string]string{}
+
+	for host, a := range c.Auths {
+		switch {
+		case a.Auth != "":
+			dec, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, errors.WithStack(fmt.Errorf("invalid docker config auth for %s", host))
+			}
+
+			parts := strings.SplitN(string(dec), ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.WithStack(fmt.Errorf("invalid docker config auth for %s", host))
+			}
+
+			auth[host] = registryAuth{Username: parts[0], Password: parts[1]}
+		case a.Username != "":
+			auth[host] = registryAuth{Username: a.Username, Password: a.Password}
+		case c.CredsStore != "":
+			helpers[host] = c.CredsStore
+		}
+	}
+
+	for host, helper := range c.CredHelpers {
+		helpers[host] = helper
+	}
+
+	for host, helper := range helpers {
+		a, err := credentialHelper(helper, host)
+		if err != nil {
+			pw.Writef("build", "could not get credentials for <dir>%s</dir> from docker-credential-%s: %s\n", host, helper, err)
+			continue
+		}
+
+		// identity tokens can not be used to log in with a password
+		if a.Username == "<token>" {
+			continue
+		}
+
+		auth[host] = a
+	}
+
+	return auth, nil
+}
+
+// credentialHelper gets the login for a registry from a docker credential helper
+func credentialHelper(helper, host string) (registryAuth, error) {
+	var buf bytes.Buffer
+
+	if err := Exec.Stream(&buf, strings.NewReader(host), fmt.Sprintf("docker-credential-%s", helper), "get"); err != nil {
+		return registryAuth{}, errors.WithStack(err)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &creds); err != nil {
+		return registryAuth{}, errors.WithStack(err)
+	}
+
+	return registryAuth{Username: creds.Username, Password: creds.Secret}, nil
+}
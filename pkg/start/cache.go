@@ -0,0 +1,90 @@
+package start
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+)
+
+var (
+	reCacheClassicHit  = regexp.MustCompile(`(?:^|: ) ---> Using cache$`)
+	reCacheClassicStep = regexp.MustCompile(`(?:^|: )Step \d+/\d+ : (\w+)`)
+	reCacheKitHit      = regexp.MustCompile(`^(.*)#(\d+) CACHED$`)
+	reCacheKitStep     = regexp.MustCompile(`^(.*)#(\d+) \[(?:[\w.-]+ )?\d+/\d+\] (\w+)`)
+)
+
+// cacheStats counts the steps of an external build and how many of them came
+// from the layer cache by reading the output of docker build, both that of
+// the classic builder and the plain progress of buildkit. it is best effort
+// as the output format is not an api
+type cacheStats struct {
+	cached  map[string]bool
+	hits    int
+	lock    sync.Mutex
+	partial []byte
+	run     int
+	steps   map[string]bool
+}
+
+func newCacheStats() *cacheStats {
+	return &cacheStats{cached: map[string]bool{}, steps: map[string]bool{}}
+}
+
+func (s *cacheStats) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.partial = append(s.partial, p...)
+
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		s.line(string(bytes.TrimRight(s.partial[:i], "\r")))
+
+		s.partial = s.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// line counts a line of output, buildkit numbers its steps per build so the
+// steps of concurrent builds are told apart by the prefix of their lines
+func (s *cacheStats) line(line string) {
+	if m := reCacheClassicStep.FindStringSubmatch(line); m != nil {
+		// a base image is pulled rather than built
+		if m[1] != "FROM" {
+			s.run++
+		}
+
+		return
+	}
+
+	if reCacheClassicHit.MatchString(line) {
+		s.hits++
+		return
+	}
+
+	if m := reCacheKitStep.FindStringSubmatch(line); m != nil {
+		if m[3] != "FROM" {
+			s.steps[m[1]+m[2]] = true
+		}
+
+		return
+	}
+
+	if m := reCacheKitHit.FindStringSubmatch(line); m != nil && s.steps[m[1]+m[2]] {
+		s.cached[m[1]+m[2]] = true
+	}
+}
+
+// counts returns the number of steps that came from the cache and the number
+// of steps that were run or cached
+func (s *cacheStats) counts() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.hits + len(s.cached), s.run + len(s.steps)
+}
@@ -0,0 +1,58 @@
+package start
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/convox/changes"
+	"github.com/pkg/errors"
+)
+
+// binarySniffLength is how much of a file is checked for a nul byte to tell a
+// binary file from a text file, the same heuristic as git
+const binarySniffLength = 8000
+
+func syncContentFilter(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid sync content filter: %s", err))
+	}
+
+	return re, nil
+}
+
+// contentAdds drops the adds of text files whose contents do not match the
+// sync content filter, binary files and files that can not be read such as
+// directories are always kept
+func (opts Options2) contentAdds(adds []changes.Change) []changes.Change {
+	if opts.contentFilter == nil {
+		return adds
+	}
+
+	kept := []changes.Change{}
+
+	for _, a := range adds {
+		data, err := os.ReadFile(filepath.Join(a.Base, a.Path))
+		if err != nil || binaryContent(data) || opts.contentFilter.Match(data) {
+			kept = append(kept, a)
+		}
+	}
+
+	return kept
+}
+
+// binaryContent returns true when data has a nul byte near its start or is not
+// valid utf-8
+func binaryContent(data []byte) bool {
+	sniff := data
+
+	if len(sniff) > binarySniffLength {
+		sniff = sniff[:binarySniffLength]
+	}
+
+	return bytes.IndexByte(sniff, 0) >= 0 || !utf8.Valid(data)
+}
@@ -0,0 +1,257 @@
+package start
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/engine/pkg/fileutils"
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+	"github.com/pkg/errors"
+)
+
+const contextIndex = "index.json"
+
+// contextEntry is the state of a file of the build context when its tar entry
+// was cached
+type contextEntry struct {
+	Link    string      `json:"link,omitempty"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size"`
+}
+
+// contextTarball returns the build context in dir, without the files matching
+// excludes, as a gzipped tarball like common.Tarball, the tar entry of each file is gzipped on its own and cached
+// between runs so that only files whose size or mtime changed are read and
+// compressed again, the cached members are joined into a multistream gzip
+func contextTarball(dir string, excludes []string) ([]byte, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sym, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(sym, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	ignores, err := dockerignore.ReadAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pm, err := fileutils.NewPatternMatcher(append(ignores, excludes...))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	cache, err := contextCacheDir(sym)
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[string]contextEntry{}
+
+	if data, err := os.ReadFile(filepath.Join(cache, contextIndex)); err == nil {
+		// a corrupt index only costs a full rebuild of the cache
+		if err := json.Unmarshal(data, &index); err != nil {
+			index = map[string]contextEntry{}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	seen := map[string]contextEntry{}
+
+	err = filepath.Walk(sym, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(sym, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if match, err := pm.Matches(rel); err != nil {
+			return err
+		} else if match {
+			// exceptions may still match files under an excluded directory
+			if info.IsDir() && !pm.Exclusions() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		e := contextEntry{Mode: info.Mode(), ModTime: info.ModTime(), Size: info.Size()}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if e.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		member, err := contextMember(cache, path, rel, info, e, index)
+		if err != nil {
+			return err
+		}
+
+		seen[rel] = e
+
+		_, err = buf.Write(member)
+
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	trailer, err := gzipMember(true, func(*tar.Writer) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	buf.Write(trailer)
+
+	// drop the members of files that are gone or excluded now
+	for rel := range index {
+		if _, ok := seen[rel]; !ok {
+			os.Remove(filepath.Join(cache, contextKey(rel)))
+		}
+	}
+
+	data, err = json.Marshal(seen)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(cache, contextIndex), data, 0600); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// contextMember returns the gzipped tar entry of a file, from the cache when
+// the file has not changed since it was cached
+func contextMember(cache, path, rel string, info os.FileInfo, e contextEntry, index map[string]contextEntry) ([]byte, error) {
+	file := filepath.Join(cache, contextKey(rel))
+
+	if ie, ok := index[rel]; ok && ie.Link == e.Link && ie.Mode == e.Mode && ie.ModTime.Equal(e.ModTime) && ie.Size == e.Size {
+		if data, err := os.ReadFile(file); err == nil {
+			return data, nil
+		}
+	}
+
+	member, err := gzipMember(false, func(tw *tar.Writer) error {
+		h, err := tar.FileInfoHeader(info, e.Link)
+		if err != nil {
+			return err
+		}
+
+		h.Name = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			h.Name += "/"
+		}
+
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+
+		_, err = io.Copy(tw, fd)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(file, member, 0600); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return member, nil
+}
+
+// gzipMember returns a gzip member holding the tar entries that fn writes,
+// with the end of archive marker when end is set
+func gzipMember(end bool, fn func(tw *tar.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	if err := fn(tw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	finish := tw.Flush
+
+	if end {
+		finish = tw.Close
+	}
+
+	if err := finish(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// contextCacheDir returns the directory that caches the build context of the
+// app in dir
+func contextCacheDir(dir string) (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	sum := sha256.Sum256([]byte(dir))
+
+	cache := filepath.Join(base, "convox", "context", hex.EncodeToString(sum[:]))
+
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return cache, nil
+}
+
+func contextKey(rel string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+
+	return hex.EncodeToString(sum[:]) + ".gz"
+}
@@ -0,0 +1,88 @@
+package start_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/convox/convox/pkg/start"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextTarball(t *testing.T) {
+	cache := t.TempDir()
+
+	t.Setenv("HOME", cache)
+	t.Setenv("XDG_CACHE_HOME", cache)
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("node_modules\n*.log\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "app.js"), []byte("one"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "old.js"), []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "debug.log"), []byte("log"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "dep.js"), []byte("dep"), 0644))
+
+	data, err := start.ContextTarball(dir, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{
+		".dockerignore": "node_modules\n*.log\n",
+		"Dockerfile":    "FROM httpd\n",
+		"src/":          "",
+		"src/app.js":    "one",
+		"src/old.js":    "old",
+	}, untarContext(t, data))
+
+	// the same size with a new mtime must not be served from the cache
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "app.js"), []byte("two"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "src", "app.js"), time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	require.NoError(t, os.Remove(filepath.Join(dir, "src", "old.js")))
+
+	data, err = start.ContextTarball(dir, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{
+		".dockerignore": "node_modules\n*.log\n",
+		"Dockerfile":    "FROM httpd\n",
+		"src/":          "",
+		"src/app.js":    "two",
+	}, untarContext(t, data))
+
+	// one member for each entry along with the index
+	members, err := filepath.Glob(filepath.Join(cache, "convox", "context", "*", "*"))
+	require.NoError(t, err)
+	require.Len(t, members, 5)
+}
+
+func untarContext(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	tr := tar.NewReader(gz)
+
+	files := map[string]string{}
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		files[h.Name] = string(data)
+	}
+
+	return files
+}
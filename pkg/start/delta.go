@@ -0,0 +1,174 @@
+package start
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/structs"
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+const (
+	deltaBlockSize = 128 * 1024
+	deltaMinSize   = 1024 * 1024
+)
+
+// the container side of delta sync only needs sh, dd and md5sum
+var (
+	deltaApply     = `f=$1; d=$2; for b in "$d"/*; do [ -e "$b" ] || continue; dd if="$b" of="$f" bs=%d seek=$(basename "$b") conv=notrunc 2>/dev/null || exit 1; done; dd if=/dev/null of="$f" bs=1 seek=$3 2>/dev/null; rm -rf "$d"`
+	deltaSignature = `f=$1; [ -f "$f" ] || exit 3; s=$(wc -c < "$f"); echo $s; n=0; while [ $((n*%d)) -lt $s ]; do dd if="$f" bs=%d skip=$n count=1 2>/dev/null | md5sum; n=$((n+1)); done`
+)
+
+// deltaAdds uploads the blocks that changed in large files and returns the
+// adds that still need a full upload
+func (opts Options2) deltaAdds(p structs.Provider, pid, remote string, adds []changes.Change) []changes.Change {
+	var full []changes.Change
+
+	for _, add := range adds {
+		local := filepath.Join(add.Base, add.Path)
+
+		if stat, err := os.Stat(local); err != nil || stat.Size() < deltaMinSize {
+			full = append(full, add)
+			continue
+		}
+
+		if err := opts.uploadDelta(p, pid, remoteJoin(remote, add.Path), local); err != nil {
+			full = append(full, add)
+		}
+	}
+
+	return full
+}
+
+// uploadDelta compares the file at local with the file at remote block by
+// block and only uploads the blocks that differ
+func (opts Options2) uploadDelta(p structs.Provider, pid, remote, local string) error {
+	if reWindowsPath.MatchString(remote) {
+		return errors.WithStack(fmt.Errorf("delta sync is not supported on windows containers"))
+	}
+
+	size, sums, err := opts.deltaSignature(p, pid, remote)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.Open(local)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer fd.Close()
+
+	dir := remoteJoin(common.CoalesceString(opts.RemoteTempDir, "/tmp"), fmt.Sprintf(".convox-delta-%d", time.Now().UnixNano()))
+
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+	block := make([]byte, deltaBlockSize)
+	changed := 0
+	total := int64(0)
+
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(fd, block)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return errors.WithStack(err)
+		}
+
+		total += int64(n)
+
+		if i < len(sums) && fmt.Sprintf("%x", md5.Sum(block[:n])) == sums[i] {
+			continue
+		}
+
+		changed++
+
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%s/%d", dir, i), Mode: 0600, Size: int64(n), ModTime: time.Now()}); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if _, err := tw.Write(block[:n]); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if changed == 0 && total == size {
+		return nil
+	}
+
+	// nothing to gain over a full upload
+	if int64(changed)*deltaBlockSize >= total {
+		return errors.WithStack(fmt.Errorf("delta is not smaller than the file"))
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := p.FilesUpload(opts.App, pid, &buf, opts.transferOptions()); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var out bytes.Buffer
+
+	cmd := shellquote.Join("sh", "-c", fmt.Sprintf(deltaApply, deltaBlockSize), "sh", remote, dir, strconv.FormatInt(total, 10))
+
+	code, err := p.ProcessExec(opts.App, pid, cmd, &out, opts.execOptions())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if code != 0 {
+		return errors.WithStack(fmt.Errorf("delta apply exited with %d: %s", code, strings.TrimSpace(out.String())))
+	}
+
+	return nil
+}
+
+// deltaSignature returns the size and the md5 of each block of a remote file
+func (opts Options2) deltaSignature(p structs.Provider, pid, remote string) (int64, []string, error) {
+	var out bytes.Buffer
+
+	cmd := shellquote.Join("sh", "-c", fmt.Sprintf(deltaSignature, deltaBlockSize, deltaBlockSize), "sh", remote)
+
+	code, err := p.ProcessExec(opts.App, pid, cmd, &out, opts.execOptions())
+	if err != nil {
+		return 0, nil, errors.WithStack(err)
+	}
+	if code != 0 {
+		return 0, nil, errors.WithStack(fmt.Errorf("delta signature exited with %d", code))
+	}
+
+	s := bufio.NewScanner(&out)
+
+	if !s.Scan() {
+		return 0, nil, errors.WithStack(fmt.Errorf("empty delta signature"))
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(s.Text()), 10, 64)
+	if err != nil {
+		return 0, nil, errors.WithStack(err)
+	}
+
+	var sums []string
+
+	for s.Scan() {
+		if fs := strings.Fields(s.Text()); len(fs) > 0 {
+			sums = append(sums, fs[0])
+		}
+	}
+
+	return size, sums, nil
+}
@@ -0,0 +1,109 @@
+package start_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/convox/convox/pkg/options"
+	"github.com/convox/convox/pkg/start"
+	"github.com/convox/convox/pkg/structs"
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadDelta(t *testing.T) {
+	tests := []struct {
+		Name    string
+		TempDir bool
+	}{
+		{Name: "default"},
+		{Name: "temp dir", TempDir: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			block := 128 * 1024
+
+			remote := filepath.Join(dir, "remote.db")
+			local := filepath.Join(dir, "local.db")
+
+			data := bytes.Repeat([]byte("a"), block*10)
+			require.NoError(t, os.WriteFile(remote, data, 0644))
+
+			changed := append([]byte{}, data[:block*9+100]...)
+			copy(changed[block*2:], bytes.Repeat([]byte("b"), 10))
+			require.NoError(t, os.WriteFile(local, changed, 0644))
+
+			p := &structs.MockProvider{}
+
+			var uploaded []string
+
+			// run the container side of the transfer locally
+			p.On("ProcessExec", "app1", "pid1", mock.Anything, mock.Anything, structs.ProcessExecOptions{}).Return(0, nil).Run(func(args mock.Arguments) {
+				parts, err := shellquote.Split(args.String(2))
+				require.NoError(t, err)
+
+				cmd := exec.Command(parts[0], parts[1:]...)
+				cmd.Stdout = args.Get(3).(io.Writer)
+				require.NoError(t, cmd.Run())
+			})
+			fo := structs.FileTransferOptions{}
+			tmp := "/tmp"
+
+			if tt.TempDir {
+				tmp = filepath.Join(dir, "tmp")
+				fo.TempDir = options.String(tmp)
+			}
+
+			p.On("FilesUpload", "app1", "pid1", mock.Anything, fo).Return(nil).Run(func(args mock.Arguments) {
+				tr := tar.NewReader(args.Get(2).(io.Reader))
+
+				for {
+					h, err := tr.Next()
+					if err != nil {
+						break
+					}
+
+					require.True(t, strings.HasPrefix(h.Name, tmp+"/.convox-delta-"), h.Name)
+
+					uploaded = append(uploaded, filepath.Base(h.Name))
+
+					require.NoError(t, os.MkdirAll(filepath.Dir(h.Name), 0755))
+
+					fd, err := os.Create(h.Name)
+					require.NoError(t, err)
+
+					_, err = io.Copy(fd, tr)
+					require.NoError(t, err)
+					require.NoError(t, fd.Close())
+				}
+			})
+
+			opts := start.Options2{App: "app1"}
+
+			if tt.TempDir {
+				opts.RemoteTempDir = tmp
+			}
+
+			err := start.UploadDelta(opts, p, "pid1", remote, local)
+			require.NoError(t, err)
+
+			require.Equal(t, []string{"2", "9"}, uploaded)
+
+			synced, err := os.ReadFile(remote)
+			require.NoError(t, err)
+			require.Equal(t, changed, synced)
+
+			p.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package start
+
+import (
+	"fmt"
+
+	"github.com/convox/convox/pkg/manifest"
+	"github.com/pkg/errors"
+)
+
+// develop fills in the options that were not passed to start from the develop
+// block of the manifest, values passed for a service replace those of the
+// manifest for that service only
+func (opts Options2) develop(d manifest.Develop) (Options2, error) {
+	if opts.LogLineRegex == "" && d.LogLineRegex != "" {
+		re, err := logLineRegex(d.LogLineRegex)
+		if err != nil {
+			return opts, err
+		}
+
+		opts.LogLineRegex = d.LogLineRegex
+		opts.logLine = re
+	}
+
+	for service, wd := range d.RemoteWorkdir {
+		if _, ok := opts.RemoteWorkdir[service]; !ok && !remoteIsAbs(wd) {
+			return opts, errors.WithStack(fmt.Errorf("remote workdir for %s must be absolute: %s", service, wd))
+		}
+	}
+
+	opts.PostSyncExec = mergeServiceValues(d.PostSync, opts.PostSyncExec)
+	opts.RemoteWorkdir = mergeServiceValues(d.RemoteWorkdir, opts.RemoteWorkdir)
+	opts.SyncContainer = mergeServiceValues(d.SyncContainer, opts.SyncContainer)
+	opts.SyncIgnoreExtensions = mergeServiceLists(d.SyncIgnoreExtensions, opts.SyncIgnoreExtensions)
+
+	if d.NoSyncHidden {
+		opts.NoSyncHidden = true
+	}
+
+	if opts.SyncMirrorExclude == nil {
+		opts.SyncMirrorExclude = d.SyncMirrorExclude
+	}
+
+	return opts, nil
+}
+
+// mergeServiceValues returns defaults with values laid over them
+func mergeServiceValues(defaults, values map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return values
+	}
+
+	merged := map[string]string{}
+
+	for service, v := range defaults {
+		merged[service] = v
+	}
+
+	for service, v := range values {
+		merged[service] = v
+	}
+
+	return merged
+}
+
+// mergeServiceLists returns defaults with the lists of values laid over them
+func mergeServiceLists(defaults, values map[string][]string) map[string][]string {
+	if len(defaults) == 0 {
+		return values
+	}
+
+	merged := map[string][]string{}
+
+	for service, v := range defaults {
+		merged[service] = v
+	}
+
+	for service, v := range values {
+		merged[service] = v
+	}
+
+	return merged
+}
@@ -0,0 +1,87 @@
+package start
+
+import (
+	"context"
+	"time"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/structs"
+)
+
+// Event is a structured notification sent on Options2.Events for editor
+// integrations, the channel must be read for as long as start is running
+type Event struct {
+	Action string
+	Data   map[string]string
+	Error  string
+	Status string
+	Time   time.Time
+}
+
+// syncEvents sends a sync:file event for each file of a batch uploaded to a
+// process, with the error of the upload if it failed
+func (opts Options2) syncEvents(ctx context.Context, service, pid, remote string, adds []changes.Change, err error) {
+	if opts.Events == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, add := range adds {
+		e := Event{
+			Action: "sync:file",
+			Data: map[string]string{
+				"path":    add.Path,
+				"process": pid,
+				"remote":  remoteJoin(remote, add.Path),
+				"service": service,
+			},
+			Status: "success",
+			Time:   now,
+		}
+
+		if err != nil {
+			e.Error = err.Error()
+			e.Status = "error"
+		}
+
+		select {
+		case opts.Events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// buildEvent sends a build event with the start time and duration of a build,
+// with the error of the build if it failed
+func (opts Options2) buildEvent(ctx context.Context, b *structs.Build, started time.Time, duration time.Duration, err error) {
+	if opts.Events == nil {
+		return
+	}
+
+	e := Event{
+		Action: "build",
+		Data: map[string]string{
+			"duration": duration.String(),
+			"started":  started.UTC().Format(time.RFC3339),
+		},
+		Status: "success",
+		Time:   time.Now(),
+	}
+
+	if b != nil {
+		e.Data["build"] = b.Id
+		e.Data["release"] = b.Release
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+		e.Status = "error"
+	}
+
+	select {
+	case opts.Events <- e:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,137 @@
+package start
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/convox/convox/pkg/options"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+	"github.com/pkg/errors"
+)
+
+// exportSync writes the watched sources as they are in the first process of
+// each service to a local tarball, with the entries of each service under its
+// name, so that they can be compared with the local files
+func (opts Options2) exportSync(ctx context.Context, pw *prefix.Writer, file string) {
+	sources := opts.watchers.sources()
+
+	if len(sources) == 0 {
+		pw.Writef("convox", "export: nothing is being synced\n")
+		return
+	}
+
+	if file == "" {
+		file = fmt.Sprintf("%s-sync-%s.tar", opts.App, time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	fd, err := os.Create(file)
+	if err != nil {
+		pw.Writef("convox", "export error: %s\n", err)
+		return
+	}
+	defer fd.Close()
+
+	tw := tar.NewWriter(fd)
+
+	services := []string{}
+
+	for service := range sources {
+		services = append(services, service)
+	}
+
+	sort.Strings(services)
+
+	files := 0
+
+	for _, service := range services {
+		// FilesDownload only reaches the main container
+		if c := opts.SyncContainer[service]; c != "" {
+			pw.Writef("convox", "export: skipping <service>%s</service>, files can not be read from its %s container\n", service, c)
+			continue
+		}
+
+		pss, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{Service: options.String(service)})
+		if err != nil {
+			pw.Writef("convox", "export error: <service>%s</service>: %s\n", service, err)
+			continue
+		}
+
+		pss = opts.releaseProcesses(pss)
+
+		if len(pss) == 0 {
+			pw.Writef("convox", "export: skipping <service>%s</service>, it has no running processes\n", service)
+			continue
+		}
+
+		pid := pss[0].Id
+		wds := map[string]string{}
+
+		for _, bs := range sources[service] {
+			remote := opts.remotePath(ctx, pw, service, pid, bs, wds)
+
+			n, err := opts.exportSource(ctx, tw, service, pid, remote)
+			if err != nil {
+				pw.Writef("convox", "export error: <dir>%s</dir> on <service>%s</service>: %s\n", remote, service, err)
+			}
+
+			files += n
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		pw.Writef("convox", "export error: %s\n", err)
+		return
+	}
+
+	pw.Writef("convox", "export: wrote %d files to <dir>%s</dir>\n", files, file)
+}
+
+// exportSource copies a path downloaded from a process into tw under the
+// service name and returns the number of files copied
+func (opts Options2) exportSource(ctx context.Context, tw *tar.Writer, service, pid, remote string) (int, error) {
+	r, err := opts.Provider.WithContext(ctx).FilesDownload(opts.App, pid, remote)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	tr := tar.NewReader(r)
+
+	files := 0
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, errors.WithStack(err)
+		}
+
+		name := path.Join(service, h.Name)
+
+		if h.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+
+		h.Name = name
+
+		if err := tw.WriteHeader(h); err != nil {
+			return files, errors.WithStack(err)
+		}
+
+		if _, err := io.Copy(tw, tr); err != nil {
+			return files, errors.WithStack(err)
+		}
+
+		if h.Typeflag == tar.TypeReg {
+			files++
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package start
+
+var AtomicSaves = atomicSaves
+
+var BuildSources = buildSources
+
+func CacheCounts(output string) (int, int) {
+	s := newCacheStats()
+	s.Write([]byte(output))
+	return s.counts()
+}
+
+var BuildSummary = Options2.buildSummary
+
+var ContextTarball = contextTarball
+
+var CrashHint = crashHint
+
+var CrashSampleInterval = &crashSampleInterval
+
+var OutsideRoot = outsideRoot
+
+var ReclaimInterval = &reclaimInterval
+
+var RemoteJoin = remoteJoin
+
+var RestartPollInterval = &restartPollInterval
+
+var RemoteWithin = remoteWithin
+
+var UploadDelta = Options2.uploadDelta
+
+var FormatJSONLog = formatJSONLog
+
+var WriteSources = writeSources
+
+var WriteArchive = writeArchive
+
+var DockerConfigAuth = dockerConfigAuth
+
+type RegistryAuth = registryAuth
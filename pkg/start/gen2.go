@@ -4,17 +4,22 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 
@@ -25,37 +30,207 @@ import (
 	"github.com/convox/convox/pkg/options"
 	"github.com/convox/convox/pkg/prefix"
 	"github.com/convox/convox/pkg/structs"
+	"github.com/docker/engine/pkg/fileutils"
+	shellquote "github.com/kballard/go-shellquote"
 	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
 )
 
 const (
 	ScannerStartSize = 4096
 	ScannerMaxSize   = 20 * 1024 * 1024
+
+	dockerNoSync   = "convox:nosync"
+	syncBufferSize = 256 * 1024
+	xattrRecord    = "SCHILY.xattr."
+)
+
+// errMaxDuration is the cause of the shutdown once MaxDuration has elapsed
+var errMaxDuration = errors.New("maximum duration reached")
+
+var vcsDirs = []string{".git", ".hg", ".svn"}
+
+var (
+	ObjectStoreAttempts = 4
+	ObjectStoreBackoff  = 2 * time.Second
 )
 
 var (
-	reAppLog       = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})T(\d{2}:\d{2}:\d{2})Z ([^/]+)/([^/]+)/([^ ]+) (.*)$`)
+	reAppLog       = regexp.MustCompile(`^(?P<timestamp>\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z) (?P<kind>[^/]+)/(?P<service>[^/]+)/(?P<process>[^ ]+) (?P<message>.*)$`)
 	reDockerOption = regexp.MustCompile("--([a-z]+)")
+	reNotSupported = regexp.MustCompile(`(?i)not (implemented|supported|available)|response status (404|405|501)|404 page not found`)
+	reWindowsPath  = regexp.MustCompile(`^[a-zA-Z]:[\\/]`)
 )
 
 type Options2 struct {
-	App      string
-	Build    bool
-	Cache    bool
-	External bool
-	Manifest string
-	Provider structs.Provider
-	Services []string
-	Sync     bool
-	Test     bool
+	AdditionalApps         []string
+	App                    string
+	Attach                 bool
+	AutoReclaim            int
+	Build                  bool
+	BuildConcurrency       int
+	BuildSecrets           []string
+	BuildSource            string
+	BuildSummary           bool
+	Cache                  bool
+	CompressLogs           bool
+	CompressSync           bool
+	ContextCache           bool
+	ContinueOnBuildFailure bool
+	Dir                    string
+	DeltaSync              bool
+	Events                 chan<- Event
+	External               bool
+	Heartbeat              time.Duration
+	InitialSync            int
+	Input                  io.Reader
+	LogBuffer              int
+	LogLineRegex           string
+	LogMaxLinesPerSecond   int
+	LogsSince              time.Duration
+	Manifest               string
+	MaxDuration            time.Duration
+	NoLogs                 bool
+	NoSyncDeletes          bool
+	NoSyncHidden           bool
+	Outputs                map[string]io.Writer
+	ParseJSONLogs          bool
+	PlanOutput             string
+	PostSyncExec           map[string]string
+	PreStart               string
+	PreserveMtime          bool
+	Production             bool
+	Provider               structs.Provider
+	RawLogs                bool
+	ReconnectAfter         time.Duration
+	ReleaseEnv             bool
+	RemoteTempDir          string
+	RemoteWorkdir          map[string]string
+	RunningTimeout         time.Duration
+	Services               []string
+	SparseSync             bool
+	Status                 *Status
+	StrictEnv              bool
+	StrictSync             bool
+	Sync                   bool
+	SyncAllowedRoots       []string
+	SyncBufferSize         int
+	SyncContainer          map[string]string
+	SyncContentFilter      string
+	SyncFilter             func(change changes.Change) bool
+	SyncIgnoreExtensions   map[string][]string
+	SyncMirror             bool
+	SyncMirrorExclude      []string
+	SyncRoot               map[string]string
+	SyncStartupDelay       time.Duration
+	SyncTrigger            string
+	SyncXattrs             bool
+	Test                   bool
+	Tracer                 Tracer
+	Verbose                bool
+	VerifySync             bool
+	WatchGit               bool
+
+	activity      *activity
+	batches       *batches
+	connection    *connection
+	container     string
+	contentFilter *regexp.Regexp
+	control       *control
+	fallbacks     *sync.Map
+	generation    string
+	initial       *initialSync
+	logApp        string
+	logLimit      *logLimiter
+	logLine       *regexp.Regexp
+	logRing       *logRing
+	manifest      *manifest.Manifest
+	postSync      *postSync
+	promoted      *atomic.Value
+	readOnly      *sync.Map
+	replaced      string
+	uncompressed  *atomic.Bool
+	watchers      *watchers
+	xattrless     *atomic.Bool
+}
+
+type activity struct {
+	lock     sync.Mutex
+	lastPoll time.Time
+	lastSeen time.Time
+}
+
+func (a *activity) poll() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.lastPoll = time.Now()
+}
+
+func (a *activity) seen() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.lastSeen = time.Now()
+}
+
+func (a *activity) times() (time.Time, time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.lastPoll, a.lastSeen
+}
+
+// control holds the sync pause state toggled by commands on Options2.Input
+type control struct {
+	lock   sync.Mutex
+	paused bool
+	resync int
+}
+
+func (c *control) pause() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.paused = true
+}
+
+func (c *control) resume(full bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.paused = false
+
+	if full {
+		c.resync++
+	}
+}
+
+// resync asks for a full resync without changing whether sync is paused
+func (c *control) resyncAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.resync++
+}
+
+func (c *control) state() (bool, int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.paused, c.resync
 }
 
 type buildSource struct {
-	Local  string
-	Remote string
+	Extract bool
+	Local   string
+	Remote  string
+
+	// Workdir is the final working directory of the Dockerfile stage, used in
+	// place of the one of a process that can not run pwd
+	Workdir string
 }
 
-func (*Start) Start2(ctx context.Context, w io.Writer, opts Options2) error {
+func (*Start) Start2(ctx context.Context, w io.Writer, opts Options2) (err error) {
 	select {
 	case <-ctx.Done():
 		return nil
@@ -66,53 +241,241 @@ func (*Start) Start2(ctx context.Context, w io.Writer, opts Options2) error {
 		return errors.WithStack(fmt.Errorf("app required"))
 	}
 
+	if opts.MaxDuration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.MaxDuration, errMaxDuration)
+		defer cancel()
+	}
+
+	opts.activity = &activity{lastSeen: time.Now()}
+	opts.batches = &batches{slots: map[string]chan struct{}{}}
+	opts.connection = &connection{}
+	opts.control = &control{}
+	opts.fallbacks = &sync.Map{}
+	opts.uncompressed = &atomic.Bool{}
+	opts.logLine = reAppLog
+	opts.postSync = &postSync{pending: map[string]bool{}, running: map[string]bool{}}
+	opts.promoted = &atomic.Value{}
+	opts.readOnly = &sync.Map{}
+	opts.watchers = &watchers{paths: map[string]*watcher{}}
+	opts.xattrless = &atomic.Bool{}
+
+	if opts.InitialSync > 0 {
+		opts.initial = newInitialSync(opts.InitialSync)
+	}
+
+	if opts.LogBuffer > 0 {
+		opts.logRing = newLogRing(opts.LogBuffer)
+	}
+
+	if opts.LogMaxLinesPerSecond > 0 {
+		opts.logLimit = &logLimiter{max: opts.LogMaxLinesPerSecond, windows: map[string]*logWindow{}}
+	}
+
+	if opts.RemoteTempDir != "" && !remoteIsAbs(opts.RemoteTempDir) {
+		return errors.WithStack(fmt.Errorf("remote temp dir must be absolute: %s", opts.RemoteTempDir))
+	}
+
+	for _, root := range opts.SyncAllowedRoots {
+		if !remoteIsAbs(root) {
+			return errors.WithStack(fmt.Errorf("sync allowed root must be absolute: %s", root))
+		}
+	}
+
+	for service, wd := range opts.RemoteWorkdir {
+		if !remoteIsAbs(wd) {
+			return errors.WithStack(fmt.Errorf("remote workdir for %s must be absolute: %s", service, wd))
+		}
+	}
+
+	for service, root := range opts.SyncRoot {
+		if !remoteIsAbs(root) {
+			return errors.WithStack(fmt.Errorf("sync root for %s must be absolute: %s", service, root))
+		}
+	}
+
+	if opts.Production {
+		if err := opts.validateProduction(); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.BuildSecrets) > 0 && opts.External {
+		return errors.WithStack(fmt.Errorf("build secrets require a build on the rack"))
+	}
+
+	if opts.BuildSource != "" {
+		if !opts.External {
+			return errors.WithStack(fmt.Errorf("build source requires an external build"))
+		}
+
+		if _, _, err := opts.buildSource(); err != nil {
+			return err
+		}
+	}
+
+	if opts.LogLineRegex != "" {
+		re, err := logLineRegex(opts.LogLineRegex)
+		if err != nil {
+			return err
+		}
+
+		opts.logLine = re
+	}
+
+	if opts.SyncContentFilter != "" {
+		re, err := syncContentFilter(opts.SyncContentFilter)
+		if err != nil {
+			return err
+		}
+
+		opts.contentFilter = re
+	}
+
 	a, err := opts.Provider.AppGet(opts.App)
+	if opts.Attach {
+		if err := opts.attach(a, err); err != nil {
+			return err
+		}
+	}
+
 	if err != nil {
-		if _, err := opts.Provider.AppCreate(opts.App, structs.AppCreateOptions{Generation: options.String("2")}); err != nil {
+		ca, err := opts.Provider.AppCreate(opts.App, structs.AppCreateOptions{Generation: options.String("2")})
+		if err != nil {
 			return errors.WithStack(err)
 		}
+
+		if ca != nil {
+			opts.generation = ca.Generation
+		}
 	} else {
 		if a.Generation != "2" && a.Generation != "3" {
 			return errors.WithStack(fmt.Errorf("invalid generation: %s", a.Generation))
 		}
+
+		opts.generation = a.Generation
+		opts.replaced = a.Release
+	}
+
+	root, err := opts.root()
+	if err != nil {
+		return err
 	}
 
-	data, err := os.ReadFile(common.CoalesceString(opts.Manifest, "convox.yml"))
+	mf := common.CoalesceString(opts.Manifest, "convox.yml")
+
+	data, err := os.ReadFile(rootPath(root, mf))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	env, err := common.AppEnvironment(opts.Provider, opts.App)
+	data, err = manifestData(mf, data)
+	if err != nil {
+		return err
+	}
+
+	env, err := opts.environment(a)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if opts.StrictEnv {
+		if missing := manifest.MissingEnv(data, env); len(missing) > 0 {
+			return errors.WithStack(fmt.Errorf("%s references undefined environment variables: %s", mf, strings.Join(missing, ", ")))
+		}
+	}
+
 	m, err := manifest.Load(data, env)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	if err := m.Validate(); err != nil {
+		return validationError(mf, data, err)
+	}
+
+	opts.manifest = m
+
+	opts, err = opts.develop(m.Develop)
+	if err != nil {
 		return err
 	}
 
 	services := map[string]bool{}
 
-	if opts.Services == nil {
+	switch {
+	case opts.Services == nil && strings.TrimSpace(os.Getenv("CONVOX_SERVICES")) != "":
+		for _, s := range strings.Split(os.Getenv("CONVOX_SERVICES"), ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+
+			if !isServicePattern(s) {
+				if _, err := m.Service(s); err != nil {
+					return errors.WithStack(fmt.Errorf("invalid CONVOX_SERVICES: %s", err))
+				}
+			}
+
+			if err := expandServices(m, []string{s}, services); err != nil {
+				return errors.WithStack(fmt.Errorf("invalid CONVOX_SERVICES: %s", err))
+			}
+		}
+	case opts.Services == nil && len(m.Develop.Services) > 0:
+		for _, s := range m.Develop.Services {
+			services[s] = true
+		}
+	case opts.Services == nil:
 		for i := range m.Services {
 			services[m.Services[i].Name] = true
 		}
-	} else {
-		for _, s := range opts.Services {
-			services[s] = true
+	default:
+		if err := expandServices(m, opts.Services, services); err != nil {
+			return err
+		}
+	}
+
+	apps, err := opts.additionalServices()
+	if err != nil {
+		return err
+	}
+
+	prefixes := map[string]bool{}
+
+	for s := range services {
+		prefixes[s] = true
+	}
+
+	for app, ss := range apps {
+		for s := range ss {
+			prefixes[fmt.Sprintf("%s/%s", app, s)] = true
+		}
+	}
+
+	pw := prefixWriter(w, prefixes, opts.Outputs)
+
+	// show what the services logged before a failure that may have scrolled off
+	defer func() {
+		if err != nil {
+			opts.logRing.dump(&pw, opts.failingServices(err))
+		}
+	}()
+
+	if opts.PreStart != "" {
+		if err := opts.preStart(&pw); err != nil {
+			return err
 		}
 	}
 
-	pw := prefixWriter(w, services)
+	if opts.PlanOutput != "" {
+		if err := opts.writePlan(w, &pw, m, root, services); err != nil {
+			return err
+		}
+	}
 
 	if opts.Build {
 		bopts := structs.BuildCreateOptions{
-			Development: options.Bool(true),
+			Development: options.Bool(!opts.Production),
 			External:    options.Bool(opts.External),
 		}
 
@@ -120,59 +483,113 @@ func (*Start) Start2(ctx context.Context, w io.Writer, opts Options2) error {
 			bopts.Manifest = options.String(opts.Manifest)
 		}
 
-		b, err := opts.buildCreate(ctx, &pw, bopts)
+		bargs, err := buildArgs(m, env)
 		if err != nil {
 			return err
 		}
 
+		if len(bargs) > 0 {
+			bopts.BuildArgs = &bargs
+		}
+
+		if len(opts.BuildSecrets) > 0 {
+			secrets, err := opts.buildSecrets()
+			if err != nil {
+				return err
+			}
+
+			bopts.Secrets = &secrets
+		}
+
+		if opts.Production {
+			return opts.production(ctx, &pw, bopts)
+		}
+
+		if err := opts.buildPromote(ctx, &pw, bopts); err != nil {
+			if !opts.ContinueOnBuildFailure {
+				return err
+			}
+
+			pw.Writef("build", "<error>build failed: %s</error>\n", err)
+
+			go opts.rebuildOnChange(ctx, &pw, bopts)
+		}
+
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
 		}
+	}
 
-		popts := structs.ReleasePromoteOptions{
-			Development: options.Bool(true),
-			Force:       options.Bool(true),
-			Idle:        options.Bool(false),
-			Min:         options.Int(0),
-			Timeout:     options.Int(300),
-		}
+	if opts.Test {
+		ran, err := opts.runTests(ctx, &pw, m, services)
+		if ran {
+			if serr := opts.stop(&pw); serr != nil {
+				return serr
+			}
 
-		if err := opts.Provider.ReleasePromote(opts.App, b.Release, popts); err != nil {
-			return errors.WithStack(err)
+			return err
 		}
 	}
 
-	go opts.streamLogs(ctx, pw, services)
+	if !opts.NoLogs {
+		go opts.streamLogs(ctx, pw, services)
+		opts.streamAdditionalLogs(ctx, pw, apps)
+	}
 
 	errch := make(chan error)
 	defer close(errch)
 
 	go handleErrors(ctx, pw, errch)
 
-	wd, err := os.Getwd()
-	if err != nil {
-		return errors.WithStack(err)
+	if opts.Input != nil {
+		go opts.readCommands(ctx, &pw)
 	}
 
+	go opts.superviseConnection(ctx, &pw)
+
 	for i := range m.Services {
 		if !services[m.Services[i].Name] {
 			continue
 		}
 
-		if m.Services[i].Build.Path != "" {
-			go opts.watchChanges(ctx, pw, m, m.Services[i].Name, wd, errch)
+		if m.Services[i].Build.Path != "" || len(volumeSources(&m.Services[i], root)) > 0 {
+			go opts.watchChanges(ctx, pw, m, m.Services[i].Name, root, errch)
 		}
 	}
 
-	if err := common.WaitForAppRunningContext(ctx, opts.Provider, opts.App); err != nil {
+	if err := opts.waitForRunning(ctx, &pw); err != nil {
 		return err
 	}
 
+	opts.checkRelease(&pw)
+
+	if opts.AutoReclaim > 0 {
+		go opts.reclaimRelease(ctx, &pw)
+	}
+
+	if opts.Heartbeat > 0 {
+		go opts.heartbeat(ctx, &pw)
+	}
+
 	<-ctx.Done()
 
-	a, err = opts.Provider.AppGet(opts.App)
+	if context.Cause(ctx) == errMaxDuration {
+		pw.Writef("convox", "maximum duration of %s reached\n", opts.MaxDuration)
+	}
+
+	// an attached session leaves the app in development mode to attach to again
+	if opts.Attach {
+		return nil
+	}
+
+	return opts.stop(&pw)
+}
+
+// stop takes the app out of development mode
+func (opts Options2) stop(pw *prefix.Writer) error {
+	a, err := opts.Provider.AppGet(opts.App)
 	if err != nil {
 		return nil
 	}
@@ -180,12 +597,7 @@ func (*Start) Start2(ctx context.Context, w io.Writer, opts Options2) error {
 	pw.Writef("convox", "stopping\n")
 
 	if a.Release != "" {
-		popts := structs.ReleasePromoteOptions{
-			Development: options.Bool(false),
-			Force:       options.Bool(true),
-		}
-
-		if err := opts.Provider.ReleasePromote(opts.App, a.Release, popts); err != nil {
+		if err := opts.Provider.ReleasePromote(opts.App, a.Release, opts.promoteOptions(false)); err != nil {
 			return errors.WithStack(err)
 		}
 	}
@@ -193,365 +605,1920 @@ func (*Start) Start2(ctx context.Context, w io.Writer, opts Options2) error {
 	return nil
 }
 
-func (opts Options2) buildCreate(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) (*structs.Build, error) {
-	if opts.External {
-		return opts.buildCreateExternal(ctx, pw, bopts)
+// manifestData strips a utf-8 byte order mark from the manifest and rejects
+// content that can not be yaml, such as a binary or utf-16 file, which would
+// otherwise fail with an opaque parse error
+func manifestData(file string, data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return nil, errors.WithStack(fmt.Errorf("%s doesn't look like valid YAML, it has binary or non utf-8 content, check that it is the right file and that it is saved as utf-8", file))
 	}
 
-	pw.Writef("build", "uploading source\n")
+	return data, nil
+}
 
-	data, err := common.Tarball(".")
-	if err != nil {
-		return nil, errors.WithStack(err)
+// validationError prefixes each manifest validation error with the file and
+// the line and column of the field it refers to
+func validationError(file string, data []byte, err error) error {
+	ves, ok := err.(manifest.ValidationErrors)
+	if !ok {
+		return err
 	}
 
-	o, err := opts.Provider.ObjectStore(opts.App, "", bytes.NewReader(data), structs.ObjectStoreOptions{})
-	if err != nil {
-		return nil, errors.WithStack(err)
+	located, lerr := ves.Locate(data)
+	if lerr != nil {
+		return err
 	}
 
-	pw.Writef("build", "starting build\n")
+	messages := []string{}
 
-	b, err := opts.Provider.BuildCreate(opts.App, o.Url, bopts)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	for _, e := range located {
+		if ve, ok := e.(manifest.ValidationError); ok && ve.Line > 0 {
+			messages = append(messages, fmt.Sprintf("%s:%d:%d: %s", file, ve.Line, ve.Column, ve.Message))
+		} else {
+			messages = append(messages, fmt.Sprintf("%s: %s", file, e))
+		}
 	}
 
-	logs, err := opts.Provider.BuildLogs(opts.App, b.Id, structs.LogsOptions{})
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+	return fmt.Errorf("validation errors:\n%s", strings.Join(messages, "\n"))
+}
+
+func isServicePattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
 
-	bo := pw.Writer("build")
+// expandServices adds the services named by each selector to services, a
+// selector may be a glob such as worker-* which must match at least one service
+func expandServices(m *manifest.Manifest, selectors []string, services map[string]bool) error {
+	for _, sel := range selectors {
+		if !isServicePattern(sel) {
+			services[sel] = true
+			continue
+		}
 
-	go io.Copy(bo, logs)
+		matched := false
 
-	if err := opts.waitForBuild(ctx, b.Id); err != nil {
-		return nil, errors.WithStack(err)
+		for i := range m.Services {
+			ok, err := path.Match(sel, m.Services[i].Name)
+			if err != nil {
+				return errors.WithStack(fmt.Errorf("invalid service pattern %s: %s", sel, err))
+			}
+
+			if ok {
+				services[m.Services[i].Name] = true
+				matched = true
+			}
+		}
+
+		if !matched {
+			return errors.WithStack(fmt.Errorf("no services match %s", sel))
+		}
 	}
 
-	b, err = opts.Provider.BuildGet(opts.App, b.Id)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	return nil
+}
+
+// environment returns the env used to interpolate the manifest, which is the
+// env of the latest release unless ReleaseEnv asks for the promoted release
+func (opts Options2) environment(a *structs.App) (structs.Environment, error) {
+	if opts.ReleaseEnv && a != nil && a.Release != "" {
+		return common.ReleaseEnvironment(opts.Provider, opts.App, a.Release)
 	}
 
-	return b, nil
+	return common.AppEnvironment(opts.Provider, opts.App)
 }
 
-func (opts Options2) buildCreateExternal(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) (*structs.Build, error) {
-	dir := "."
+// buildArgs returns the build args of the services in the manifest, which Load
+// has already interpolated, with bare names taking their value from env
+func buildArgs(m *manifest.Manifest, env map[string]string) ([]string, error) {
+	args := []string{}
+	values := map[string]string{}
 
-	s, err := opts.Provider.SystemGet()
-	if err != nil {
-		return nil, err
-	}
+	for i := range m.Services {
+		for _, a := range m.Services[i].Build.Args {
+			kv := strings.SplitN(a, "=", 2)
 
-	bopts.External = options.Bool(true)
+			if len(kv) == 1 {
+				v, ok := env[kv[0]]
+				if !ok {
+					continue
+				}
 
-	b, err := opts.Provider.BuildCreate(opts.App, "", bopts)
-	if err != nil {
-		return nil, err
+				kv = append(kv, v)
+			}
+
+			if v, ok := values[kv[0]]; ok {
+				if v != kv[1] {
+					return nil, errors.WithStack(fmt.Errorf("conflicting values for build arg %s", kv[0]))
+				}
+
+				continue
+			}
+
+			values[kv[0]] = kv[1]
+			args = append(args, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+		}
 	}
 
-	manifest := common.CoalesceString(opts.Manifest, "convox.yml")
+	return args, nil
+}
 
-	data, err := os.ReadFile(filepath.Join(dir, manifest))
+func (opts Options2) buildPromote(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) error {
+	b, err := opts.buildCreate(ctx, pw, bopts)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if _, err := opts.Provider.BuildUpdate(b.App, b.Id, structs.BuildUpdateOptions{Manifest: options.String(string(data))}); err != nil {
-		return nil, err
+	select {
+	case <-ctx.Done():
+		return nil
+	default:
 	}
 
-	u, err := url.Parse(b.Repository)
+	_, span := opts.startSpan(ctx, "start.promote", map[string]interface{}{"app": opts.App, "release": b.Release})
+
+	err = opts.Provider.ReleasePromote(opts.App, b.Release, opts.promoteOptions(true))
+
+	span.End(err)
+
 	if err != nil {
-		return nil, err
+		return errors.WithStack(err)
 	}
 
-	auth := ""
-	repo := fmt.Sprintf("%s%s", u.Host, u.Path)
+	opts.promoted.Store(b.Release)
 
-	if pass, ok := u.User.Password(); ok {
-		auth = fmt.Sprintf(`{%q: { "Username": %q, "Password": %q } }`, repo, u.User.Username(), pass)
+	return nil
+}
+
+// promoteOptions returns the options used to promote a development release, or
+// to restore the previous release on the way out when development is false
+func (opts Options2) promoteOptions(development bool) structs.ReleasePromoteOptions {
+	popts := structs.ReleasePromoteOptions{
+		Development: options.Bool(development),
+		Force:       options.Bool(true),
+	}
+
+	if !development {
+		return popts
+	}
+
+	popts.Idle = options.Bool(false)
+	popts.Min = options.Int(0)
+	popts.Timeout = options.Int(300)
+
+	// generation 3 racks use max as the rollout surge, replace processes in
+	// place so that syncs and logs only go to the new release
+	if opts.generation == "3" {
+		popts.Max = options.Int(100)
+	}
+
+	return popts
+}
+
+// rebuildOnChange retries a failed build each time the source tree changes
+// until a build succeeds and is promoted
+func (opts Options2) rebuildOnChange(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) {
+	dir := common.CoalesceString(opts.Dir, ".")
+
+	ignores, err := buildIgnores(dir, "")
+	if err != nil {
+		pw.Writef("build", "<error>error: %s</error>\n", err)
+		return
+	}
+
+	ignores = append(opts.vcsIgnores(), ignores...)
+
+	cch := make(chan changes.Change, 1)
+
+	go changes.Watch(dir, cch, changes.WatchOptions{
+		Ignores: ignores,
+	})
+
+	pw.Writef("build", "waiting for changes to retry build\n")
+
+	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
+
+	changed := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cch:
+			changed = true
+		case <-tick.C:
+			if !changed {
+				continue
+			}
+
+			changed = false
+
+			if err := opts.buildPromote(ctx, pw, bopts); err != nil {
+				pw.Writef("build", "<error>build failed: %s</error>\n", err)
+				pw.Writef("build", "waiting for changes to retry build\n")
+				continue
+			}
+
+			return
+		}
+	}
+}
+
+// buildCreate runs a build on the rack, or locally when External is set, and
+// reports how long it took
+func (opts Options2) buildCreate(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) (*structs.Build, error) {
+	started := time.Now()
+
+	build := opts.buildCreateRack
+
+	if opts.External {
+		build = opts.buildCreateExternal
+	}
+
+	sctx, span := opts.startSpan(ctx, "start.build", map[string]interface{}{"app": opts.App, "external": opts.External})
+
+	b, err := build(sctx, pw, bopts)
+
+	if b != nil {
+		span.SetAttribute("build", b.Id)
+		span.SetAttribute("release", b.Release)
+	}
+
+	span.End(err)
+
+	duration := time.Since(started).Round(time.Second)
+
+	if err == nil {
+		pw.Writef("build", "completed in %s\n", duration)
+	}
+
+	opts.buildEvent(ctx, b, started, duration, err)
+
+	return b, err
+}
+
+func (opts Options2) buildCreateRack(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) (*structs.Build, error) {
+	pw.Writef("build", "uploading source\n")
+
+	data, err := opts.tarball()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	o, err := opts.objectStore(ctx, pw, data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pw.Writef("build", "starting build\n")
+
+	b, err := opts.Provider.BuildCreate(opts.App, o.Url, bopts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	logs, err := opts.Provider.BuildLogs(opts.App, b.Id, structs.LogsOptions{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if bopts.Secrets != nil {
+		rw := common.NewRedactWriter(pw.Writer("build"), secretValues(*bopts.Secrets))
+
+		go func() {
+			io.Copy(rw, logs)
+			rw.Flush()
+		}()
+	} else {
+		go io.Copy(pw.Writer("build"), logs)
+	}
+
+	if err := opts.waitForBuild(ctx, b.Id); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	b, err = opts.Provider.BuildGet(opts.App, b.Id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if opts.BuildSummary {
+		pw.Writef("build", "image summary is only available for external builds\n")
+	}
+
+	return b, nil
+}
+
+// tarball returns the build source without the files of build secrets, using
+// the cached entries of the files that did not change since the last run when
+// ContextCache is set
+func (opts Options2) tarball() ([]byte, error) {
+	dir := common.CoalesceString(opts.Dir, ".")
+
+	excludes, err := opts.secretExcludes()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ContextCache {
+		return contextTarball(dir, excludes)
+	}
+
+	return common.TarballExcluding(dir, excludes)
+}
+
+// objectStore uploads the build source, retrying transient failures with backoff
+func (opts Options2) objectStore(ctx context.Context, pw *prefix.Writer, data []byte) (*structs.Object, error) {
+	r := bytes.NewReader(data)
+	backoff := ObjectStoreBackoff
+
+	for attempt := 1; ; attempt++ {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		o, err := opts.Provider.ObjectStore(opts.App, "", r, structs.ObjectStoreOptions{})
+		if err == nil {
+			return o, nil
+		}
+
+		if attempt >= ObjectStoreAttempts {
+			return nil, err
+		}
+
+		pw.Writef("build", "upload failed: %s, retrying in %s (%d/%d)\n", err, backoff, attempt, ObjectStoreAttempts-1)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (opts Options2) buildCreateExternal(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) (*structs.Build, error) {
+	source, dir, err := opts.buildSource()
+	if err != nil {
+		return nil, err
+	}
+
+	// the builder resolves each service's build path against the source dir
+	if dir != "" && opts.manifest != nil {
+		if err := validateBuildPaths(opts.manifest, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := opts.Provider.SystemGet()
+	if err != nil {
+		return nil, err
+	}
+
+	bopts.External = options.Bool(true)
+
+	b, err := opts.Provider.BuildCreate(opts.App, "", bopts)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := common.CoalesceString(opts.Manifest, "convox.yml")
+
+	// the builder records the manifest of a remote source once it has it
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, manifest))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := opts.Provider.BuildUpdate(b.App, b.Id, structs.BuildUpdateOptions{Manifest: options.String(string(data))}); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := url.Parse(b.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := fmt.Sprintf("%s%s", u.Host, u.Path)
+
+	ras, err := dockerConfigAuth(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	if pass, ok := u.User.Password(); ok {
+		ras[repo] = registryAuth{Username: u.User.Username(), Password: pass}
+	}
+
+	auth, err := json.Marshal(ras)
+	if err != nil {
+		return nil, err
 	}
 
 	bbopts := builder.Options{
 		App:         b.App,
-		Auth:        auth,
+		Auth:        string(auth),
 		Cache:       opts.Cache,
-		Development: true,
+		Concurrency: opts.BuildConcurrency,
+		Development: !opts.Production,
 		Id:          b.Id,
 		Manifest:    manifest,
 		Push:        repo,
 		Rack:        s.Name,
-		Source:      fmt.Sprintf("dir://%s", dir),
+		Source:      source,
 		Terminal:    true,
 	}
 
+	if bopts.BuildArgs != nil {
+		bbopts.BuildArgs = *bopts.BuildArgs
+	}
+
+	// concurrent builds cannot share the terminal so their output is prefixed
+	if opts.BuildConcurrency > 1 {
+		bbopts.Output = pw.Writer("build")
+		bbopts.Terminal = false
+	}
+
+	var stats *cacheStats
+
+	// the output of a cached build is read to count the steps that were cached
+	if opts.Cache {
+		stats = newCacheStats()
+
+		if bbopts.Output == nil {
+			bbopts.Output = pw.Writer("build")
+		}
+
+		bbopts.Output = io.MultiWriter(bbopts.Output, stats)
+		bbopts.Terminal = false
+	}
+
 	bb, err := builder.New(opts.Provider, bbopts, &builder.Docker{})
 	if err != nil {
 		return nil, err
 	}
 
+	// the builder runs docker through Exec like the rest of start
+	bb.Exec = Exec
+
 	if err := bb.Execute(); err != nil {
 		return nil, err
 	}
 
+	if stats != nil {
+		if hits, steps := stats.counts(); steps > 0 {
+			pw.Writef("build", "%d/%d steps cached\n", hits, steps)
+		}
+	}
+
+	if opts.BuildSummary {
+		opts.buildSummary(pw, s.Name, b)
+	}
+
 	ropts := structs.ReleaseCreateOptions{
 		Build:       options.String(b.Id),
 		Description: options.String(b.Description),
 	}
 
-	r, err := opts.Provider.ReleaseCreate(b.App, ropts)
-	if err != nil {
-		return nil, err
-	}
+	r, err := opts.Provider.ReleaseCreate(b.App, ropts)
+	if err != nil {
+		return nil, err
+	}
+
+	uopts := structs.BuildUpdateOptions{
+		Release: options.String(r.Id),
+	}
+
+	bu, err := opts.Provider.BuildUpdate(b.App, b.Id, uopts)
+	if err != nil {
+		return nil, err
+	}
+
+	return bu, nil
+}
+
+// buildSource returns the source of an external build along with the local
+// directory it is read from, which is empty for a git source
+func (opts Options2) buildSource() (string, string, error) {
+	if opts.BuildSource == "" {
+		dir := "."
+
+		if opts.Dir != "" {
+			root, err := opts.root()
+			if err != nil {
+				return "", "", err
+			}
+
+			dir = root
+		}
+
+		return fmt.Sprintf("dir://%s", dir), dir, nil
+	}
+
+	u, err := url.Parse(opts.BuildSource)
+	if err != nil || u.Host == "" && u.Path == "" {
+		return "", "", errors.WithStack(fmt.Errorf("invalid build source: %s", opts.BuildSource))
+	}
+
+	switch u.Scheme {
+	case "git", "git+file", "git+https", "git+ssh":
+		return opts.BuildSource, "", nil
+	default:
+		return "", "", errors.WithStack(fmt.Errorf("invalid build source: %s, must be a git url such as git+https://github.com/org/app.git#main", opts.BuildSource))
+	}
+}
+
+// validateBuildPaths checks that the build path of each service built from
+// source is a directory in dir
+func validateBuildPaths(m *manifest.Manifest, dir string) error {
+	for _, s := range m.Services {
+		if s.Image != "" {
+			continue
+		}
+
+		stat, err := os.Stat(filepath.Join(dir, s.Build.Path))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("build path for service %s does not exist: %s", s.Name, s.Build.Path)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !stat.IsDir() {
+			return fmt.Errorf("build path for service %s is not a directory: %s", s.Name, s.Build.Path)
+		}
+	}
+
+	return nil
+}
+
+func (opts Options2) handleAdds(ctx context.Context, pid, remote string, adds []changes.Change) error {
+	adds = opts.contentAdds(adds)
+
+	if len(adds) == 0 {
+		return nil
+	}
+
+	p := opts.Provider.WithContext(ctx)
+
+	// remotePath resolves relative destinations once per process
+	if !remoteIsAbs(remote) {
+		return errors.WithStack(fmt.Errorf("%s: remote path %s is not absolute", pid, remote))
+	}
+
+	if err := opts.allowedAdds(remote, adds); err != nil {
+		return err
+	}
+
+	adds = sortedAdds(adds)
+
+	if err := opts.uploadAdds(ctx, p, pid, remote, adds); err != nil {
+		return err
+	}
+
+	if opts.PreserveMtime {
+		return opts.touchFiles(p, pid, remote, adds)
+	}
+
+	return nil
+}
+
+// allowedAdds rejects a sync that would write a file outside of the allowed
+// roots, guarding against a remote path resolved to somewhere like /etc
+func (opts Options2) allowedAdds(remote string, adds []changes.Change) error {
+	if len(opts.SyncAllowedRoots) == 0 {
+		return nil
+	}
+
+	for _, a := range adds {
+		dst := remoteJoin(remote, a.Path)
+
+		if !opts.allowedRemote(dst) {
+			return errors.WithStack(fmt.Errorf("sync to %s is outside of the allowed roots: %s", dst, strings.Join(opts.SyncAllowedRoots, ", ")))
+		}
+	}
+
+	return nil
+}
+
+// allowedRemote reports whether the remote path p is one of the allowed roots
+// or inside of one
+func (opts Options2) allowedRemote(p string) bool {
+	for _, root := range opts.SyncAllowedRoots {
+		if remoteWithin(root, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (opts Options2) uploadAdds(ctx context.Context, p structs.Provider, pid, remote string, adds []changes.Change) error {
+	if opts.DeltaSync {
+		if adds = opts.deltaAdds(p, pid, remote, adds); len(adds) == 0 {
+			return nil
+		}
+	}
+
+	if opts.SyncXattrs && !opts.xattrless.Load() {
+		err := opts.uploadCompressed(ctx, p, pid, remote, adds, true)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		// the container tar may not know --xattrs, retry without them
+		if err := opts.uploadCompressed(ctx, p, pid, remote, adds, false); err != nil {
+			return err
+		}
+
+		opts.xattrless.Store(true)
+
+		return nil
+	}
+
+	return opts.uploadCompressed(ctx, p, pid, remote, adds, false)
+}
+
+func (opts Options2) uploadCompressed(ctx context.Context, p structs.Provider, pid, remote string, adds []changes.Change, xattrs bool) error {
+	if opts.CompressSync && !opts.uncompressed.Load() {
+		err := opts.uploadTar(ctx, p, pid, remote, adds, true, xattrs)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		// the container may not be able to extract gzip, retry without it
+		if err := opts.uploadTar(ctx, p, pid, remote, adds, false, xattrs); err != nil {
+			return err
+		}
+
+		opts.uncompressed.Store(true)
+
+		return nil
+	}
+
+	return opts.uploadTar(ctx, p, pid, remote, adds, false, xattrs)
+}
+
+func (opts Options2) uploadTar(ctx context.Context, p structs.Provider, pid, remote string, adds []changes.Change, compress, xattrs bool) error {
+	rp, wp := io.Pipe()
+
+	fopts := opts.transferOptions()
+
+	flags := []string{}
+
+	if compress {
+		flags = append(flags, "-z")
+	}
+
+	// gnu tar only restores the user namespace unless asked for all of them
+	if xattrs {
+		flags = append(flags, "--xattrs", "--xattrs-include=*")
+	}
+
+	if len(flags) > 0 {
+		fopts.TarExtraFlags = options.String(strings.Join(flags, ","))
+	}
+
+	ch := make(chan error, 1)
+
+	go func() {
+		err := p.FilesUpload(opts.App, pid, rp, fopts)
+		rp.Close()
+		ch <- err
+	}()
+
+	// abort the tar stream if the watcher is torn down mid upload
+	stop := context.AfterFunc(ctx, func() {
+		wp.CloseWithError(ctx.Err())
+	})
+	defer stop()
+
+	size := opts.SyncBufferSize
+
+	if size <= 0 {
+		size = syncBufferSize
+	}
+
+	if err := writeArchive(wp, remote, adds, compress, opts.SparseSync, xattrs, size); err != nil {
+		wp.CloseWithError(err)
+
+		if uerr := <-ch; uerr != nil {
+			return uerr
+		}
+
+		return err
+	}
+
+	if err := wp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return <-ch
+}
+
+func writeArchive(w io.Writer, remote string, adds []changes.Change, compress, sparse, xattrs bool, size int) error {
+	if !compress {
+		return writeTar(w, remote, adds, sparse, xattrs, size)
+	}
+
+	gz := gzip.NewWriter(w)
+
+	if err := writeTar(gz, remote, adds, sparse, xattrs, size); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// remotePath resolves a relative sync destination against the working directory
+// of the process, caching the result per process, when the process can not run
+// pwd the working directory of the Dockerfile is used instead, or the root as
+// docker does when the Dockerfile and its image have none, a service with a
+// sync root is synced into it instead
+func (opts Options2) remotePath(ctx context.Context, pw *prefix.Writer, service, pid string, bs buildSource, wds map[string]string) string {
+	if p, ok := opts.syncRootPath(service, bs); ok {
+		return p
+	}
+
+	remote := bs.Remote
+
+	if remoteIsAbs(remote) {
+		return remote
+	}
+
+	if wd, ok := opts.RemoteWorkdir[service]; ok {
+		return remoteJoin(wd, remote)
+	}
+
+	wd, ok := wds[pid]
+	if !ok {
+		var buf bytes.Buffer
+
+		if _, err := opts.Provider.WithContext(ctx).ProcessExec(opts.App, pid, "pwd", &buf, opts.execOptions()); err != nil {
+			// without a WORKDIR in the Dockerfile or a working directory read
+			// from the image, docker would have copied relative to the root
+			if bs.Workdir == "" {
+				if _, logged := opts.fallbacks.LoadOrStore(pid, true); !logged {
+					pw.Writef("convox", "sync: could not run pwd in <service>%s</service> %s and the Dockerfile has no working directory, using <dir>/</dir>, set --remote-workdir if this is wrong: %s\n", service, pid, err)
+				}
+
+				wds[pid] = "/"
+
+				return remoteJoin("/", remote)
+			}
+
+			if _, logged := opts.fallbacks.LoadOrStore(pid, true); !logged {
+				pw.Writef("convox", "sync: could not run pwd in <service>%s</service> %s, using the Dockerfile working directory <dir>%s</dir>: %s\n", service, pid, bs.Workdir, err)
+			}
+
+			wds[pid] = bs.Workdir
+
+			return remoteJoin(bs.Workdir, remote)
+		}
+
+		wd = common.CoalesceString(strings.TrimSpace(buf.String()), bs.Workdir, "/")
+		wds[pid] = wd
+	}
+
+	return remoteJoin(wd, remote)
+}
+
+func (opts Options2) handleRemoves(ctx context.Context, pid string, removes []changes.Change) error {
+	if len(removes) == 0 {
+		return nil
+	}
+
+	return opts.deleteFiles(ctx, pid, changes.Files(removes))
+}
+
+// deleteFiles removes files from a process, FilesDelete only reaches the main
+// container so files in another container are removed with rm
+func (opts Options2) deleteFiles(ctx context.Context, pid string, files []string) error {
+	p := opts.Provider.WithContext(ctx)
+
+	if opts.container == "" {
+		return p.FilesDelete(opts.App, pid, files)
+	}
+
+	var buf bytes.Buffer
+
+	code, err := p.ProcessExec(opts.App, pid, shellquote.Join(append([]string{"rm", "-f"}, files...)...), &buf, opts.execOptions())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if code != 0 {
+		return errors.WithStack(fmt.Errorf("rm exited with %d: %s", code, strings.TrimSpace(buf.String())))
+	}
+
+	return nil
+}
+
+// syncTarget returns the options used to sync to the processes of a service,
+// targeting the container named for it in SyncContainer
+func (opts Options2) syncTarget(service string) Options2 {
+	opts.container = opts.SyncContainer[service]
+	return opts
+}
+
+func (opts Options2) execOptions() structs.ProcessExecOptions {
+	eo := structs.ProcessExecOptions{}
+
+	if opts.container != "" {
+		eo.Container = options.String(opts.container)
+	}
+
+	return eo
+}
+
+func (opts Options2) transferOptions() structs.FileTransferOptions {
+	fo := structs.FileTransferOptions{}
+
+	if opts.container != "" {
+		fo.Container = options.String(opts.container)
+	}
+
+	if opts.RemoteTempDir != "" {
+		fo.TempDir = options.String(opts.RemoteTempDir)
+	}
+
+	return fo
+}
+
+// writeTar buffers the archive so that the headers and contents of small
+// files are batched into fewer writes to the upload stream, with sparse set
+// the holes in large files are left out of the archive and with xattrs the
+// extended attributes of each file are carried in its pax records
+func writeTar(w io.Writer, remote string, adds []changes.Change, sparse, xattrs bool, size int) error {
+	bw := bufio.NewWriterSize(w, size)
+	tw := tar.NewWriter(bw)
+	buf := make([]byte, size)
+
+	for _, add := range adds {
+		local := filepath.Join(add.Base, add.Path)
+
+		stat, err := os.Stat(local)
+		if err != nil {
+			// skip transient files like '.git/.COMMIT_EDITMSG.swp'
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return errors.WithStack(err)
+		}
+
+		fd, err := os.Open(local)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		defer fd.Close() // skipcq
+
+		var records map[string]string
+
+		if xattrs {
+			records = fileXattrs(local)
+		}
+
+		if sparse {
+			segs, err := sparseSegments(fd, stat.Size())
+			if err != nil {
+				return err
+			}
+
+			if segs != nil {
+				ok, err := writeSparse(bw, tw, remoteJoin(remote, add.Path), stat, fd, segs, records, buf)
+				if err != nil {
+					return err
+				}
+
+				if ok {
+					fd.Close()
+					continue
+				}
+			}
+		}
+
+		h := &tar.Header{
+			Name:    remoteJoin(remote, add.Path),
+			Mode:    int64(stat.Mode()),
+			Size:    stat.Size(),
+			ModTime: stat.ModTime(),
+		}
+
+		if xattrs {
+			h.PAXRecords = records
+		}
+
+		if err := tw.WriteHeader(h); err != nil {
+			return errors.WithStack(err)
+		}
+
+		// hide WriteTo so that the copy goes through buf
+		if _, err := io.CopyBuffer(tw, struct{ io.Reader }{fd}, buf); err != nil {
+			return errors.WithStack(err)
+		}
+
+		fd.Close()
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (opts Options2) stopProcess(pid string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	opts.Provider.ProcessStop(opts.App, pid)
+}
+
+func (opts Options2) streamLogs(ctx context.Context, pw prefix.Writer, services map[string]bool) {
+	since := 1 * time.Second
+
+	var seen map[string]bool
+
+	if opts.LogsSince > since {
+		since = opts.LogsSince
+		seen = map[string]bool{}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			lopts := structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(since)}
+
+			if opts.CompressLogs {
+				lopts.Compress = options.Bool(true)
+			}
+
+			logs, err := opts.Provider.AppLogs(opts.App, lopts)
+			if err == nil {
+				opts.activity.poll()
+
+				if opts.writeLogs(ctx, pw, logReader(logs), services, seen) > 0 {
+					opts.activity.seen()
+				}
+
+				// the backlog only overlaps the poll that follows it
+				if since == 1*time.Second {
+					seen = nil
+				}
+
+				since = 1 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				time.Sleep(1 * time.Second)
+			}
+		}
+	}
+}
+
+// readCommands reads sync commands from Options2.Input, one per line
+func (opts Options2) readCommands(ctx context.Context, pw *prefix.Writer) {
+	s := bufio.NewScanner(opts.Input)
+
+	for s.Scan() {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		args := strings.Fields(s.Text())
+
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "export":
+			file := ""
+
+			if len(args) > 1 {
+				file = strings.Join(args[1:], " ")
+			}
+
+			opts.exportSync(ctx, pw, file)
+		case "pause":
+			opts.control.pause()
+			pw.Writef("convox", "sync paused, changes will be held until resume\n")
+		case "resume":
+			opts.control.resume(false)
+			pw.Writef("convox", "sync resumed\n")
+		case "resync":
+			opts.control.resume(true)
+			pw.Writef("convox", "sync resumed with full resync\n")
+		default:
+			pw.Writef("convox", "unknown command: %s (valid commands: export, pause, resume, resync)\n", strings.TrimSpace(s.Text()))
+		}
+	}
+}
+
+// heartbeat periodically reports that start is still watching when there has
+// been no log output or sync activity for a full interval
+func (opts Options2) heartbeat(ctx context.Context, pw *prefix.Writer) {
+	tick := time.NewTicker(opts.Heartbeat)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			poll, seen := opts.activity.times()
+
+			if time.Since(seen) < opts.Heartbeat {
+				continue
+			}
+
+			if poll.IsZero() {
+				pw.Writef("convox", "watching (no changes)\n")
+			} else {
+				pw.Writef("convox", "watching (no changes, logs polled at %s)\n", poll.Format("15:04:05"))
+			}
+		}
+	}
+}
+
+func (opts Options2) waitForBuild(ctx context.Context, id string) error {
+	tick := time.Tick(1 * time.Second)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			b, err := opts.Provider.BuildGet(opts.App, id)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			switch b.Status {
+			case "created", "running":
+				break
+			case "complete":
+				return nil
+			case "failed":
+				return errors.WithStack(fmt.Errorf("build failed"))
+			default:
+				return errors.WithStack(fmt.Errorf("unknown build status: %s", b.Status))
+			}
+		}
+	}
+}
+
+// writeSources prints a table of the sources found for a service so that
+// the Dockerfile interpretation can be checked before editing
+func writeSources(pw *prefix.Writer, service, root string, bss []buildSource, notes map[int]string) {
+	if len(bss) == 0 {
+		pw.Writef("convox", "sync: no sources found for <service>%s</service>\n", service)
+		return
+	}
+
+	locals := make([]string, len(bss))
+	width := 0
+
+	for i, bs := range bss {
+		locals[i] = relativePath(root, bs.Local)
+
+		if len(locals[i]) > width {
+			width = len(locals[i])
+		}
+	}
+
+	pw.Writef("convox", "sync: sources for <service>%s</service>\n", service)
+
+	for i, bs := range bss {
+		line := fmt.Sprintf("sync:   <dir>%s</dir>%s -> <dir>%s</dir>", locals[i], strings.Repeat(" ", width-len(locals[i])), common.CoalesceString(bs.Remote, "."))
+
+		if note, ok := notes[i]; ok {
+			line += fmt.Sprintf(" (%s)", note)
+		}
+
+		pw.Writef("convox", "%s\n", line)
+	}
+}
+
+func (opts Options2) watchChanges(ctx context.Context, pw prefix.Writer, m *manifest.Manifest, service, root string, ch chan error) {
+	bss, err := buildSources(&pw, m, root, service)
+	if err != nil {
+		ch <- fmt.Errorf("sync error: %s", err)
+		return
+	}
+
+	// a source renamed without updating the Dockerfile would otherwise only
+	// show as a sync waiting for it to be created
+	if s, err := m.Service(service); err == nil {
+		warnMissingSources(&pw, s, root, bss)
+	}
+
+	ignores, err := buildIgnores(root, service)
+	if err != nil {
+		ch <- fmt.Errorf("sync error: %s", err)
+		return
+	}
+
+	// hidden patterns go first so that exceptions in .dockerignore still win
+	if opts.NoSyncHidden {
+		ignores = append([]string{"**/.*", "**/.*/**"}, ignores...)
+	}
+
+	ignores = append(opts.vcsIgnores(), ignores...)
+
+	// extensions go last so that they apply even to files .dockerignore keeps
+	ignores = append(ignores, extensionIgnores(opts.SyncIgnoreExtensions[service])...)
+
+	var watch []buildSource
+
+	notes := map[int]string{}
+
+	for i, bs := range bss {
+		if bs.Extract {
+			pw.Writef("convox", "sync: skipping archive <dir>%s</dir> on <service>%s</service>, it is extracted by ADD at build time\n", relativePath(root, bs.Local), service)
+			notes[i] = "skipped, extracted by ADD"
+			continue
+		}
+
+		if outsideRoot(root, bs.Local) {
+			if opts.StrictSync {
+				pw.Writef("convox", "sync: refusing to sync <dir>%s</dir> to <service>%s</service>, it is outside of <dir>%s</dir>\n", bs.Local, service, root)
+				notes[i] = "skipped, outside of app directory"
+				continue
+			}
+
+			pw.Writef("convox", "sync: <dir>%s</dir> on <service>%s</service> is outside of <dir>%s</dir>, check the COPY sources in the Dockerfile\n", bs.Local, service, root)
+			notes[i] = "outside of app directory"
+		}
+
+		watch = append(watch, bs)
+	}
+
+	if opts.Verbose {
+		writeSources(&pw, service, root, bss, notes)
+	}
+
+	if len(watch) == 0 {
+		return
+	}
+
+	supported, err := opts.probeSync(ctx, service)
+	if err != nil {
+		ch <- fmt.Errorf("sync error: %s", err)
+		return
+	}
+
+	if !supported {
+		pw.Writef("convox", "sync: not supported by this rack, disabling sync for <service>%s</service>\n", service)
+		return
+	}
+
+	for _, bs := range watch {
+		w, ok := opts.watchers.add(bs, ignores, service)
+		if !ok {
+			pw.Writef("convox", "sync: <dir>%s</dir> is already watched, syncing it to <service>%s</service> as well\n", relativePath(root, bs.Local), service)
+			continue
+		}
+
+		go opts.watchPath(ctx, pw, w, root, bs, ignores, ch)
+	}
+}
+
+// watchers tracks the sources being watched so that services which share a
+// build context share a single watcher
+type watchers struct {
+	lock  sync.Mutex
+	paths map[string]*watcher
+}
+
+// add registers service for a source and returns a new watcher, or false if
+// the source was already being watched and service has joined its watcher
+func (ws *watchers) add(bs buildSource, ignores []string, service string) (*watcher, bool) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	key := strings.Join(append([]string{bs.Local, bs.Remote}, ignores...), "\x00")
+
+	if w, ok := ws.paths[key]; ok {
+		w.add(service)
+		return w, false
+	}
+
+	w := &watcher{services: []string{service}, source: bs}
+
+	ws.paths[key] = w
+
+	return w, true
+}
+
+// sources returns the watched sources synced to each service
+func (ws *watchers) sources() map[string][]buildSource {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	sources := map[string][]buildSource{}
+
+	for _, w := range ws.paths {
+		for _, service := range w.list() {
+			sources[service] = append(sources[service], w.source)
+		}
+	}
+
+	for _, bss := range sources {
+		sort.Slice(bss, func(i, j int) bool { return bss[i].Remote < bss[j].Remote })
+	}
+
+	return sources
+}
+
+// watcher holds the services that a watched source is synced to
+type watcher struct {
+	lock     sync.Mutex
+	services []string
+	source   buildSource
+}
+
+func (w *watcher) add(service string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.services = append(w.services, service)
+}
+
+func (w *watcher) list() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return append([]string{}, w.services...)
+}
+
+// probeSync waits for a process of the service to appear and checks that the
+// provider is able to exec into it and accept file uploads
+func (opts Options2) probeSync(ctx context.Context, service string) (bool, error) {
+	opts = opts.syncTarget(service)
+
+	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
+
+	for {
+		pss, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{Service: options.String(service)})
+		if err == nil {
+			pss = opts.releaseProcesses(pss)
+		}
+
+		if err == nil && len(pss) > 0 {
+			pid := pss[0].Id
+
+			var buf bytes.Buffer
+
+			if _, err := opts.Provider.ProcessExec(opts.App, pid, "true", &buf, opts.execOptions()); err != nil && isNotSupported(err) {
+				return false, nil
+			}
+
+			buf.Reset()
+
+			if err := tar.NewWriter(&buf).Close(); err != nil {
+				return false, errors.WithStack(err)
+			}
+
+			if err := opts.Provider.FilesUpload(opts.App, pid, &buf, opts.transferOptions()); err != nil && isNotSupported(err) {
+				return false, nil
+			}
+
+			return true, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case <-tick.C:
+		}
+	}
+}
+
+// restartPollInterval is how often a watcher without changes to sync lists
+// the processes it syncs to notice restarted containers
+var restartPollInterval = 5 * time.Second
+
+func (opts Options2) watchPath(ctx context.Context, pw prefix.Writer, w *watcher, root string, bs buildSource, ignores []string, ch chan error) {
+	cch := make(chan changes.Change, 1)
+
+	service := w.list()[0]
+
+	abs, err := filepath.Abs(bs.Local)
+	if err != nil {
+		ch <- fmt.Errorf("sync error: %s", err)
+		return
+	}
+
+	rel, err := filepath.Rel(root, bs.Local)
+	if err != nil {
+		ch <- fmt.Errorf("sync error: %s", err)
+		return
+	}
+
+	var chgs []changes.Change
+
+	if _, err := os.Stat(abs); os.IsNotExist(err) {
+		opts.Status.watch(w, rel, bs.Remote, false)
+
+		pw.Writef("convox", "waiting for <dir>%s</dir> to be created to sync it to <service>%s</service>\n", rel, service)
+
+		if !waitForPath(ctx, abs) {
+			return
+		}
+
+		// anything created along with the directory predates the watcher
+		chgs, err = existingFiles(abs, ignores)
+		if err != nil {
+			ch <- fmt.Errorf("sync error: %s", err)
+			return
+		}
+	}
+
+	// only a directory can be mirrored, a single file has nothing to delete
+	mirror := false
+
+	if fi, err := os.Stat(abs); err == nil && fi.IsDir() {
+		mirror = true
+	}
+
+	pw.Writef("convox", "starting sync from <dir>%s</dir> to <dir>%s</dir> on <service>%s</service>\n", rel, common.CoalesceString(bs.Remote, "."), service)
+
+	opts.Status.watch(w, rel, bs.Remote, true)
+
+	go changes.Watch(abs, cch, changes.WatchOptions{
+		Ignores: ignores,
+	})
+
+	tick := time.Tick(1000 * time.Millisecond)
+	_, resynced := opts.control.state()
+
+	trigger := syncTrigger(root, opts.SyncTrigger)
+	triggered := modTime(trigger)
+	holding := false
+
+	var known map[string]bool
+	var polled time.Time
+	appeared := map[string]time.Time{}
+	restarted := map[string]bool{}
+	wds := map[string]string{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-cch:
+			chgs = append(chgs, c)
+		case <-tick:
+			paused, resync := opts.control.state()
+
+			// changes are held while the rack is unreachable
+			if paused || opts.connection.isLost() {
+				continue
+			}
+
+			flush := true
+
+			if trigger != "" {
+				if mt := modTime(trigger); !mt.Equal(triggered) {
+					triggered = mt
+				} else {
+					flush = false
+				}
+			}
+
+			if resync != resynced {
+				resynced = resync
+				flush = true
+
+				files, err := existingFiles(abs, ignores)
+				if err != nil {
+					pw.Writef("convox", "sync error: %s\n", err)
+					continue
+				}
+
+				chgs = append(chgs, files...)
+			}
+
+			// without changes the processes are only listed now and then to
+			// notice restarts, a pending resync needs them on every tick
+			if len(chgs) == 0 && len(restarted) == 0 && known != nil && time.Since(polled) < restartPollInterval {
+				continue
+			}
+
+			polled = time.Now()
+
+			pss, owners, err := opts.watcherProcesses(w)
+			if err != nil {
+				opts.connection.failed()
+
+				if len(chgs) > 0 {
+					pw.Writef("convox", "sync error: %s\n", err)
+				}
+				continue
+			}
+
+			opts.connection.ok()
+
+			current := map[string]bool{}
+			now := time.Now()
+
+			for _, ps := range pss {
+				service := owners[ps.Id]
+				opts := opts.syncTarget(service)
+				current[ps.Id] = true
+
+				if _, ok := appeared[ps.Id]; !ok {
+					appeared[ps.Id] = now
+
+					// a process we have not seen before is a restarted or rescheduled
+					// container that only has the files from the build
+					if known != nil {
+						pw.Writef("convox", "restart detected: <service>%s</service> is now running as %s, resyncing <dir>%s</dir>\n", service, ps.Id, rel)
+						restarted[ps.Id] = true
+					} else if (opts.SyncMirror && mirror) || opts.initial != nil {
+						files, err := existingFiles(abs, ignores)
+						if err != nil {
+							pw.Writef("convox", "sync error: %s\n", err)
+							continue
+						}
+
+						remote := opts.remotePath(ctx, &pw, service, ps.Id, bs, wds)
+
+						// files deleted while start was not running are still in the container
+						if opts.SyncMirror && mirror {
+							opts.mirrorSync(ctx, &pw, service, ps.Id, remote, files, ignores)
+						}
+
+						// the container only has the files from the build until it is sent
+						// the whole source
+						if opts.initial != nil {
+							opts.initial.sync(ctx, &pw, opts, w, service, ps.Id, remote, appeared[ps.Id], opts.filterChanges(files))
+						}
+					}
+				}
+
+				// give a new container time to settle before resyncing it
+				if restarted[ps.Id] && now.Sub(appeared[ps.Id]) >= opts.SyncStartupDelay {
+					delete(restarted, ps.Id)
+
+					files, err := existingFiles(abs, ignores)
+					if err != nil {
+						pw.Writef("convox", "sync error: %s\n", err)
+						continue
+					}
+
+					remote := opts.remotePath(ctx, &pw, service, ps.Id, bs, wds)
+
+					if !opts.batches.acquire(ctx, &pw, service) {
+						return
+					}
+
+					adds := opts.filterChanges(files)
+
+					err = opts.handleAdds(ctx, ps.Id, remote, adds)
+					if err != nil {
+						pw.Writef("convox", "sync add error: %s\n", err)
+						opts.readOnlyHint(&pw, service, remote, err)
+					} else {
+						opts.Status.synced(w, service, ps.Id, remote)
+					}
+
+					opts.syncEvents(ctx, service, ps.Id, remote, adds, err)
+
+					if opts.SyncMirror && mirror {
+						opts.mirrorSync(ctx, &pw, service, ps.Id, remote, files, ignores)
+					}
+
+					opts.batches.release(service)
+				}
+			}
+
+			for pid := range wds {
+				if !current[pid] {
+					delete(wds, pid)
+				}
+			}
+
+			opts.Status.prune(w, current)
+
+			for pid := range appeared {
+				if !current[pid] {
+					delete(appeared, pid)
+					delete(restarted, pid)
+				}
+			}
+
+			known = current
+
+			if trigger != "" {
+				chgs = withoutFile(chgs, trigger)
+			}
+
+			chgs = opts.filterChanges(atomicSaves(chgs))
+
+			if len(chgs) == 0 {
+				continue
+			}
+
+			if !flush {
+				if !holding {
+					pw.Writef("convox", "sync: holding changes to <service>%s</service> until <dir>%s</dir> changes\n", strings.Join(w.list(), "</service>, <service>"), opts.SyncTrigger)
+					holding = true
+				}
+				continue
+			}
+
+			holding = false
+
+			adds, removes := changes.Partition(chgs)
+
+			if opts.NoSyncDeletes && len(removes) > 0 {
+				pw.Writef("convox", "sync: keeping %d deleted files in <service>%s</service>\n", len(removes), strings.Join(w.list(), "</service>, <service>"))
+				removes = nil
+			}
+
+			for _, ps := range pss {
+				service := owners[ps.Id]
+				opts := opts.syncTarget(service)
+
+				// a resync is still to come for restarted processes
+				if restarted[ps.Id] {
+					continue
+				}
+
+				if now.Sub(appeared[ps.Id]) < opts.SyncStartupDelay {
+					pw.Writef("convox", "sync: ignoring %d changes to <service>%s</service> while %s starts up\n", len(chgs), service, ps.Id)
+					continue
+				}
+
+				if !opts.batches.acquire(ctx, &pw, service) {
+					return
+				}
+
+				switch {
+				case len(adds) > 3:
+					pw.Writef("convox", "sync: %d files to <dir>%s</dir> on <service>%s</service>\n", len(adds), common.CoalesceString(bs.Remote, "."), service)
+				case len(adds) > 0:
+					for _, a := range adds {
+						pw.Writef("convox", "sync: <dir>%s</dir> to <dir>%s</dir> on <service>%s</service>\n", a.Path, common.CoalesceString(bs.Remote, "."), service)
+					}
+				}
+
+				remote := opts.remotePath(ctx, &pw, service, ps.Id, bs, wds)
 
-	uopts := structs.BuildUpdateOptions{
-		Release: options.String(r.Id),
-	}
+				sctx, span := opts.startSpan(ctx, "start.sync", map[string]interface{}{
+					"bytes":   changesSize(adds),
+					"files":   len(adds),
+					"process": ps.Id,
+					"removes": len(removes),
+					"service": service,
+				})
 
-	bu, err := opts.Provider.BuildUpdate(b.App, b.Id, uopts)
-	if err != nil {
-		return nil, err
-	}
+				err := opts.handleAdds(sctx, ps.Id, remote, adds)
+				if err != nil {
+					pw.Writef("convox", "sync add error: %s\n", err)
+					opts.readOnlyHint(&pw, service, remote, err)
+				} else if len(adds) > 0 {
+					if opts.VerifySync {
+						opts.verifySync(ctx, &pw, service, ps.Id, remote, adds)
+					}
 
-	return bu, nil
-}
+					opts.postSyncExec(ctx, &pw, service, ps.Id)
+				}
 
-func (opts Options2) handleAdds(pid, remote string, adds []changes.Change) error {
-	if len(adds) == 0 {
-		return nil
-	}
+				opts.syncEvents(ctx, service, ps.Id, remote, adds, err)
 
-	if !filepath.IsAbs(remote) {
-		var buf bytes.Buffer
+				switch {
+				case len(removes) > 3:
+					pw.Writef("convox", "remove: %d files from <dir>%s</dir> to <service>%s</service>\n", len(removes), common.CoalesceString(bs.Remote, "."), service)
+				case len(removes) > 0:
+					for _, r := range removes {
+						pw.Writef("convox", "remove: <dir>%s</dir> from <dir>%s</dir> on <service>%s</service>\n", r.Path, common.CoalesceString(bs.Remote, "."), service)
+					}
+				}
 
-		if _, err := opts.Provider.ProcessExec(opts.App, pid, "pwd", &buf, structs.ProcessExecOptions{}); err != nil {
-			return errors.WithStack(fmt.Errorf("%s pwd: %s", pid, err))
-		}
+				if rerr := opts.handleRemoves(sctx, ps.Id, removes); rerr != nil {
+					pw.Writef("convox", "sync remove error: %s\n", rerr)
 
-		wd := strings.TrimSpace(buf.String())
+					if err == nil {
+						err = rerr
+					}
+				}
 
-		remote = filepath.Join(wd, remote)
-	}
+				if err == nil {
+					opts.Status.synced(w, service, ps.Id, remote)
+				}
 
-	rp, wp := io.Pipe()
+				span.End(err)
 
-	ch := make(chan error)
+				opts.batches.release(service)
+			}
 
-	go func() {
-		ch <- opts.Provider.FilesUpload(opts.App, pid, rp, structs.FileTransterOptions{})
-		close(ch)
-	}()
+			opts.activity.seen()
 
-	tw := tar.NewWriter(wp)
+			chgs = []changes.Change{}
+		}
+	}
+}
 
-	for _, add := range adds {
-		local := filepath.Join(add.Base, add.Path)
+// releaseProcesses drops the processes of a release other than the one start
+// promoted, which are on their way out during a rolling promote, the release
+// is read on each call to follow later promotes
+func (opts Options2) releaseProcesses(pss structs.Processes) structs.Processes {
+	release, _ := opts.promoted.Load().(string)
+	if release == "" {
+		return pss
+	}
 
-		stat, err := os.Stat(local)
-		if err != nil {
-			// skip transient files like '.git/.COMMIT_EDITMSG.swp'
-			if os.IsNotExist(err) {
-				continue
-			}
+	rps := structs.Processes{}
 
-			return errors.WithStack(err)
+	for _, ps := range pss {
+		if ps.Release == "" || ps.Release == release {
+			rps = append(rps, ps)
 		}
+	}
 
-		tw.WriteHeader(&tar.Header{
-			Name:    filepath.Join(remote, add.Path),
-			Mode:    int64(stat.Mode()),
-			Size:    stat.Size(),
-			ModTime: stat.ModTime(),
-		})
+	return rps
+}
 
-		fd, err := os.Open(local)
+// watcherProcesses lists the processes of every service synced by a watcher
+// along with the service each of them belongs to
+func (opts Options2) watcherProcesses(w *watcher) (structs.Processes, map[string]string, error) {
+	pss := structs.Processes{}
+	owners := map[string]string{}
+
+	for _, service := range w.list() {
+		sps, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{Service: options.String(service)})
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, nil, err
 		}
 
-		defer fd.Close() // skipcq
+		sps = opts.releaseProcesses(sps)
 
-		if _, err := io.Copy(tw, fd); err != nil {
-			return errors.WithStack(err)
+		for _, ps := range sps {
+			owners[ps.Id] = service
 		}
 
-		fd.Close()
+		pss = append(pss, sps...)
 	}
 
-	if err := tw.Close(); err != nil {
-		return errors.WithStack(err)
+	return pss, owners, nil
+}
+
+// batches lets a single sync batch upload to each service at a time so that
+// the changes of sources synced to the same service are applied in order
+type batches struct {
+	lock  sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// acquire waits for the batch being uploaded to service to finish, it returns
+// false if ctx is done first
+func (b *batches) acquire(ctx context.Context, pw *prefix.Writer, service string) bool {
+	b.lock.Lock()
+
+	slot, ok := b.slots[service]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		b.slots[service] = slot
 	}
 
-	if err := wp.Close(); err != nil {
-		return errors.WithStack(err)
+	b.lock.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return true
+	default:
 	}
 
-	return <-ch
-}
+	pw.Writef("convox", "sync: queueing changes to <service>%s</service> behind the batch being uploaded\n", service)
 
-func (opts Options2) handleRemoves(pid string, removes []changes.Change) error {
-	if len(removes) == 0 {
-		return nil
+	select {
+	case slot <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
 	}
+}
 
-	return opts.Provider.FilesDelete(opts.App, pid, changes.Files(removes))
+func (b *batches) release(service string) {
+	b.lock.Lock()
+	slot := b.slots[service]
+	b.lock.Unlock()
+
+	<-slot
 }
 
-func (opts Options2) stopProcess(pid string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	opts.Provider.ProcessStop(opts.App, pid)
+// postSync tracks the Options2.PostSyncExec commands running on each process
+type postSync struct {
+	lock    sync.Mutex
+	pending map[string]bool
+	running map[string]bool
 }
 
-func (opts Options2) streamLogs(ctx context.Context, pw prefix.Writer, services map[string]bool) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			logs, err := opts.Provider.AppLogs(opts.App, structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)})
-			if err == nil {
-				writeLogs(ctx, pw, logs, services)
-			}
+// postSyncExec runs the post sync command of service on a process, batches
+// that land while it is still running are coalesced into a single rerun
+func (opts Options2) postSyncExec(ctx context.Context, pw *prefix.Writer, service, pid string) {
+	command := opts.PostSyncExec[service]
+	if command == "" {
+		return
+	}
 
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				time.Sleep(1 * time.Second)
-			}
-		}
+	ps := opts.postSync
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if ps.running[pid] {
+		ps.pending[pid] = true
+		return
 	}
-}
 
-func (opts Options2) waitForBuild(ctx context.Context, id string) error {
-	tick := time.Tick(1 * time.Second)
+	ps.running[pid] = true
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-tick:
-			b, err := opts.Provider.BuildGet(opts.App, id)
-			if err != nil {
-				return errors.WithStack(err)
+	go func() {
+		for {
+			pw.Writef("convox", "sync: running <command>%s</command> on <service>%s</service>\n", command, service)
+
+			var buf bytes.Buffer
+
+			code, err := opts.Provider.WithContext(ctx).ProcessExec(opts.App, pid, command, &buf, opts.execOptions())
+			switch {
+			case ctx.Err() != nil:
+			case err != nil:
+				pw.Writef("convox", "sync: post sync command failed on <service>%s</service>: %s\n", service, err)
+			case code != 0:
+				pw.Writef("convox", "sync: post sync command exited with %d on <service>%s</service>: %s\n", code, service, strings.TrimSpace(buf.String()))
 			}
 
-			switch b.Status {
-			case "created", "running":
-				break
-			case "complete":
-				return nil
-			case "failed":
-				return errors.WithStack(fmt.Errorf("build failed"))
-			default:
-				return errors.WithStack(fmt.Errorf("unknown build status: %s", b.Status))
+			ps.lock.Lock()
+
+			if !ps.pending[pid] || ctx.Err() != nil {
+				delete(ps.pending, pid)
+				delete(ps.running, pid)
+				ps.lock.Unlock()
+				return
 			}
+
+			ps.pending[pid] = false
+			ps.lock.Unlock()
 		}
-	}
+	}()
 }
 
-func (opts Options2) watchChanges(ctx context.Context, pw prefix.Writer, m *manifest.Manifest, service, root string, ch chan error) {
-	bss, err := buildSources(m, root, service)
-	if err != nil {
-		ch <- fmt.Errorf("sync error: %s", err)
-		return
+// syncTrigger returns the absolute path of the trigger file with any symlinks
+// in its directory resolved, so it can be compared with watched changes
+func syncTrigger(root, file string) string {
+	if file == "" {
+		return ""
 	}
 
-	ignores, err := buildIgnores(root, service)
+	abs, err := filepath.Abs(rootPath(root, file))
 	if err != nil {
-		ch <- fmt.Errorf("sync error: %s", err)
-		return
+		return file
 	}
 
-	for _, bs := range bss {
-		go opts.watchPath(ctx, pw, service, root, bs, ignores, ch)
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(dir, filepath.Base(abs))
 	}
+
+	return abs
 }
 
-func (opts Options2) watchPath(ctx context.Context, pw prefix.Writer, service, root string, bs buildSource, ignores []string, ch chan error) {
-	cch := make(chan changes.Change, 1)
+// modTime returns the modification time of file, or the zero time when it
+// does not exist
+func modTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
 
-	abs, err := filepath.Abs(bs.Local)
+	stat, err := os.Stat(file)
 	if err != nil {
-		ch <- fmt.Errorf("sync error: %s", err)
-		return
+		return time.Time{}
 	}
 
-	wd, err := os.Getwd()
-	if err != nil {
-		ch <- fmt.Errorf("sync error: %s", err)
-		return
+	return stat.ModTime()
+}
+
+// sortedAdds returns a copy of adds in path order so that the same changes
+// always make the same archive and directories come before their contents
+func sortedAdds(adds []changes.Change) []changes.Change {
+	sorted := make([]changes.Change, len(adds))
+	copy(sorted, adds)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return filepath.ToSlash(sorted[i].Path) < filepath.ToSlash(sorted[j].Path)
+	})
+
+	return sorted
+}
+
+// filterChanges drops the changes that SyncFilter does not include, every
+// change is kept when it is not set
+func (opts Options2) filterChanges(chgs []changes.Change) []changes.Change {
+	if opts.SyncFilter == nil {
+		return chgs
 	}
 
-	rel, err := filepath.Rel(wd, bs.Local)
-	if err != nil {
-		ch <- fmt.Errorf("sync error: %s", err)
-		return
+	var cs []changes.Change
+
+	for _, c := range chgs {
+		if opts.SyncFilter(c) {
+			cs = append(cs, c)
+		}
 	}
 
-	pw.Writef("convox", "starting sync from <dir>%s</dir> to <dir>%s</dir> on <service>%s</service>\n", rel, common.CoalesceString(bs.Remote, "."), service)
+	return cs
+}
 
-	go changes.Watch(abs, cch, changes.WatchOptions{
-		Ignores: ignores,
-	})
+// withoutFile removes the changes to file from chgs
+func withoutFile(chgs []changes.Change, file string) []changes.Change {
+	var cs []changes.Change
 
-	tick := time.Tick(1000 * time.Millisecond)
-	var chgs []changes.Change
+	for _, c := range chgs {
+		if filepath.Join(c.Base, c.Path) != file {
+			cs = append(cs, c)
+		}
+	}
+
+	return cs
+}
+
+// waitForPath blocks until path exists, returning false if ctx is cancelled first
+func waitForPath(ctx context.Context, path string) bool {
+	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
 
 	for {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+
 		select {
 		case <-ctx.Done():
-			return
-		case c := <-cch:
-			chgs = append(chgs, c)
-		case <-tick:
-			if len(chgs) == 0 {
-				continue
-			}
-
-			pss, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{Service: options.String(service)})
-			if err != nil {
-				pw.Writef("convox", "sync error: %s\n", err)
-				continue
-			}
+			return false
+		case <-tick.C:
+		}
+	}
+}
 
-			adds, removes := changes.Partition(chgs)
+// existingFiles returns an add change for every file under dir that is not ignored
+func existingFiles(dir string, ignores []string) ([]changes.Change, error) {
+	base, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-			for _, ps := range pss {
-				switch {
-				case len(adds) > 3:
-					pw.Writef("convox", "sync: %d files to <dir>%s</dir> on <service>%s</service>\n", len(adds), common.CoalesceString(bs.Remote, "."), service)
-				case len(adds) > 0:
-					for _, a := range adds {
-						pw.Writef("convox", "sync: <dir>%s</dir> to <dir>%s</dir> on <service>%s</service>\n", a.Path, common.CoalesceString(bs.Remote, "."), service)
-					}
-				}
+	var cs []changes.Change
 
-				if err := opts.handleAdds(ps.Id, bs.Remote, adds); err != nil {
-					pw.Writef("convox", "sync add error: %s\n", err)
-				}
+	err = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
-				switch {
-				case len(removes) > 3:
-					pw.Writef("convox", "remove: %d files from <dir>%s</dir> to <service>%s</service>\n", len(removes), common.CoalesceString(bs.Remote, "."), service)
-				case len(removes) > 0:
-					for _, r := range removes {
-						pw.Writef("convox", "remove: <dir>%s</dir> from <dir>%s</dir> on <service>%s</service>\n", r.Path, common.CoalesceString(bs.Remote, "."), service)
-					}
-				}
+		if info.IsDir() {
+			return nil
+		}
 
-				if err := opts.handleRemoves(ps.Id, removes); err != nil {
-					pw.Writef("convox", "sync remove error: %s\n", err)
-				}
-			}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
 
-			chgs = []changes.Change{}
+		if match, _ := fileutils.Matches(rel, ignores); match {
+			return nil
 		}
+
+		cs = append(cs, changes.Change{Operation: "add", Base: base, Path: rel})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
+
+	return cs, nil
 }
 
 func buildDockerfile(m *manifest.Manifest, root, service string) ([]byte, error) {
@@ -576,6 +2543,39 @@ func buildDockerfile(m *manifest.Manifest, root, service string) ([]byte, error)
 	return os.ReadFile(path)
 }
 
+// extensionIgnores returns the ignore patterns that match files with any of
+// the extensions, which may be given with or without a leading dot
+func extensionIgnores(exts []string) []string {
+	ignores := []string{}
+
+	for _, ext := range exts {
+		if ext = strings.TrimPrefix(strings.TrimSpace(ext), "."); ext == "" {
+			continue
+		}
+
+		ignores = append(ignores, fmt.Sprintf("**/*.%s", ext))
+	}
+
+	return ignores
+}
+
+// vcsIgnores returns the patterns of the version control directories, which
+// are not watched unless WatchGit is set as branch operations rewrite many of
+// their files at once
+func (opts Options2) vcsIgnores() []string {
+	ignores := []string{}
+
+	if opts.WatchGit {
+		return ignores
+	}
+
+	for _, dir := range vcsDirs {
+		ignores = append(ignores, fmt.Sprintf("**/%s", dir), fmt.Sprintf("**/%s/**", dir))
+	}
+
+	return ignores
+}
+
 func buildIgnores(root, service string) ([]string, error) {
 	fd, err := os.Open(filepath.Join(root, ".dockerignore"))
 	if os.IsNotExist(err) {
@@ -588,22 +2588,33 @@ func buildIgnores(root, service string) ([]string, error) {
 	return dockerignore.ReadAll(fd)
 }
 
-func buildSources(m *manifest.Manifest, root, service string) ([]buildSource, error) {
+// dockerStage is a stage of a multi-stage Dockerfile
+type dockerStage struct {
+	env     map[string]string
+	parent  int
+	sources []buildSource
+	wd      string
+}
+
+// buildSources returns the COPY and ADD sources of a service, warnings are
+// written to pw when it is set
+func buildSources(pw *prefix.Writer, m *manifest.Manifest, root, service string) ([]buildSource, error) {
 	data, err := buildDockerfile(m, root, service)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	if data == nil {
-		return []buildSource{}, nil
-	}
-
 	svc, err := m.Service(service)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	var bs []buildSource
+	var stages []dockerStage
+	args := map[string]string{}
 	env := map[string]string{}
+	globals := map[string]string{}
+	names := map[string]int{}
+	nosync := false
 	wd := ""
 
 	s := bufio.NewScanner(bytes.NewReader(data))
@@ -616,8 +2627,23 @@ lines:
 			continue
 		}
 
+		// a marker comment keeps the next instruction out of sync
+		if strings.HasPrefix(parts[0], "#") {
+			if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(strings.Join(parts, " "), "#")), dockerNoSync) {
+				nosync = true
+			}
+			continue
+		}
+
+		skip := nosync
+		nosync = false
+
 		switch strings.ToUpper(parts[0]) {
 		case "ADD", "COPY":
+			if skip {
+				continue
+			}
+
 			for i, p := range parts {
 				if m := reDockerOption.FindStringSubmatch(p); len(m) > 1 {
 					switch strings.ToLower(m[1]) {
@@ -643,14 +2669,51 @@ lines:
 				case "http", "https":
 					// do nothing
 				default:
-					local := filepath.Join(svc.Build.Path, parts[1])
-					remote := replaceEnv(parts[2], env)
+					local := filepath.Join(root, svc.Build.Path, replaceEnv(parts[1], mergeEnv(args, env)))
+					remote := replaceEnv(parts[2], mergeEnv(args, env))
+
+					add := strings.ToUpper(parts[0]) == "ADD"
+
+					// a single file copied into a directory keeps its name, otherwise
+					// the destination is the new name of the file
+					if strings.HasSuffix(remote, "/") || remote == "." {
+						if stat, err := os.Stat(local); err == nil && stat.Mode().IsRegular() {
+							archive := false
+
+							if add {
+								if archive, err = isArchive(local); err != nil {
+									return nil, errors.WithStack(err)
+								}
+							}
+
+							if !archive {
+								remote = remoteJoin(remote, filepath.Base(local))
+							}
+						}
+					}
+
+					if wd != "" && !remoteIsAbs(remote) {
+						remote = remoteJoin(wd, remote)
+					}
 
-					if wd != "" && !filepath.IsAbs(remote) {
-						remote = filepath.Join(wd, remote)
+					if len(stages) > 0 {
+						stages[len(stages)-1].sources = append(stages[len(stages)-1].sources, buildSource{Extract: add, Local: local, Remote: remote})
 					}
+				}
+			}
+		case "ARG":
+			if len(parts) > 1 {
+				kv := strings.SplitN(parts[1], "=", 2)
+
+				switch {
+				case len(kv) == 2:
+					args[kv[0]] = kv[1]
+				case globals[kv[0]] != "":
+					args[kv[0]] = globals[kv[0]]
+				}
 
-					bs = append(bs, buildSource{Local: local, Remote: remote})
+				if len(stages) == 0 {
+					globals[kv[0]] = args[kv[0]]
 				}
 			}
 		case "ENV":
@@ -658,40 +2721,107 @@ lines:
 				env[parts[1]] = parts[2]
 			}
 		case "FROM":
-			if len(parts) > 1 {
-				var ee []string
+			// args declared before the first FROM must be redeclared to be used in a stage
+			args = map[string]string{}
 
-				data, err := Exec.Execute("docker", "inspect", parts[1], "--format", "{{json .Config.Env}}")
-				if err != nil {
-					continue
+			if len(stages) > 0 {
+				stages[len(stages)-1].env = env
+				stages[len(stages)-1].wd = wd
+			}
+
+			var from []string
+
+			for _, p := range parts[1:] {
+				if !strings.HasPrefix(p, "--") {
+					from = append(from, p)
 				}
+			}
 
-				if err := json.Unmarshal(data, &ee); err != nil {
-					return nil, errors.WithStack(err)
+			// the base may name an earlier stage through an arg declared before the first FROM
+			if len(from) > 0 {
+				from[0] = replaceEnv(from[0], globals)
+			}
+
+			st := dockerStage{parent: -1}
+
+			if len(from) > 0 {
+				if i, ok := names[strings.ToLower(from[0])]; ok {
+					st.parent = i
 				}
+			}
 
-				for _, e := range ee {
-					parts := strings.SplitN(e, "=", 2)
+			if len(from) > 2 && strings.ToUpper(from[1]) == "AS" {
+				names[strings.ToLower(from[2])] = len(stages)
+			}
 
-					if len(parts) == 2 {
-						env[parts[0]] = parts[1]
-					}
+			stages = append(stages, st)
+
+			// a stage built on an earlier stage inherits its environment
+			if st.parent >= 0 {
+				env = map[string]string{}
+
+				for k, v := range stages[st.parent].env {
+					env[k] = v
 				}
 
-				data, err = Exec.Execute("docker", "inspect", parts[1], "--format", "{{.Config.WorkingDir}}")
+				wd = stages[st.parent].wd
+
+				continue
+			}
+
+			env = map[string]string{}
+			wd = ""
+
+			if len(from) > 0 {
+				ienv, iwd, err := inspectImage(from[0])
 				if err != nil {
-					return nil, errors.WithStack(err)
+					// images pinned by digest or from a private registry may not have been pulled
+					if pw != nil {
+						pw.Writef("convox", "sync: could not inspect <dir>%s</dir> for <service>%s</service>, destinations that rely on its environment or working directory may be wrong: %s\n", from[0], service, err)
+					}
+
+					continue
 				}
 
-				wd = strings.TrimSpace(string(data))
+				env, wd = ienv, iwd
 			}
 		case "WORKDIR":
 			if len(parts) > 1 {
-				wd = replaceEnv(parts[1], env)
+				dir := replaceEnv(parts[1], mergeEnv(args, env))
+
+				// a relative WORKDIR is relative to the previous one
+				if wd != "" && !remoteIsAbs(dir) {
+					dir = remoteJoin(wd, dir)
+				}
+
+				wd = dir
 			}
 		}
 	}
 
+	// only the stage that start builds is running, which is the development
+	// stage when there is one, and the stages it is built on
+	workdir := ""
+
+	if len(stages) > 0 {
+		stages[len(stages)-1].env = env
+		stages[len(stages)-1].wd = wd
+
+		target := len(stages) - 1
+
+		if i, ok := names["development"]; ok {
+			target = i
+		}
+
+		workdir = stages[target].wd
+
+		for i := target; i >= 0; i = stages[i].parent {
+			bs = append(stages[i].sources, bs...)
+		}
+	}
+
+	bs = append(bs, volumeSources(svc, root)...)
+
 	for i := range bs {
 		abs, err := filepath.Abs(bs[i].Local)
 		if err != nil {
@@ -699,18 +2829,38 @@ lines:
 		}
 
 		stat, err := os.Stat(abs)
-		if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			// sources that do not exist yet are usually generated directories,
+			// watchPath will wait for them to appear
+			bs[i].Extract = false
+
+			if !strings.HasSuffix(abs, "/") {
+				abs = abs + "/"
+			}
+		case err != nil:
 			return nil, errors.WithStack(err)
-		}
+		default:
+			if stat.IsDir() && !strings.HasSuffix(abs, "/") {
+				abs = abs + "/"
+			}
 
-		if stat.IsDir() && !strings.HasSuffix(abs, "/") {
-			abs = abs + "/"
+			// ADD only extracts local tar archives, everything else is copied verbatim
+			if bs[i].Extract {
+				if stat.IsDir() {
+					bs[i].Extract = false
+				} else if bs[i].Extract, err = isArchive(abs); err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
 		}
 
 		bs[i].Local = abs
+		bs[i].Workdir = workdir
 
-		if bs[i].Remote == "." {
-			bs[i].Remote = wd
+		// without a known working directory . is resolved in the container
+		if bs[i].Remote == "." && workdir != "" {
+			bs[i].Remote = workdir
 		}
 	}
 
@@ -731,9 +2881,10 @@ lines:
 					return nil, errors.WithStack(err)
 				}
 
+				// an absolute and a relative destination can not be compared
 				rr, err := filepath.Rel(bs[j].Remote, bs[i].Remote)
 				if err != nil {
-					return nil, errors.WithStack(err)
+					continue
 				}
 
 				if rl == rr {
@@ -751,6 +2902,152 @@ lines:
 	return bss, nil
 }
 
+// isNotSupported reports whether err indicates that the provider does not
+// implement the requested operation
+func isNotSupported(err error) bool {
+	return err != nil && reNotSupported.MatchString(err.Error())
+}
+
+// root returns the absolute directory of the app, Dir or the current directory
+func (opts Options2) root() (string, error) {
+	root, err := filepath.Abs(common.CoalesceString(opts.Dir, "."))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return root, nil
+}
+
+// rootPath resolves a path given relative to the app directory
+func rootPath(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(root, path)
+}
+
+func relativePath(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return rel
+	}
+
+	return path
+}
+
+// volumeSources returns the volumes of a service that mount a local directory,
+// written as a relative path such as ./data:/app/data
+func volumeSources(s *manifest.Service, root string) []buildSource {
+	var bs []buildSource
+
+	for _, v := range s.Volumes {
+		parts := strings.SplitN(v, ":", 2)
+
+		if len(parts) < 2 {
+			continue
+		}
+
+		if parts[0] != "." && !strings.HasPrefix(parts[0], "./") && !strings.HasPrefix(parts[0], "../") {
+			continue
+		}
+
+		bs = append(bs, buildSource{Local: filepath.Join(root, parts[0]), Remote: parts[1]})
+	}
+
+	return bs
+}
+
+// outsideRoot reports whether path, after resolving symlinks, is outside of
+// the directory root
+func outsideRoot(root, path string) bool {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+
+	if r, err := filepath.EvalSymlinks(root); err == nil {
+		root = r
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return true
+	}
+
+	return rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+// remoteIsAbs reports whether path is absolute inside the container, which
+// may not share the path conventions of the host
+func remoteIsAbs(p string) bool {
+	return path.IsAbs(p) || reWindowsPath.MatchString(p)
+}
+
+// remoteWithin reports whether the remote path p is root or inside of it, both
+// paths are cleaned so that .. elements can not escape root
+func remoteWithin(root, p string) bool {
+	root = filepath.ToSlash(remoteJoin(root))
+	p = filepath.ToSlash(remoteJoin(p))
+
+	if reWindowsPath.MatchString(root) {
+		root = strings.ReplaceAll(strings.ToLower(root), `\`, "/")
+		p = strings.ReplaceAll(strings.ToLower(p), `\`, "/")
+	}
+
+	return p == root || strings.HasPrefix(p, strings.TrimSuffix(root, "/")+"/")
+}
+
+// remoteJoin joins path elements using the separator of the container rather
+// than the host, windows containers are recognised by their drive letter paths
+func remoteJoin(base string, elem ...string) string {
+	parts := []string{base}
+
+	for _, e := range elem {
+		parts = append(parts, filepath.ToSlash(e))
+	}
+
+	if !reWindowsPath.MatchString(base) {
+		return path.Join(parts...)
+	}
+
+	for i := range parts {
+		parts[i] = strings.ReplaceAll(parts[i], `\`, "/")
+	}
+
+	return strings.ReplaceAll(path.Join(parts...), "/", `\`)
+}
+
+// isArchive reports whether the file at path is a tar archive, optionally compressed,
+// that docker would extract when used as the source of an ADD
+func isArchive(path string) (bool, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer fd.Close()
+
+	header := make([]byte, 512)
+
+	n, err := io.ReadFull(fd, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, errors.WithStack(err)
+	}
+
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return true, nil
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return true, nil
+	case bytes.HasPrefix(header, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}):
+		return true, nil
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return true, nil
+	}
+
+	return false, nil
+}
+
 type stackTracer interface {
 	StackTrace() errors.StackTrace
 }
@@ -768,6 +3065,37 @@ func handleErrors(ctx context.Context, pw prefix.Writer, errch chan error) {
 	}
 }
 
+// inspectImage returns the environment and working directory of a local image
+func inspectImage(image string) (map[string]string, string, error) {
+	data, err := Exec.Execute("docker", "inspect", image, "--format", "{{json .Config.Env}}")
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	var ee []string
+
+	if err := json.Unmarshal(data, &ee); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	env := map[string]string{}
+
+	for _, e := range ee {
+		parts := strings.SplitN(e, "=", 2)
+
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	data, err = Exec.Execute("docker", "inspect", image, "--format", "{{.Config.WorkingDir}}")
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	return env, strings.TrimSpace(string(data)), nil
+}
+
 func replaceEnv(s string, env map[string]string) string {
 	for k, v := range env {
 		s = strings.Replace(s, fmt.Sprintf("${%s}", k), v, -1)
@@ -777,6 +3105,21 @@ func replaceEnv(s string, env map[string]string) string {
 	return s
 }
 
+// mergeEnv combines build args with environment variables, ENV takes precedence over ARG
+func mergeEnv(args, env map[string]string) map[string]string {
+	merged := map[string]string{}
+
+	for k, v := range args {
+		merged[k] = v
+	}
+
+	for k, v := range env {
+		merged[k] = v
+	}
+
+	return merged
+}
+
 var ansiScreenSequences = []*regexp.Regexp{
 	regexp.MustCompile("\033\\[\\d+;\\d+H"),
 }
@@ -789,46 +3132,278 @@ func stripANSIScreenCommands(data string) string {
 	return data
 }
 
-func writeLogs(ctx context.Context, pw prefix.Writer, r io.Reader, services map[string]bool) {
+// formatJSONLog renders a structured log line as its level, time and message
+// followed by the remaining fields, it returns false if line is not a json object
+func formatJSONLog(line string) (string, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return "", false
+	}
+
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return "", false
+	}
+
+	take := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := fields[k]; ok {
+				delete(fields, k)
+				return logField(v)
+			}
+		}
+
+		return ""
+	}
+
+	level := take("level", "lvl", "severity")
+	ts := take("time", "ts", "timestamp")
+	msg := take("msg", "message")
+
+	var parts []string
+
+	if level != "" {
+		tag := "info"
+
+		switch strings.ToLower(level) {
+		case "error", "err", "fatal", "panic", "critical":
+			tag = "fail"
+		case "warn", "warning":
+			tag = "warning"
+		case "info":
+			tag = "ok"
+		}
+
+		parts = append(parts, fmt.Sprintf("<%s>%s</%s>", tag, strings.ToUpper(level), tag))
+	}
+
+	if ts != "" {
+		parts = append(parts, ts)
+	}
+
+	if msg != "" {
+		parts = append(parts, msg)
+	}
+
+	var keys []string
+
+	for k := range fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := logField(fields[k])
+
+		if _, ok := fields[k].(string); ok && strings.ContainsAny(v, " \t\"") {
+			v = fmt.Sprintf("%q", v)
+		}
+
+		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return strings.Join(parts, " "), true
+}
+
+func logField(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(data)
+}
+
+// logLimiter caps the number of log lines shown for each service per second,
+// it is only used from the log streaming goroutine
+type logLimiter struct {
+	max     int
+	pending int
+	windows map[string]*logWindow
+}
+
+type logWindow struct {
+	lines      int
+	start      time.Time
+	suppressed int
+}
+
+// allow counts a line for service and reports whether it should be shown
+func (l *logLimiter) allow(service string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	w, ok := l.windows[service]
+	if !ok {
+		w = &logWindow{start: now}
+		l.windows[service] = w
+	}
+
+	if now.Sub(w.start) >= time.Second {
+		w.lines = 0
+		w.start = now
+	}
+
+	if w.lines >= l.max {
+		w.suppressed++
+		l.pending++
+		return false
+	}
+
+	w.lines++
+
+	return true
+}
+
+// report notes how many lines were suppressed for each service whose window
+// has ended, or for every service when all is set
+func (l *logLimiter) report(pw *prefix.Writer, now time.Time, all bool) {
+	if l == nil || l.pending == 0 {
+		return
+	}
+
+	var names []string
+
+	for name := range l.windows {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		w := l.windows[name]
+
+		if w.suppressed == 0 || (!all && now.Sub(w.start) < time.Second) {
+			continue
+		}
+
+		pw.Writef(name, "suppressed %d lines\n", w.suppressed)
+		l.pending -= w.suppressed
+		w.suppressed = 0
+	}
+}
+
+// logLineRegex compiles a custom pattern for app log lines, which must name
+// the service and message groups and may name kind, process and timestamp
+func logLineRegex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid log line regex: %s", err))
+	}
+
+	for _, name := range []string{"service", "message"} {
+		if re.SubexpIndex(name) < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid log line regex: missing named group: %s", name))
+		}
+	}
+
+	return re, nil
+}
+
+// logGroup returns the named group of a log line match, or def when the
+// pattern does not have it
+func logGroup(re *regexp.Regexp, match []string, name, def string) string {
+	if i := re.SubexpIndex(name); i >= 0 {
+		return match[i]
+	}
+
+	return def
+}
+
+// logReader returns the plain text of a log stream, a rack that supports it
+// gzips the stream when CompressLogs asks for it and one that does not sends
+// it as is
+func logReader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		if gz, err := gzip.NewReader(br); err == nil {
+			return gz
+		}
+	}
+
+	return br
+}
+
+// writeLogs writes the app log lines of the selected services, skipping lines
+// already in seen when it is not nil
+func (opts Options2) writeLogs(ctx context.Context, pw prefix.Writer, r io.Reader, services map[string]bool, seen map[string]bool) int {
+	re := opts.logLine
 	ls := bufio.NewScanner(r)
 
 	ls.Buffer(make([]byte, ScannerStartSize), ScannerMaxSize)
 
+	lines := 0
+
 	for ls.Scan() {
 		select {
 		case <-ctx.Done():
-			return
+			return lines
 		default:
-			match := reAppLog.FindStringSubmatch(ls.Text())
+			if seen != nil {
+				if seen[ls.Text()] {
+					continue
+				}
+
+				seen[ls.Text()] = true
+			}
+
+			match := re.FindStringSubmatch(ls.Text())
 
-			if len(match) != 7 {
+			if match == nil {
 				continue
 			}
 
-			switch match[3] {
+			message := logGroup(re, match, "message", "")
+
+			opts.logLimit.report(&pw, time.Now(), false)
+
+			switch logGroup(re, match, "kind", "service") {
 			case "service":
-				service := match[4]
+				service := logGroup(re, match, "service", "")
 
-				if !services[service] {
+				if !services[service] || !opts.logLimit.allow(opts.logPrefix(service), time.Now()) {
 					continue
 				}
 
-				stripped := stripANSIScreenCommands(match[6])
+				if !opts.RawLogs {
+					message = stripANSIScreenCommands(message)
+				}
+
+				if opts.ParseJSONLogs {
+					if formatted, ok := formatJSONLog(message); ok {
+						message = formatted
+					}
+				}
 
-				pw.Writef(service, "%s\n", stripped)
+				pw.Writef(opts.logPrefix(service), "%s\n", message)
+				opts.logRing.add(opts.logPrefix(service), message)
+				lines++
 			case "system":
-				service := strings.Split(match[5], "-")[0]
+				service := strings.Split(logGroup(re, match, "process", ""), "-")[0]
 
-				if !services[service] {
+				if !services[service] || !opts.logLimit.allow(opts.logPrefix(service), time.Now()) {
 					continue
 				}
 
-				pw.Writef(service, "%s\n", match[6])
+				pw.Writef(opts.logPrefix(service), "%s\n", message)
+				opts.logRing.add(opts.logPrefix(service), message)
+				lines++
 			}
 		}
 	}
 
+	opts.logLimit.report(&pw, time.Now(), true)
+
 	if err := ls.Err(); err != nil {
 		pw.Writef("convox", "scan error: %s\n", err)
 	}
+
+	return lines
 }
@@ -1,16 +1,29 @@
 package start_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/convox/changes"
 	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/manifest"
 	"github.com/convox/convox/pkg/options"
+	"github.com/convox/convox/pkg/prefix"
 	"github.com/convox/convox/pkg/start"
 	"github.com/convox/convox/pkg/structs"
 	"github.com/convox/exec"
@@ -73,6 +86,291 @@ func TestStart2(t *testing.T) {
 	e.AssertExpectations(t)
 }
 
+func TestStart2AdditionalApps(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	p := &structs.MockProvider{}
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader("0000-00-00T00:00:00Z service/web/pid1 log1\n")), nil).Once()
+	p.On("AppGet", "app2").Return(&structs.App{Name: "app2", Generation: "2", Release: "release2"}, nil)
+	p.On("ReleaseGet", "app2", "release2").Return(&structs.Release{Manifest: "services:\n  web:\n    image: nginx\n  worker:\n    image: nginx\n"}, nil)
+	p.On("AppLogs", "app2", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader("0000-00-00T00:00:00Z service/web/pid2 log2\n0000-00-00T00:00:00Z service/other/pid3 log3\n")), nil).Once()
+	p.On("AppLogs", mock.Anything, mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/app/foo`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		AdditionalApps: []string{"app2"},
+		App:            "app1",
+		Provider:       p,
+		Test:           true,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.ElementsMatch(t,
+		[]string{
+			"<color3>web        </color3> | log1",
+			"<color10>app2/web   </color10> | log2",
+			"<system>convox     </system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	p.On("AppGet", "app3").Return(nil, fmt.Errorf("no such app: app3"))
+
+	err = start.New().Start2(context.Background(), &buf, start.Options2{AdditionalApps: []string{"app3"}, App: "app1", Provider: p})
+	require.EqualError(t, err, "could not stream logs of app3: no such app: app3")
+}
+
+func TestStart2LogLineRegex(t *testing.T) {
+	p := &structs.MockProvider{}
+
+	logs := "[web:pid1] log1\n[web:pid1] log2\n[other:pid2] log3\nunparsed\n"
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/app/foo`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:          "app1",
+		LogLineRegex: `^\[(?P<service>[^:]+):(?P<process>[^\]]+)\] (?P<message>.*)$`,
+		Provider:     p,
+		Test:         true,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<color3>web   </color3> | log1",
+			"<color3>web   </color3> | log2",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	err = start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", LogLineRegex: `^(?P<service>\S+)`, Provider: p})
+	require.EqualError(t, err, "invalid log line regex: missing named group: message")
+}
+
+func TestStart2LogRateLimit(t *testing.T) {
+	p := &structs.MockProvider{}
+
+	logs := ""
+
+	for i := 1; i <= 5; i++ {
+		logs += fmt.Sprintf("0000-00-00T00:00:00Z service/web/pid1 log%d\n", i)
+	}
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/app/foo`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:                  "app1",
+		LogMaxLinesPerSecond: 2,
+		Provider:             p,
+		Test:                 true,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<color3>web   </color3> | log1",
+			"<color3>web   </color3> | log2",
+			"<color3>web   </color3> | suppressed 3 lines",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+}
+
+func TestStart2LogsSince(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	p := &structs.MockProvider{}
+
+	backlog := "0000-00-00T00:00:00Z service/web/pid1 crash1\n0000-00-00T00:00:01Z service/web/pid1 crash2\n"
+	overlap := "0000-00-00T00:00:01Z service/web/pid1 crash2\n0000-00-00T00:00:02Z service/web/pid2 log1\n"
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(5 * time.Minute)}).Return(ioutil.NopCloser(strings.NewReader(backlog)), nil).Once()
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(overlap)), nil).Once()
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/app/foo`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:       "app1",
+		LogsSince: 5 * time.Minute,
+		Provider:  p,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<color3>web   </color3> | crash1",
+			"<color3>web   </color3> | crash2",
+			"<color3>web   </color3> | log1",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2RawLogs(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	logs := "0000-00-00T00:00:00Z service/web/pid1 \033[1;1H\033[32mlog1\033[0m\n"
+
+	for _, raw := range []bool{false, true} {
+		p := &structs.MockProvider{}
+
+		p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2"}, nil)
+		p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+		p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+		p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil)
+
+		e := &exec.MockInterface{}
+		start.Exec = e
+
+		e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+		e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/app/foo`), nil)
+
+		cwd, err := os.Getwd()
+		require.NoError(t, err)
+		os.Chdir("testdata/httpd")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+
+		buf := bytes.Buffer{}
+
+		opts := start.Options2{
+			App:      "app1",
+			Provider: p,
+			RawLogs:  raw,
+			Test:     true,
+		}
+
+		err = start.New().Start2(ctx, &buf, opts)
+		cancel()
+		os.Chdir(cwd)
+		require.NoError(t, err)
+
+		line := "<color3>web   </color3> | \033[32mlog1\033[0m"
+
+		if raw {
+			line = "<color3>web   </color3> | \033[1;1H\033[32mlog1\033[0m"
+		}
+
+		require.Equal(t,
+			[]string{
+				line,
+				"<system>convox</system> | stopping",
+			},
+			strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+		)
+	}
+}
+
+func TestFormatJSONLog(t *testing.T) {
+	tests := []struct {
+		Line   string
+		Output string
+		JSON   bool
+	}{
+		{`{"level":"info","time":"12:00:00","msg":"listening","port":3000}`, "<ok>INFO</ok> 12:00:00 listening port=3000", true},
+		{`{"level":"error","msg":"request failed","path":"/a b","status":500}`, `<fail>ERROR</fail> request failed path="/a b" status=500`, true},
+		{`{"lvl":"warn","message":"slow query","ms":1200.5}`, "<warning>WARN</warning> slow query ms=1200.5", true},
+		{`{"msg":"no level","ctx":{"id":1}}`, `no level ctx={"id":1}`, true},
+		{`plain text`, "", false},
+		{`{"unterminated":`, "", false},
+		{`[1,2,3]`, "", false},
+	}
+
+	for _, test := range tests {
+		out, ok := start.FormatJSONLog(test.Line)
+		require.Equal(t, test.JSON, ok, test.Line)
+		require.Equal(t, test.Output, out, test.Line)
+	}
+}
+
 func TestStart2Options(t *testing.T) {
 	common.ProviderWaitDuration = 1
 
@@ -129,13 +427,4776 @@ func TestStart2Options(t *testing.T) {
 			"<system>build </system> | starting build",
 			"<system>build </system> | build1",
 			"<system>build </system> | build2",
+			"<system>build </system> | completed in 1s",
 			"<color3>web   </color3> | log1",
 			"<color3>web   </color3> | log2",
 			"<system>convox</system> | stopping",
 		},
-		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+		buildLines(buf.String()),
 	)
 
 	p.AssertExpectations(t)
 	e.AssertExpectations(t)
 }
+
+func TestStart2Flow(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	promote := structs.ReleasePromoteOptions{Development: options.Bool(true), Force: options.Bool(true), Idle: options.Bool(false), Min: options.Int(0), Timeout: options.Int(300)}
+	demote := structs.ReleasePromoteOptions{Development: options.Bool(false), Force: options.Bool(true)}
+
+	build := func(p *structs.MockProvider, status string) {
+		p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil)
+		p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(false), Manifest: options.String("convox2.yml")}).Return(&structs.Build{Id: "build1"}, nil)
+		p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+		p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release2", Status: status}, nil)
+	}
+
+	tests := []struct {
+		Name    string
+		Options start.Options2
+		Setup   func(p *structs.MockProvider, e *exec.MockInterface)
+		Error   string
+		Output  []string
+	}{
+		{
+			Name:    "build promote and demote",
+			Options: start.Options2{Build: true, Manifest: "convox2.yml"},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}, nil)
+				build(p, "complete")
+				p.On("ReleasePromote", "app1", "release2", promote).Return(nil)
+				p.On("ReleasePromote", "app1", "release1", demote).Return(nil)
+			},
+			Output: []string{
+				"<system>build </system> | uploading source",
+				"<system>build </system> | starting build",
+				"<system>build </system> | completed in 1s",
+				"<system>convox</system> | stopping",
+			},
+		},
+		{
+			Name:    "build summary",
+			Options: start.Options2{Build: true, BuildSummary: true, Manifest: "convox2.yml"},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}, nil)
+				build(p, "complete")
+				p.On("ReleasePromote", "app1", "release2", promote).Return(nil)
+				p.On("ReleasePromote", "app1", "release1", demote).Return(nil)
+			},
+			Output: []string{
+				"<system>build </system> | uploading source",
+				"<system>build </system> | starting build",
+				"<system>build </system> | image summary is only available for external builds",
+				"<system>build </system> | completed in 1s",
+				"<system>convox</system> | stopping",
+			},
+		},
+		{
+			Name:    "build promote generation 3",
+			Options: start.Options2{Build: true, Manifest: "convox2.yml"},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "3", Release: "release1", Status: "running"}, nil)
+				build(p, "complete")
+				p.On("ReleasePromote", "app1", "release2", structs.ReleasePromoteOptions{Development: options.Bool(true), Force: options.Bool(true), Idle: options.Bool(false), Min: options.Int(0), Max: options.Int(100), Timeout: options.Int(300)}).Return(nil)
+				p.On("ReleasePromote", "app1", "release1", demote).Return(nil)
+			},
+			Output: []string{
+				"<system>build </system> | uploading source",
+				"<system>build </system> | starting build",
+				"<system>build </system> | completed in 1s",
+				"<system>convox</system> | stopping",
+			},
+		},
+		{
+			Name:    "build failed",
+			Options: start.Options2{Build: true, Manifest: "convox2.yml"},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}, nil)
+				build(p, "failed")
+			},
+			Error: "build failed",
+			Output: []string{
+				"<system>build </system> | uploading source",
+				"<system>build </system> | starting build",
+			},
+		},
+		{
+			Name:    "external build",
+			Options: start.Options2{Build: true, External: true, Manifest: "convox2.yml"},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}, nil)
+				p.On("SystemGet").Return(&structs.System{Name: "rack1"}, nil)
+				p.On("BuildCreate", "app1", "", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(true), Manifest: options.String("convox2.yml")}).Return(nil, fmt.Errorf("external builds unavailable"))
+			},
+			Error:  "external builds unavailable",
+			Output: []string{""},
+		},
+		{
+			Name:    "external build promote and demote",
+			Options: start.Options2{Build: true, External: true, Manifest: "convox2.yml"},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				b := &structs.Build{Id: "build1", App: "app1", Repository: "https://registry.example.com/app1"}
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}, nil)
+				p.On("SystemGet").Return(&structs.System{Name: "rack1"}, nil)
+				p.On("BuildCreate", "app1", "", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(true), Manifest: options.String("convox2.yml")}).Return(b, nil)
+				p.On("BuildGet", "app1", "build1").Return(b, nil)
+				p.On("BuildUpdate", "app1", "build1", mock.Anything).Return(&structs.Build{Id: "build1", App: "app1", Release: "release2"}, nil)
+				p.On("ObjectStore", "app1", "build/build1/logs", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/build/build1/logs"}, nil)
+				p.On("ReleaseCreate", "app1", mock.Anything).Return(&structs.Release{Id: "release2"}, nil)
+				p.On("EventSend", "build:create", mock.Anything).Return(nil)
+				e.On("Terminal", "docker", "build", "--no-cache", "-t", mock.Anything, "-f", mock.Anything, "--network", "host", mock.Anything).Return(nil)
+				e.On("Execute", "docker", "inspect", mock.Anything, "--format", "{{json .Config.Entrypoint}}").Return([]byte("null"), nil)
+				e.On("Execute", "docker", "pull", "httpd").Return([]byte(""), nil)
+				e.On("Execute", "docker", "tag", mock.Anything, mock.Anything).Return([]byte(""), nil)
+				e.On("Execute", "docker", "push", mock.Anything).Return([]byte(""), nil)
+				p.On("ReleasePromote", "app1", "release2", promote).Return(nil)
+				p.On("ReleasePromote", "app1", "release1", demote).Return(nil)
+			},
+			Output: []string{
+				"<system>build </system> | completed in 1s",
+				"<system>convox</system> | stopping",
+			},
+		},
+		{
+			Name:    "no release to demote",
+			Options: start.Options2{},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+			},
+			Output: []string{
+				"<system>convox</system> | stopping",
+			},
+		},
+		{
+			Name:    "create missing app",
+			Options: start.Options2{},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(nil, fmt.Errorf("no such app: app1")).Once()
+				p.On("AppCreate", "app1", structs.AppCreateOptions{Generation: options.String("2")}).Return(&structs.App{Name: "app1", Generation: "2"}, nil)
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+			},
+			Output: []string{
+				"<system>convox</system> | stopping",
+			},
+		},
+		{
+			Name:    "invalid generation",
+			Options: start.Options2{},
+			Setup: func(p *structs.MockProvider, e *exec.MockInterface) {
+				p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "1"}, nil)
+			},
+			Error:  "invalid generation: 1",
+			Output: []string{""},
+		},
+		{
+			Name:    "relative remote temp dir",
+			Options: start.Options2{RemoteTempDir: "tmp"},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "remote temp dir must be absolute: tmp",
+			Output:  []string{""},
+		},
+		{
+			Name:    "relative sync allowed root",
+			Options: start.Options2{SyncAllowedRoots: []string{"/app", "srv"}},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "sync allowed root must be absolute: srv",
+			Output:  []string{""},
+		},
+		{
+			Name:    "invalid sync content filter",
+			Options: start.Options2{SyncContentFilter: "ready("},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "invalid sync content filter: error parsing regexp: missing closing ): `ready(`",
+			Output:  []string{""},
+		},
+		{
+			Name:    "relative sync root",
+			Options: start.Options2{SyncRoot: map[string]string{"web": "mnt/code"}},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "sync root for web must be absolute: mnt/code",
+			Output:  []string{""},
+		},
+		{
+			Name:    "relative remote workdir",
+			Options: start.Options2{RemoteWorkdir: map[string]string{"web": "app"}},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "remote workdir for web must be absolute: app",
+			Output:  []string{""},
+		},
+		{
+			Name:    "build source without external build",
+			Options: start.Options2{Build: true, BuildSource: "git+https://github.com/example/app.git"},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "build source requires an external build",
+			Output:  []string{""},
+		},
+		{
+			Name:    "invalid build source",
+			Options: start.Options2{Build: true, BuildSource: "https://github.com/example/app.git", External: true},
+			Setup:   func(p *structs.MockProvider, e *exec.MockInterface) {},
+			Error:   "invalid build source: https://github.com/example/app.git, must be a git url such as git+https://github.com/org/app.git#main",
+			Output:  []string{""},
+		},
+	}
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// the docker config of the user is not used for external builds
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+			p := &structs.MockProvider{}
+			e := &exec.MockInterface{}
+			start.Exec = e
+
+			test.Setup(p, e)
+
+			// registered after the case so that its own mocks of these calls are used first
+			p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil).Maybe()
+			p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil).Maybe()
+			p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil).Maybe()
+
+			e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil).Maybe()
+			e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil).Maybe()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			buf := bytes.Buffer{}
+
+			opts := test.Options
+			opts.App = "app1"
+			opts.Provider = p
+
+			err := start.New().Start2(ctx, &buf, opts)
+
+			if test.Error == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, test.Error)
+			}
+
+			require.Equal(t, test.Output, buildLines(buf.String()))
+
+			p.AssertExpectations(t)
+			e.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStart2ServicesEnv(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("develop:\n  services:\n    - web\nservices:\n  web:\n    image: httpd\n  worker:\n    image: httpd\n"), 0644))
+
+	logs := "0000-00-00T00:00:00Z service/web/pid1 log1\n0000-00-00T00:00:00Z service/worker/pid2 log2\n"
+
+	// the logs are mocked with the options that start asks for instead of the shared mock
+	p, _ := testStart2Provider(t, testDir(dir), testSetup(func(p *structs.MockProvider, e *exec.MockInterface) {
+		p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil)
+	}))
+
+	t.Setenv("CONVOX_SERVICES", " worker, ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<color15>worker</color15> | log2",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	t.Setenv("CONVOX_SERVICES", "worker,reports")
+
+	err = start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", Provider: p})
+	require.EqualError(t, err, "invalid CONVOX_SERVICES: no such service: reports")
+}
+
+func TestStart2ServicesPattern(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n  worker-email:\n    image: httpd\n  worker-sms:\n    image: httpd\n"), 0644))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	logs := "0000-00-00T00:00:00Z service/web/pid1 log1\n0000-00-00T00:00:00Z service/worker-email/pid2 log2\n0000-00-00T00:00:00Z service/worker-sms/pid3 log3\n"
+
+	run := func(opts start.Options2) ([]string, error) {
+		p := &structs.MockProvider{}
+
+		p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+		p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+		p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+		p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		buf := bytes.Buffer{}
+
+		opts.App = "app1"
+		opts.Provider = p
+
+		err := start.New().Start2(ctx, &buf, opts)
+
+		lines := []string{}
+
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			if i := strings.Index(line, "| "); i >= 0 {
+				lines = append(lines, line[i+2:])
+			}
+		}
+
+		return lines, err
+	}
+
+	lines, err := run(start.Options2{Services: []string{"worker-*"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"log2", "log3", "stopping"}, lines)
+
+	lines, err = run(start.Options2{Services: []string{"web", "*-sms"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"log1", "log3", "stopping"}, lines)
+
+	t.Setenv("CONVOX_SERVICES", "worker-e*")
+
+	lines, err = run(start.Options2{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"log2", "stopping"}, lines)
+
+	t.Setenv("CONVOX_SERVICES", "")
+
+	_, err = run(start.Options2{Services: []string{"queue-*"}})
+	require.EqualError(t, err, "no services match queue-*")
+
+	_, err = run(start.Options2{Services: []string{"worker-["}})
+	require.EqualError(t, err, "invalid service pattern worker-[: syntax error in pattern")
+}
+
+func TestStart2Test(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n    test: make test\n  worker:\n    image: httpd\n"), 0644))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	for _, code := range []int{0, 2} {
+		p, _ := testStart2Provider(t, testApp(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}))
+
+		p.On("ProcessRun", "app1", "web", structs.ProcessRunOptions{Command: options.String("sleep 3600")}).Return(&structs.Process{Id: "pid1"}, nil)
+		p.On("ProcessGet", "app1", "pid1").Return(&structs.Process{Id: "pid1", Status: "running"}, nil)
+		p.On("ProcessExec", "app1", "pid1", "make test", mock.Anything, structs.ProcessExecOptions{Entrypoint: options.Bool(true), Tty: options.Bool(false)}).Return(code, nil).Run(func(args mock.Arguments) {
+			fmt.Fprintf(args.Get(3).(io.Writer), "ok 1 - web\n")
+		})
+		p.On("ProcessStop", "app1", "pid1").Return(nil)
+		p.On("ReleasePromote", "app1", "release1", structs.ReleasePromoteOptions{Development: options.Bool(false), Force: options.Bool(true)}).Return(nil)
+
+		buf := bytes.Buffer{}
+
+		opts := start.Options2{
+			App:      "app1",
+			Provider: p,
+			Test:     true,
+		}
+
+		err := start.New().Start2(context.Background(), &buf, opts)
+
+		output := []string{
+			"<system>test  </system> | running <command>make test</command> on <service>web</service>",
+			"<system>test  </system> | ok 1 - web",
+		}
+
+		if code == 0 {
+			require.NoError(t, err)
+		} else {
+			require.EqualError(t, err, "test failed on web: exit 2")
+			require.Equal(t, 2, err.(interface{ Code() int }).Code())
+			output = append(output, "<system>test  </system> | <service>web</service> exited with 2")
+		}
+
+		output = append(output, "<system>convox</system> | stopping")
+
+		require.Equal(t, output, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"))
+
+		p.AssertExpectations(t)
+	}
+}
+
+func TestBuildSourcesArchive(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(``), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/archive")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 2)
+
+	require.Equal(t, filepath.Join(wd, "deps.tar.gz"), bss[0].Local)
+	require.Equal(t, "/usr/local/", bss[0].Remote)
+	require.True(t, bss[0].Extract)
+
+	require.Equal(t, filepath.Join(wd, "src")+"/", bss[1].Local)
+	require.Equal(t, "/app/src", bss[1].Remote)
+	require.False(t, bss[1].Extract)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesWorkdirArg(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`["FOO=bar"]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/workdir")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, wd+"/", bss[0].Local)
+	require.Equal(t, "/srv/app", bss[0].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesCopyEnv(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`["FOO=bar"]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/copyenv")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, wd+"/src/app/", bss[0].Local)
+	require.Equal(t, "/srv/app", bss[0].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesUnpulledImage(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	image := "registry.example.com/base@sha256:0123456789abcdef"
+
+	e.On("Execute", "docker", "inspect", image, "--format", "{{json .Config.Env}}").Return(nil, fmt.Errorf("No such object: %s", image))
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(fmt.Sprintf("FROM %s\nWORKDIR /app\nCOPY . .\n", image)), 0644))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	pw := prefix.NewWriter(&buf, map[string]string{"convox": "system"})
+
+	bss, err := start.BuildSources(&pw, m, dir, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, dir+"/", bss[0].Local)
+	require.Equal(t, "/app", bss[0].Remote)
+
+	require.Contains(t, buf.String(), fmt.Sprintf("sync: could not inspect <dir>%s</dir> for <service>web</service>", image))
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesWorkdirResolution(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+	e.On("Execute", "docker", "inspect", "private/base", "--format", "{{json .Config.Env}}").Return(nil, fmt.Errorf("No such object: private/base"))
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build:\n      manifest: Dockerfile.web\n  worker:\n    build:\n      manifest: Dockerfile.worker\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile.web"), []byte("FROM httpd\nWORKDIR srv\nWORKDIR app\nCOPY src src\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile.worker"), []byte("FROM private/base\nCOPY src /app/src\nCOPY . .\n"), 0644))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	// relative workdirs build on the working directory of the base image
+	bss, err := start.BuildSources(nil, m, dir, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, dir+"/src/", bss[0].Local)
+	require.Equal(t, "/usr/local/apache2/srv/app/src", bss[0].Remote)
+
+	// without a known working directory relative destinations are resolved in the container
+	bss, err = start.BuildSources(nil, m, dir, "worker")
+	require.NoError(t, err)
+	require.Len(t, bss, 2)
+
+	require.Equal(t, dir+"/src/", bss[0].Local)
+	require.Equal(t, "/app/src", bss[0].Remote)
+	require.Equal(t, dir+"/", bss[1].Local)
+	require.Equal(t, ".", bss[1].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesNoSync(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "config"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\n# convox:nosync\nCOPY config /app/config\n#convox:nosync\n\n# baked at build time\nCOPY config /etc/app\n# convox:nosync\nRUN true\nCOPY src /app/src\n"), 0644))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	// the marker only applies to the instruction that follows it
+	bss, err := start.BuildSources(nil, m, dir, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, dir+"/src/", bss[0].Local)
+	require.Equal(t, "/app/src", bss[0].Remote)
+}
+
+func TestBuildSourcesRename(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/rename")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 3)
+
+	require.Equal(t, filepath.Join(wd, "a.txt"), bss[0].Local)
+	require.Equal(t, "/app/b.txt", bss[0].Remote)
+
+	require.Equal(t, filepath.Join(wd, "a.txt"), bss[1].Local)
+	require.Equal(t, "/app/dir/b.txt", bss[1].Remote)
+
+	require.Equal(t, filepath.Join(wd, "a.txt"), bss[2].Local)
+	require.Equal(t, "/app/conf/a.txt", bss[2].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesMultistage(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+	e.On("Execute", "docker", "inspect", "node", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "node", "--format", "{{.Config.WorkingDir}}").Return([]byte(``), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/multistage")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 2)
+
+	require.Equal(t, filepath.Join(wd, "conf")+"/", bss[0].Local)
+	require.Equal(t, "/usr/local/apache2/conf", bss[0].Remote)
+
+	require.Equal(t, filepath.Join(wd, "htdocs")+"/", bss[1].Local)
+	require.Equal(t, "/usr/local/apache2/htdocs", bss[1].Remote)
+
+	bss, err = start.BuildSources(nil, m, wd, "final")
+	require.NoError(t, err)
+	require.Len(t, bss, 2)
+
+	require.Equal(t, filepath.Join(wd, "conf")+"/", bss[0].Local)
+	require.Equal(t, "/usr/local/apache2/conf", bss[0].Remote)
+
+	require.Equal(t, filepath.Join(wd, "dist")+"/", bss[1].Local)
+	require.Equal(t, "/usr/local/apache2/htdocs", bss[1].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesDevelopmentStage(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+	e.On("Execute", "docker", "inspect", "private/base", "--format", "{{json .Config.Env}}").Return(nil, fmt.Errorf("No such object: private/base"))
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "htdocs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM private/base AS development\nCOPY . .\n\nFROM httpd\nCOPY htdocs htdocs\n"), 0644))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	// the working directory of the final stage does not apply to the development stage before it
+	bss, err := start.BuildSources(nil, m, dir, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, dir+"/", bss[0].Local)
+	require.Equal(t, ".", bss[0].Remote)
+	require.Equal(t, "", bss[0].Workdir)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesStageAliases(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	// only the real base image is inspected, never the stage aliases
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/aliases")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 3)
+
+	require.Equal(t, filepath.Join(wd, "conf")+"/", bss[0].Local)
+	require.Equal(t, "/srv/app/conf", bss[0].Remote)
+
+	require.Equal(t, filepath.Join(wd, "src")+"/", bss[1].Local)
+	require.Equal(t, "/srv/app/src", bss[1].Remote)
+
+	require.Equal(t, filepath.Join(wd, "public")+"/", bss[2].Local)
+	require.Equal(t, "/srv/app/public", bss[2].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSourcesVolumes(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/volumes")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+	require.Len(t, bss, 2)
+
+	require.Equal(t, filepath.Join(wd, "src")+"/", bss[0].Local)
+	require.Equal(t, "/app/src", bss[0].Remote)
+
+	require.Equal(t, filepath.Join(wd, "data")+"/", bss[1].Local)
+	require.Equal(t, "/app/data", bss[1].Remote)
+
+	bss, err = start.BuildSources(nil, m, wd, "db")
+	require.NoError(t, err)
+	require.Len(t, bss, 1)
+
+	require.Equal(t, filepath.Join(wd, "seed")+"/", bss[0].Local)
+	require.Equal(t, "/docker-entrypoint-initdb.d", bss[0].Remote)
+
+	e.AssertExpectations(t)
+}
+
+func TestWriteSources(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(``), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/archive")
+	defer os.Chdir(cwd)
+
+	data, err := os.ReadFile("convox.yml")
+	require.NoError(t, err)
+
+	m, err := manifest.Load(data, map[string]string{})
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	bss, err := start.BuildSources(nil, m, wd, "web")
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	pw := prefix.NewWriter(&buf, map[string]string{"convox": "system"})
+
+	start.WriteSources(&pw, "web", wd, bss, map[int]string{0: "skipped, extracted by ADD"})
+	start.WriteSources(&pw, "worker", wd, nil, nil)
+
+	require.Equal(t,
+		[]string{
+			"<system>convox</system> | sync: sources for <service>web</service>",
+			"<system>convox</system> | sync:   <dir>deps.tar.gz</dir> -> <dir>/usr/local/</dir> (skipped, extracted by ADD)",
+			"<system>convox</system> | sync:   <dir>src</dir>         -> <dir>/app/src</dir>",
+			"<system>convox</system> | sync: no sources found for <service>worker</service>",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	e.AssertExpectations(t)
+}
+
+func TestBuildSummary(t *testing.T) {
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "image", "ls", "--filter", "reference=rack1/app1:*.build1", "--format", "{{.Tag}}").Return([]byte("worker.build1\nweb.build1\n"), nil)
+	e.On("Execute", "docker", "image", "inspect", "rack1/app1:web.build1", "--format", "{{.Size}} {{len .RootFS.Layers}}").Return([]byte("250000000 9\n"), nil)
+	e.On("Execute", "docker", "history", "rack1/app1:web.build1", "--human=false", "--no-trunc", "--format", "{{.Size}}\t{{.CreatedBy}}").Return([]byte(strings.Join([]string{
+		"0\t/bin/sh -c #(nop)  CMD [\"httpd-foreground\"]",
+		"120000000\t/bin/sh -c #(nop) COPY dir:0123456789abcdef0123456789abcdef0123456789abcdef in /app",
+		"5000000\t/bin/sh -c apt-get update",
+		"80000000\t/bin/sh -c npm install",
+		"45000000\t/bin/sh -c #(nop) ADD file:abc in / ",
+	}, "\n")), nil)
+	e.On("Execute", "docker", "image", "inspect", "rack1/app1:worker.build1", "--format", "{{.Size}} {{len .RootFS.Layers}}").Return(nil, fmt.Errorf("No such image"))
+
+	buf := bytes.Buffer{}
+	pw := prefix.NewWriter(&buf, map[string]string{"build": "system"})
+
+	start.BuildSummary(start.Options2{}, &pw, "rack1", &structs.Build{App: "app1", Id: "build1"})
+
+	require.Equal(t,
+		[]string{
+			"<system>build</system> | <service>web</service> image is 250 MB in 9 layers",
+			"<system>build</system> |   120 MB COPY dir:0123456789abcdef0123456789abcdef0123456789abcdef...",
+			"<system>build</system> |   80 MB npm install",
+			"<system>build</system> |   45 MB ADD file:abc in /",
+			"<system>build</system> | could not inspect the image of <service>worker</service>: No such image",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	e.AssertExpectations(t)
+}
+
+func TestOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+
+	root := filepath.Join(dir, "app")
+	other := filepath.Join(dir, "other")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "src"), 0755))
+	require.NoError(t, os.MkdirAll(other, 0755))
+	require.NoError(t, os.Symlink(other, filepath.Join(root, "link")))
+
+	require.False(t, start.OutsideRoot(root, root))
+	require.False(t, start.OutsideRoot(root, filepath.Join(root, "src")))
+	require.False(t, start.OutsideRoot(root, filepath.Join(root, "missing")))
+	require.True(t, start.OutsideRoot(root, other))
+	require.True(t, start.OutsideRoot(root, filepath.Join(root, "link")))
+	require.True(t, start.OutsideRoot(root, dir))
+}
+
+func TestAtomicSaves(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("app"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib", "util.js"), []byte("util"), 0644))
+
+	chgs := []changes.Change{
+		{Operation: "add", Base: dir, Path: "4913"},
+		{Operation: "remove", Base: dir, Path: "4913"},
+		{Operation: "add", Base: dir, Path: "app.js~"},
+		{Operation: "remove", Base: dir, Path: "app.js"},
+		{Operation: "add", Base: dir, Path: ".app.js.swp"},
+		{Operation: "remove", Base: dir, Path: "gone.js"},
+		{Operation: "add", Base: dir, Path: "lib/util.js___jb_tmp___"},
+		{Operation: "remove", Base: dir, Path: "lib/util.js"},
+		{Operation: "add", Base: dir, Path: "lib/util.js"},
+		{Operation: "remove", Base: dir, Path: "lib/util.js___jb_old___"},
+	}
+
+	require.Equal(t, []changes.Change{
+		{Operation: "add", Base: dir, Path: "app.js"},
+		{Operation: "remove", Base: dir, Path: "gone.js"},
+		{Operation: "add", Base: dir, Path: "lib/util.js"},
+	}, start.AtomicSaves(chgs))
+}
+
+func TestCacheCounts(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Output string
+		Hits   int
+		Steps  int
+	}{
+		{
+			"classic",
+			"Step 1/4 : FROM node:18\n ---> 1a2b3c\nStep 2/4 : COPY package.json .\n ---> Using cache\n ---> 4d5e6f\nStep 3/4 : RUN npm install\n ---> Using cache\nStep 4/4 : COPY . .\n ---> 7a8b9c\n",
+			2, 3,
+		},
+		{
+			"buildkit",
+			"#1 [internal] load build definition\n#2 [1/4] FROM docker.io/library/node:18\n#3 [2/4] COPY package.json .\n#3 CACHED\n#4 [3/4] RUN npm install\n#4 CACHED\n#5 [4/4] COPY . .\n#5 DONE 0.1s\n",
+			2, 3,
+		},
+		{
+			"concurrent",
+			"web: #3 [2/2] COPY . .\nworker: #3 [build 2/3] RUN make\nworker: #3 CACHED\nweb: #3 DONE 0.1s\nworker: #4 [build 3/3] COPY . .\n",
+			1, 3,
+		},
+		{
+			"unknown",
+			"building\ndone\n",
+			0, 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			hits, steps := start.CacheCounts(test.Output)
+			require.Equal(t, test.Hits, hits)
+			require.Equal(t, test.Steps, steps)
+		})
+	}
+}
+
+func TestRemoteJoin(t *testing.T) {
+	tests := []struct {
+		Base string
+		Elem []string
+		Path string
+	}{
+		{"/app", []string{"src/index.js"}, "/app/src/index.js"},
+		{"/app/", []string{"."}, "/app"},
+		{"/app", []string{"conf/", "a.txt"}, "/app/conf/a.txt"},
+		{`C:\app`, []string{"src/index.js"}, `C:\app\src\index.js`},
+		{"C:/app", []string{"a.txt"}, `C:\app\a.txt`},
+		{`C:\app\`, []string{"."}, `C:\app`},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.Path, start.RemoteJoin(test.Base, test.Elem...))
+	}
+}
+
+func TestRemoteWithin(t *testing.T) {
+	tests := []struct {
+		Root   string
+		Path   string
+		Within bool
+	}{
+		{"/app", "/app", true},
+		{"/app", "/app/src/index.js", true},
+		{"/app/", "/app/src", true},
+		{"/app", "/application/index.js", false},
+		{"/app", "/app/../etc/passwd", false},
+		{"/app", "/etc", false},
+		{"/", "/etc/passwd", true},
+		{`C:\app`, `C:\app\index.js`, true},
+		{`C:\app`, `c:\App\index.js`, true},
+		{`C:\app`, `C:\apps\index.js`, false},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.Within, start.RemoteWithin(test.Root, test.Path), "%s in %s", test.Path, test.Root)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), bytes.Repeat([]byte("b"), 1000), 0644))
+
+	adds := []changes.Change{
+		{Operation: "add", Base: dir, Path: "a.txt"},
+		{Operation: "add", Base: dir, Path: "b.txt"},
+	}
+
+	for _, size := range []int{16, 0x40000} {
+		var buf bytes.Buffer
+
+		require.NoError(t, start.WriteArchive(&buf, "/app", adds, false, false, false, size))
+
+		files := map[string]int{}
+
+		tr := tar.NewReader(&buf)
+
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+
+			files[h.Name] = len(data)
+		}
+
+		require.Equal(t, map[string]int{"/app/a.txt": 5, "/app/b.txt": 1000}, files)
+	}
+}
+
+func BenchmarkWriteArchive(b *testing.B) {
+	dir := b.TempDir()
+
+	var small, large []changes.Change
+
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("small%d.txt", i)
+		require.NoError(b, os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("s"), 512), 0644))
+		small = append(small, changes.Change{Operation: "add", Base: dir, Path: name})
+	}
+
+	require.NoError(b, os.WriteFile(filepath.Join(dir, "large.bin"), bytes.Repeat([]byte("l"), 32*1024*1024), 0644))
+	large = append(large, changes.Change{Operation: "add", Base: dir, Path: "large.bin"})
+
+	for _, files := range []struct {
+		name string
+		adds []changes.Change
+	}{{"small", small}, {"large", large}} {
+		for _, size := range []int{32 * 1024, 256 * 1024, 1024 * 1024} {
+			b.Run(fmt.Sprintf("%s/%dk", files.name, size/1024), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					rp, wp := io.Pipe()
+
+					go func() {
+						wp.CloseWithError(start.WriteArchive(wp, "/app", files.adds, false, false, false, size))
+					}()
+
+					if _, err := io.Copy(io.Discard, rp); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestStart2ExternalBuildPaths(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Path  string
+		Error string
+	}{
+		{"missing", "missing", "build path for service web does not exist: missing"},
+		{"file", "file", "build path for service web is not a directory: file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte(fmt.Sprintf("services:\n  web:\n    build: %s\n", tt.Path)), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("file"), 0644))
+
+			p, _ := testStart2Provider(t, testDir(dir))
+
+			buf := bytes.Buffer{}
+
+			opts := start.Options2{
+				App:      "app1",
+				Build:    true,
+				External: true,
+				Provider: p,
+			}
+
+			// the paths are checked against the manifest start loaded before the build is created
+			err := start.New().Start2(context.Background(), &buf, opts)
+			require.EqualError(t, err, tt.Error)
+
+			p.AssertNotCalled(t, "BuildCreate", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestStart2Production(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	p, _ := testStart2Provider(t, testDir("testdata/httpd"))
+
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil)
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{Development: options.Bool(false), External: options.Bool(false)}).Return(&structs.Build{Id: "build1"}, nil)
+	p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release2", Status: "complete"}, nil)
+	p.On("ReleasePromote", "app1", "release2", structs.ReleasePromoteOptions{}).Return(nil)
+
+	buf := bytes.Buffer{}
+
+	events := make(chan start.Event, 1)
+
+	opts := start.Options2{
+		App:        "app1",
+		Build:      true,
+		Events:     events,
+		Production: true,
+		Provider:   p,
+	}
+
+	err := start.New().Start2(context.Background(), &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<system>build </system> | uploading source",
+			"<system>build </system> | starting build",
+			"<system>build </system> | completed in 1s",
+			"<system>convox</system> | promoting release2",
+			"<system>convox</system> | deployed release2",
+		},
+		buildLines(buf.String()),
+	)
+
+	e := <-events
+
+	require.Equal(t, "build", e.Action)
+	require.Equal(t, "build1", e.Data["build"])
+	require.Equal(t, "release2", e.Data["release"])
+	require.NotEmpty(t, e.Data["duration"])
+	require.NotEmpty(t, e.Data["started"])
+	require.Equal(t, "success", e.Status)
+
+	p.AssertExpectations(t)
+	p.AssertNotCalled(t, "AppLogs", "app1", mock.Anything)
+}
+
+func TestStart2ProductionInvalid(t *testing.T) {
+	tests := []struct {
+		Name string
+		Opts start.Options2
+		Err  string
+	}{
+		{
+			Name: "no build",
+			Opts: start.Options2{App: "app1"},
+			Err:  "production requires a build",
+		},
+		{
+			Name: "attach",
+			Opts: start.Options2{App: "app1", Attach: true, Build: true},
+			Err:  "production can not be used with attach",
+		},
+		{
+			Name: "test",
+			Opts: start.Options2{App: "app1", Build: true, Test: true},
+			Err:  "production can not be used with test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			p, _ := testStart2Provider(t)
+
+			tt.Opts.Production = true
+			tt.Opts.Provider = p
+
+			err := start.New().Start2(context.Background(), io.Discard, tt.Opts)
+			require.EqualError(t, err, tt.Err)
+
+			p.AssertNotCalled(t, "AppGet", "app1")
+		})
+	}
+}
+
+func TestStart2UploadRetry(t *testing.T) {
+	common.ProviderWaitDuration = 1
+	start.ObjectStoreBackoff = 0
+
+	p := &structs.MockProvider{}
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(nil, fmt.Errorf("connection reset")).Once()
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil).Once()
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(false)}).Return(&structs.Build{Id: "build1"}, nil)
+	p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release1", Status: "complete"}, nil)
+	p.On("ReleasePromote", "app1", "release1", structs.ReleasePromoteOptions{Development: options.Bool(true), Force: options.Bool(true), Idle: options.Bool(false), Min: options.Int(0), Timeout: options.Int(300)}).Return(nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(``), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Build:    true,
+		Provider: p,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<system>build </system> | uploading source",
+			"<system>build </system> | upload failed: connection reset, retrying in 0s (1/3)",
+			"<system>build </system> | starting build",
+			"<system>build </system> | completed in 1s",
+			"<system>convox</system> | stopping",
+		},
+		buildLines(buf.String()),
+	)
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2ReleaseSuperseded(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	p := &structs.MockProvider{}
+
+	app := &structs.App{Name: "app1", Generation: "2", Release: "release0", Status: "running"}
+
+	p.On("AppGet", "app1").Return(func(string) *structs.App { return app }, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release2"}}, nil)
+	p.On("ReleaseGet", "app1", "release2").Return(&structs.Release{}, nil)
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil)
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(false)}).Return(&structs.Build{Id: "build1"}, nil)
+	p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release1", Status: "complete"}, nil)
+	p.On("ReleasePromote", "app1", "release1", structs.ReleasePromoteOptions{Development: options.Bool(true), Force: options.Bool(true), Idle: options.Bool(false), Min: options.Int(0), Timeout: options.Int(300)}).Return(nil).Run(func(mock.Arguments) {
+		// a teammate deploys release2 over the development release
+		app.Release = "release2"
+	})
+	p.On("ReleasePromote", "app1", "release2", structs.ReleasePromoteOptions{Development: options.Bool(false), Force: options.Bool(true)}).Return(nil)
+	p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(``), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir("testdata/httpd")
+	defer os.Chdir(cwd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Build:    true,
+		Provider: p,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<system>build </system> | uploading source",
+			"<system>build </system> | starting build",
+			"<system>build </system> | completed in 1s",
+			"<system>convox</system> | <error>app is running release release2 instead of development release release1, it may have been deployed over</error>",
+			"<system>convox</system> | stopping",
+		},
+		buildLines(buf.String()),
+	)
+}
+
+func TestStart2Validate(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n    volumes:\n      - ./data:/app/data\n  worker:\n    build: worker\n  redis:\n    image: redis\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\nCOPY lib /app/lib\nCOPY *.json /app/\nCOPY config.yml /app/\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte("{}"), 0644))
+
+	p, _ := testStart2Provider(t, testWorkdir(""))
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Validate2(context.Background(), &buf, start.Options2{App: "app1", Dir: dir, Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"<system>convox</system> | warning: <dir>lib</dir> copied by the Dockerfile of <service>web</service> does not exist, check that the Dockerfile matches the app directory",
+		"<system>convox</system> | warning: <dir>config.yml</dir> copied by the Dockerfile of <service>web</service> does not exist, check that the Dockerfile matches the app directory",
+		"<system>convox</system> | warning: build path <dir>worker</dir> of <service>worker</service> does not exist",
+		"",
+	}, strings.Split(buf.String(), "\n"))
+}
+
+func TestStart2PlanOutput(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n  redis:\n    image: redis\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\nCOPY config config\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "config"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+
+	p, _ := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	p.On("ProcessList", "app1", mock.Anything).Return(structs.Processes{}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	out := filepath.Join(t.TempDir(), "plan.json")
+
+	opts := start.Options2{
+		App:           "app1",
+		LogLineRegex:  `^(?P<service>\S+) (?P<message>.*)$`,
+		NoLogs:        true,
+		PlanOutput:    out,
+		PostSyncExec:  map[string]string{"web": "kill -HUP 1"},
+		Provider:      p,
+		RemoteWorkdir: map[string]string{"web": "/srv"},
+		Sync:          true,
+	}
+
+	err := start.New().Start2(ctx, &bytes.Buffer{}, opts)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+
+	require.JSONEq(t, `{
+		"app": "app1",
+		"build": {"enabled": false, "external": false, "promote": false},
+		"logs": {"enabled": false, "json": false, "line-regex": "^(?P<service>\\S+) (?P<message>.*)$", "raw": false},
+		"manifest": "",
+		"services": [
+			{
+				"name": "web",
+				"post-sync": "kill -HUP 1",
+				"remote-workdir": "/srv",
+				"sources": [
+					{"local": "src", "remote": "/app/src"},
+					{"local": "config", "remote": "/srv/config"}
+				]
+			},
+			{"name": "redis", "sources": []}
+		],
+		"sync": true
+	}`, string(data))
+}
+
+func TestStart2Dir(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := filepath.Join(t.TempDir(), "my app")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, e := testStart2Provider(t, testWorkdir(""))
+
+	p.On("ProcessList", "app1", mock.Anything).Return(structs.Processes{}, nil)
+
+	e.On("Run", mock.Anything, "sh", "-c", fmt.Sprintf("cd '%s' && make Dockerfile", dir)).Return(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	// the current directory is not the app so everything must resolve against Dir
+	opts := start.Options2{
+		App:        "app1",
+		Dir:        dir,
+		NoLogs:     true,
+		PlanOutput: "-",
+		PreStart:   "make Dockerfile",
+		Provider:   p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Contains(t, buf.String(), `"local": "src"`)
+	require.Contains(t, buf.String(), `"remote": "/app/src"`)
+
+	e.AssertExpectations(t)
+}
+
+func TestStart2BuildArgs(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nARG VERSION\n"), 0644))
+
+	p, _ := testStart2Provider(t, testRelease(&structs.Release{Env: "VERSION=1.2.3\nNODE_ENV=development"}))
+
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil)
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{
+		BuildArgs:   &[]string{"VERSION=1.2.3", "NODE_ENV=development", "STATIC=1"},
+		Development: options.Bool(true),
+		External:    options.Bool(false),
+	}).Return(nil, fmt.Errorf("build stopped"))
+
+	opts := start.Options2{
+		App:      "app1",
+		Build:    true,
+		Dir:      dir,
+		NoLogs:   true,
+		Provider: p,
+	}
+
+	// bare names come from the app environment and repeated args are merged
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build:\n      path: .\n      args:\n        - VERSION=${VERSION}\n        - NODE_ENV\n        - MISSING\n        - STATIC=1\n  worker:\n    build:\n      path: .\n      args:\n        - STATIC=1\n"), 0644))
+
+	err := start.New().Start2(context.Background(), &bytes.Buffer{}, opts)
+	require.EqualError(t, err, "build stopped")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build:\n      path: .\n      args:\n        - STATIC=1\n  worker:\n    build:\n      path: .\n      args:\n        - STATIC=2\n"), 0644))
+
+	err = start.New().Start2(context.Background(), &bytes.Buffer{}, opts)
+	require.EqualError(t, err, "conflicting values for build arg STATIC")
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2BuildSecrets(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	t.Setenv("TEST_TOKEN", "token1")
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".npmrc"), []byte("token2"), 0600))
+
+	p, _ := testStart2Provider(t)
+
+	files := []string{}
+
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil).Run(func(args mock.Arguments) {
+		gz, err := gzip.NewReader(args.Get(2).(io.Reader))
+		require.NoError(t, err)
+
+		tr := tar.NewReader(gz)
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			files = append(files, h.Name)
+		}
+	})
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{
+		Development: options.Bool(false),
+		External:    options.Bool(false),
+		Secrets:     &[]string{"TEST_TOKEN=token1", "npmrc=token2"},
+	}).Return(&structs.Build{Id: "build1"}, nil)
+	p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("using token1\nusing token2\n")), nil)
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release2", Status: "complete"}, nil).After(100 * time.Millisecond)
+	p.On("ReleasePromote", "app1", "release2", structs.ReleasePromoteOptions{}).Return(nil)
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:          "app1",
+		Build:        true,
+		BuildSecrets: []string{"TEST_TOKEN", "npmrc=.npmrc"},
+		Dir:          dir,
+		Production:   true,
+		Provider:     p,
+	}
+
+	err := start.New().Start2(context.Background(), &buf, opts)
+	require.NoError(t, err)
+
+	require.Contains(t, files, "convox.yml")
+	require.NotContains(t, files, ".npmrc")
+
+	require.Contains(t, buf.String(), "<system>build </system> | using [redacted]\n")
+	require.NotContains(t, buf.String(), "token1")
+	require.NotContains(t, buf.String(), "token2")
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2BuildSecretsInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+
+	tests := []struct {
+		Name    string
+		Options start.Options2
+		Error   string
+	}{
+		{"external", start.Options2{BuildSecrets: []string{"TEST_TOKEN"}, External: true}, "build secrets require a build on the rack"},
+		{"unset", start.Options2{BuildSecrets: []string{"TEST_MISSING_TOKEN"}}, "build secret TEST_MISSING_TOKEN is not set"},
+		{"id", start.Options2{BuildSecrets: []string{"../token=.npmrc"}}, "invalid build secret id: ../token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			p, _ := testStart2Provider(t)
+
+			opts := test.Options
+			opts.App = "app1"
+			opts.Build = true
+			opts.Dir = dir
+			opts.Provider = p
+
+			err := start.New().Start2(context.Background(), io.Discard, opts)
+			require.EqualError(t, err, test.Error)
+		})
+	}
+}
+
+func TestStart2SyncNewDirectory(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	var lock sync.Mutex
+	uploaded := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+
+			lock.Lock()
+			uploaded[h.Name] = string(data)
+			lock.Unlock()
+
+			if h.Name == "/app/dist/nested/deep/late.txt" {
+				cancel()
+			}
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		os.MkdirAll(filepath.Join(dir, "dist", "nested"), 0755)
+		os.WriteFile(filepath.Join(dir, "dist", "nested", "early.txt"), []byte("early"), 0644)
+		time.Sleep(2500 * time.Millisecond)
+		os.MkdirAll(filepath.Join(dir, "dist", "nested", "deep"), 0755)
+		os.WriteFile(filepath.Join(dir, "dist", "nested", "deep", "late.txt"), []byte("late"), 0644)
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, "early", uploaded["/app/dist/nested/early.txt"])
+	require.Equal(t, "late", uploaded["/app/dist/nested/deep/late.txt"])
+}
+
+func TestStart2SyncHidden(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Develop      string
+		NoSyncHidden bool
+		Uploaded     map[string]string
+	}{
+		{
+			Name: "default",
+			Uploaded: map[string]string{
+				"/app/dist/.DS_Store":             "ds",
+				"/app/dist/.keep":                 "keep",
+				"/app/dist/.vscode/settings.json": "{}",
+				"/app/dist/app.js":                "app",
+			},
+		},
+		{
+			Name:         "no sync hidden",
+			NoSyncHidden: true,
+			Uploaded: map[string]string{
+				"/app/dist/.keep":  "keep",
+				"/app/dist/app.js": "app",
+			},
+		},
+		{
+			Name:    "develop",
+			Develop: "develop:\n  noSyncHidden: true\n",
+			Uploaded: map[string]string{
+				"/app/dist/.keep":  "keep",
+				"/app/dist/app.js": "app",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			common.ProviderWaitDuration = 1
+
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte(tt.Develop+"services:\n  web:\n    build: .\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("!**/.keep\n"), 0644))
+
+			p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+			var lock sync.Mutex
+			uploaded := map[string]string{}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+				tr := tar.NewReader(args.Get(2).(io.Reader))
+
+				lock.Lock()
+				defer lock.Unlock()
+
+				for {
+					h, err := tr.Next()
+					if err != nil {
+						break
+					}
+
+					data, err := io.ReadAll(tr)
+					require.NoError(t, err)
+
+					uploaded[h.Name] = string(data)
+				}
+
+				if _, ok := uploaded["/app/dist/app.js"]; ok {
+					cancel()
+				}
+			})
+
+			go func() {
+				time.Sleep(1500 * time.Millisecond)
+				tmp := filepath.Join(dir, "tmp")
+				os.MkdirAll(filepath.Join(tmp, ".vscode"), 0755)
+				os.WriteFile(filepath.Join(tmp, ".vscode", "settings.json"), []byte("{}"), 0644)
+				os.WriteFile(filepath.Join(tmp, ".DS_Store"), []byte("ds"), 0644)
+				os.WriteFile(filepath.Join(tmp, ".keep"), []byte("keep"), 0644)
+				os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+				os.Rename(tmp, filepath.Join(dir, "dist"))
+			}()
+
+			buf := bytes.Buffer{}
+
+			opts := start.Options2{
+				App:          "app1",
+				NoSyncHidden: tt.NoSyncHidden,
+				Provider:     p,
+			}
+
+			err := start.New().Start2(ctx, &buf, opts)
+			require.NoError(t, err)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			require.Equal(t, tt.Uploaded, uploaded)
+		})
+	}
+}
+
+func TestStart2WatchGit(t *testing.T) {
+	tests := []struct {
+		Name     string
+		WatchGit bool
+		Uploaded map[string]string
+	}{
+		{
+			Name:     "default",
+			Uploaded: map[string]string{"/app/src/app.js": "app"},
+		},
+		{
+			Name:     "watch git",
+			WatchGit: true,
+			Uploaded: map[string]string{"/app/src/.git/HEAD": "ref", "/app/src/app.js": "app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			common.ProviderWaitDuration = 1
+
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+			p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+			var lock sync.Mutex
+			uploaded := map[string]string{}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+				tr := tar.NewReader(args.Get(2).(io.Reader))
+
+				lock.Lock()
+				defer lock.Unlock()
+
+				for {
+					h, err := tr.Next()
+					if err != nil {
+						break
+					}
+
+					data, err := io.ReadAll(tr)
+					require.NoError(t, err)
+
+					uploaded[h.Name] = string(data)
+				}
+
+				if len(uploaded) == len(tt.Uploaded) {
+					cancel()
+				}
+			})
+
+			go func() {
+				time.Sleep(1500 * time.Millisecond)
+				tmp := filepath.Join(dir, "tmp")
+				os.MkdirAll(filepath.Join(tmp, ".git"), 0755)
+				os.WriteFile(filepath.Join(tmp, ".git", "HEAD"), []byte("ref"), 0644)
+				os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+				os.Rename(tmp, filepath.Join(dir, "src"))
+			}()
+
+			buf := bytes.Buffer{}
+
+			opts := start.Options2{
+				App:      "app1",
+				Provider: p,
+				WatchGit: tt.WatchGit,
+			}
+
+			err := start.New().Start2(ctx, &buf, opts)
+			require.NoError(t, err)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			require.Equal(t, tt.Uploaded, uploaded)
+		})
+	}
+}
+
+func TestStart2SyncIgnoreExtensions(t *testing.T) {
+	tests := []struct {
+		Name                 string
+		Develop              string
+		SyncIgnoreExtensions map[string][]string
+	}{
+		{
+			Name: "options",
+			SyncIgnoreExtensions: map[string][]string{
+				"web":    {".map", "tmp"},
+				"worker": {"js"},
+			},
+		},
+		{
+			Name:    "develop",
+			Develop: "develop:\n  syncIgnoreExtensions:\n    web:\n      - .map\n      - tmp\n",
+		},
+		{
+			Name:    "options over develop",
+			Develop: "develop:\n  syncIgnoreExtensions:\n    web:\n      - js\n",
+			SyncIgnoreExtensions: map[string][]string{
+				"web": {".map", "tmp"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			common.ProviderWaitDuration = 1
+
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte(tt.Develop+"services:\n  web:\n    build: .\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+
+			p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+			var lock sync.Mutex
+			uploaded := map[string]string{}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+				tr := tar.NewReader(args.Get(2).(io.Reader))
+
+				lock.Lock()
+				defer lock.Unlock()
+
+				for {
+					h, err := tr.Next()
+					if err != nil {
+						break
+					}
+
+					data, err := io.ReadAll(tr)
+					require.NoError(t, err)
+
+					uploaded[h.Name] = string(data)
+				}
+
+				if _, ok := uploaded["/app/dist/app.js"]; ok {
+					cancel()
+				}
+			})
+
+			go func() {
+				time.Sleep(1500 * time.Millisecond)
+				tmp := filepath.Join(dir, "tmp")
+				os.MkdirAll(filepath.Join(tmp, "css"), 0755)
+				os.WriteFile(filepath.Join(tmp, "css", "app.css.map"), []byte("map"), 0644)
+				os.WriteFile(filepath.Join(tmp, "app.js.map"), []byte("map"), 0644)
+				os.WriteFile(filepath.Join(tmp, "app.tmp"), []byte("tmp"), 0644)
+				os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+				os.Rename(tmp, filepath.Join(dir, "dist"))
+			}()
+
+			buf := bytes.Buffer{}
+
+			opts := start.Options2{
+				App:                  "app1",
+				Provider:             p,
+				SyncIgnoreExtensions: tt.SyncIgnoreExtensions,
+			}
+
+			err := start.New().Start2(ctx, &buf, opts)
+			require.NoError(t, err)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			require.Equal(t, map[string]string{
+				"/app/dist/app.js": "app",
+			}, uploaded)
+		})
+	}
+}
+
+func TestStart2SyncPromotedRelease(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+
+	p := &structs.MockProvider{}
+
+	var lock sync.Mutex
+	uploaded := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Release: "release2", Status: "running"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release2"}}, nil)
+	p.On("ReleaseGet", "app1", "release2").Return(&structs.Release{Build: "build2"}, nil)
+	p.On("BuildGet", "app1", "build2").Return(&structs.Build{Id: "build2", Development: true}, nil)
+	p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("WithContext", mock.Anything).Return(p)
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1", Release: "release1"}, {Id: "pid2", Release: "release2"}}, nil)
+	p.On("ProcessExec", "app1", "pid2", "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", "pid2", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+
+			uploaded[h.Name] = string(data)
+		}
+
+		if _, ok := uploaded["/app/dist/app.js"]; ok {
+			cancel()
+		}
+	})
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/usr/local/apache2`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "dist"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Attach:   true,
+		Provider: p,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, map[string]string{
+		"/app/dist/app.js": "app",
+	}, uploaded)
+
+	p.AssertNotCalled(t, "FilesUpload", "app1", "pid1", mock.Anything, mock.Anything)
+}
+
+func TestStart2SyncOrder(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	var lock sync.Mutex
+	uploaded := []string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			uploaded = append(uploaded, h.Name)
+		}
+
+		if len(uploaded) >= 4 {
+			cancel()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(filepath.Join(tmp, "lib", "util"), 0755)
+		os.WriteFile(filepath.Join(tmp, "lib", "util", "strings.js"), []byte("strings"), 0644)
+		os.WriteFile(filepath.Join(tmp, "lib", "index.js"), []byte("index"), 0644)
+		os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+		os.WriteFile(filepath.Join(tmp, "lib-legacy.js"), []byte("legacy"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "dist"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{
+		"/app/dist/app.js",
+		"/app/dist/lib-legacy.js",
+		"/app/dist/lib/index.js",
+		"/app/dist/lib/util/strings.js",
+	}, uploaded)
+}
+
+type testTracer struct {
+	lock  sync.Mutex
+	ended func(*testSpan)
+	spans []*testSpan
+}
+
+type testSpan struct {
+	Attributes map[string]interface{}
+	Err        error
+	Name       string
+	tracer     *testTracer
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, start.Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s := &testSpan{Attributes: map[string]interface{}{}, Name: name, tracer: t}
+
+	t.spans = append(t.spans, s)
+
+	return ctx, s
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+
+	s.Attributes[key] = value
+}
+
+func (s *testSpan) End(err error) {
+	s.tracer.lock.Lock()
+	s.Err = err
+	s.tracer.lock.Unlock()
+
+	if s.tracer.ended != nil {
+		s.tracer.ended(s)
+	}
+}
+
+func TestStart2Tracer(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testApp(&structs.App{Name: "app1", Generation: "2", Release: "release2", Status: "running"}), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil)
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(false)}).Return(&structs.Build{Id: "build1"}, nil)
+	p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release2", Status: "complete"}, nil)
+	p.On("ReleasePromote", "app1", "release2", mock.Anything).Return(nil)
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "dist"))
+	}()
+
+	tracer := &testTracer{
+		ended: func(s *testSpan) {
+			if s.Name == "start.sync" {
+				cancel()
+			}
+		},
+	}
+
+	err := start.New().Start2(ctx, io.Discard, start.Options2{App: "app1", Build: true, Provider: p, Tracer: tracer})
+	require.NoError(t, err)
+
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+
+	spans := map[string]*testSpan{}
+
+	for _, s := range tracer.spans {
+		if _, ok := spans[s.Name]; !ok {
+			spans[s.Name] = s
+		}
+	}
+
+	require.Equal(t, map[string]interface{}{"app": "app1", "build": "build1", "external": false, "release": "release2"}, spans["start.build"].Attributes)
+	require.Equal(t, map[string]interface{}{"app": "app1", "release": "release2"}, spans["start.promote"].Attributes)
+	require.Equal(t, map[string]interface{}{"app": "app1"}, spans["start.wait"].Attributes)
+	require.Equal(t, map[string]interface{}{"bytes": int64(3), "files": 1, "process": "pid1", "removes": 0, "service": "web"}, spans["start.sync"].Attributes)
+
+	for _, s := range spans {
+		require.NoError(t, s.Err)
+	}
+}
+
+func TestStart2SyncFilter(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	var lock sync.Mutex
+	uploaded := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+
+			uploaded[h.Name] = string(data)
+		}
+
+		if _, ok := uploaded["/app/dist/app.js"]; ok {
+			cancel()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "app.debug"), []byte("debug"), 0644)
+		os.WriteFile(filepath.Join(tmp, "app.js"), []byte("app"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "dist"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	filtered := []string{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Provider: p,
+		SyncFilter: func(c changes.Change) bool {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if filepath.Ext(c.Path) == ".debug" {
+				filtered = append(filtered, c.Path)
+				return false
+			}
+
+			return true
+		},
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, map[string]string{
+		"/app/dist/app.js": "app",
+	}, uploaded)
+
+	require.Equal(t, []string{"app.debug"}, filtered)
+}
+
+func TestStart2SyncContentFilter(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var names []string
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			names = append(names, h.Name)
+		}
+
+		if len(names) > 0 {
+			cancel()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "1_users.sql"), []byte("-- ready\ncreate table users;\n"), 0644)
+		os.WriteFile(filepath.Join(tmp, "2_posts.sql"), []byte("-- draft\ncreate table posts;\n"), 0644)
+		os.WriteFile(filepath.Join(tmp, "logo.png"), []byte("\x89PNG\r\n\x1a\n\x00\x00"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p, SyncContentFilter: `(?m)^-- ready$`})
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{"/app/src/1_users.sql", "/app/src/logo.png"}, names)
+}
+
+func TestStart2SyncRoot(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nWORKDIR /app\nCOPY src src\nCOPY config /etc/web\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var names []string
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			names = append(names, h.Name)
+		}
+
+		if len(names) == 2 {
+			cancel()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "web.conf"), []byte("conf"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "config"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p, SyncRoot: map[string]string{"web": "/mnt/code"}})
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	sort.Strings(names)
+
+	// destinations outside of the working directory are left in place
+	require.Equal(t, []string{"/etc/web/web.conf", "/mnt/code/src/index.js"}, names)
+}
+
+func TestStart2SyncReadOnly(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var uploads int32
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(func(app, pid string, r io.Reader, opts structs.FileTransferOptions) error {
+		tr := tar.NewReader(r)
+
+		if _, err := tr.Next(); err != nil {
+			return nil
+		}
+
+		io.Copy(io.Discard, r)
+
+		if atomic.AddInt32(&uploads, 1) == 2 {
+			cancel()
+		}
+
+		return fmt.Errorf("tar: app: Cannot mkdir: Read-only file system")
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+
+		// inotify events are shared by every watcher in the process so keep
+		// writing until a second batch fails
+		for i := 0; ctx.Err() == nil; i++ {
+			time.Sleep(100 * time.Millisecond)
+			os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte(fmt.Sprintf("index%d", i)), 0644)
+		}
+	}()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, strings.Count(buf.String(), "hint: <dir>/app/src</dir> is read-only in <service>web</service>, use --sync-root web=<path> to sync into a writable volume instead"))
+}
+
+func TestStart2InitialSync(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  api:\n    build: .\n  web:\n    build: .\n  worker:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+
+	for _, name := range []string{"a.js", "b.js", "c.js"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "src", name), []byte(name), 0644))
+	}
+
+	p, _ := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var inflight, most int
+	uploaded := map[string][]string{}
+	files := 0
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("api")}).Return(structs.Processes{{Id: "pid1"}}, nil)
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid2"}}, nil)
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("worker")}).Return(structs.Processes{{Id: "pid3"}}, nil)
+	p.On("ProcessExec", "app1", mock.Anything, "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", mock.Anything, mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		pid := args.String(1)
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		var names []string
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			names = append(names, h.Name)
+		}
+
+		// the support probe uploads an empty archive
+		if len(names) == 0 {
+			return
+		}
+
+		lock.Lock()
+		inflight++
+		if inflight > most {
+			most = inflight
+		}
+		lock.Unlock()
+
+		time.Sleep(300 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		inflight--
+		uploaded[pid] = names
+		files += len(names)
+
+		if files == 9 {
+			cancel()
+		}
+	})
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", InitialSync: 2, Provider: p})
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, 2, most)
+
+	for _, pid := range []string{"pid1", "pid2", "pid3"} {
+		require.Equal(t, []string{"/app/src/a.js", "/app/src/b.js", "/app/src/c.js"}, uploaded[pid])
+	}
+
+	// the last batch may still be reporting its progress when start stops
+	require.Contains(t, buf.String(), "<system>convox</system> | initial sync: 3/9 files\n")
+}
+
+func TestStart2InitialSyncBatches(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "a.js"), []byte("a.js"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var inflight, most int
+	var first time.Time
+	uploads := 0
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1"}, {Id: "pid2"}}, nil)
+	p.On("ProcessExec", "app1", "pid1", "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", mock.Anything, mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		if _, err := tr.Next(); err != nil {
+			return
+		}
+
+		io.Copy(io.Discard, tr)
+
+		lock.Lock()
+		if first.IsZero() {
+			first = time.Now()
+		}
+		inflight++
+		if inflight > most {
+			most = inflight
+		}
+		lock.Unlock()
+
+		time.Sleep(300 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		inflight--
+		uploads++
+
+		if uploads == 2 {
+			cancel()
+		}
+	})
+
+	buf := bytes.Buffer{}
+
+	started := time.Now()
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", InitialSync: 2, Provider: p, SyncStartupDelay: 1500 * time.Millisecond})
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// the processes of a service share its batch
+	require.Equal(t, 2, uploads)
+	require.Equal(t, 1, most)
+
+	// the processes appear on the first tick and are then left to settle
+	require.GreaterOrEqual(t, first.Sub(started), 2500*time.Millisecond)
+}
+
+func TestStart2SyncAllowedRoots(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY dist /app/dist\nCOPY conf /etc/app\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		for _, d := range []string{"conf", "dist"} {
+			tmp := filepath.Join(dir, "tmp")
+			os.MkdirAll(tmp, 0755)
+			os.WriteFile(filepath.Join(tmp, "app."+d), []byte(d), 0644)
+			os.Rename(tmp, filepath.Join(dir, d))
+		}
+	}()
+
+	events := make(chan start.Event)
+
+	var lock sync.Mutex
+	received := map[string]start.Event{}
+
+	go func() {
+		for e := range events {
+			lock.Lock()
+			received[e.Data["remote"]] = e
+			if len(received) == 2 {
+				cancel()
+			}
+			lock.Unlock()
+		}
+	}()
+
+	opts := start.Options2{
+		App:              "app1",
+		Events:           events,
+		Provider:         p,
+		SyncAllowedRoots: []string{"/app"},
+	}
+
+	err := start.New().Start2(ctx, io.Discard, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Len(t, received, 2)
+
+	require.Equal(t, "success", received["/app/dist/app.dist"].Status)
+	require.Equal(t, "error", received["/etc/app/app.conf"].Status)
+	require.Equal(t, "sync to /etc/app/app.conf is outside of the allowed roots: /app", received["/etc/app/app.conf"].Error)
+}
+
+func TestStart2PostSyncExec(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("ProcessExec", "app1", "pid1", "kill -HUP 1", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil).Run(func(args mock.Arguments) {
+		cancel()
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:          "app1",
+		PostSyncExec: map[string]string{"web": "kill -HUP 1"},
+		Provider:     p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Contains(t, buf.String(), "sync: running <command>kill -HUP 1</command> on <service>web</service>")
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2SyncStartupDelay(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	var lock sync.Mutex
+	var uploaded []string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			lock.Lock()
+			uploaded = append(uploaded, h.Name)
+			lock.Unlock()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:              "app1",
+		Provider:         p,
+		SyncStartupDelay: 1 * time.Minute,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Empty(t, uploaded)
+	require.Contains(t, buf.String(), "sync: ignoring 1 changes to <service>web</service> while pid1 starts up")
+}
+
+func TestStart2SyncTrigger(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	var lock sync.Mutex
+	var triggered, synced time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			if h.Name == "/app/src/index.js" {
+				lock.Lock()
+				synced = time.Now()
+				lock.Unlock()
+				cancel()
+			}
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+		time.Sleep(2500 * time.Millisecond)
+		lock.Lock()
+		triggered = time.Now()
+		lock.Unlock()
+		os.WriteFile(filepath.Join(dir, ".sync"), []byte{}, 0644)
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:         "app1",
+		Provider:    p,
+		SyncTrigger: ".sync",
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.False(t, synced.IsZero())
+	require.True(t, synced.After(triggered))
+	require.Contains(t, buf.String(), "sync: holding changes to <service>web</service> until <dir>.sync</dir> changes")
+}
+
+func TestStart2SyncSharedBuildPath(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n  worker:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	uploads := map[string]int{}
+
+	upload := func(args mock.Arguments) {
+		data, _ := io.ReadAll(args.Get(2).(io.Reader))
+
+		if len(data) <= 1024 {
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		uploads[args.String(1)]++
+
+		if uploads["pid1"] > 0 && uploads["pid2"] > 0 {
+			cancel()
+		}
+	}
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1"}}, nil)
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("worker")}).Return(structs.Processes{{Id: "pid2"}}, nil)
+	p.On("ProcessExec", "app1", mock.Anything, "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", mock.Anything, mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(upload)
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, strings.Count(buf.String(), "starting sync from <dir>src</dir>"))
+	require.Contains(t, buf.String(), "<dir>src</dir> is already watched, syncing it to <service>")
+	require.Contains(t, buf.String(), "sync: <dir>index.js</dir> to <dir>/app/src</dir> on <service>web</service>")
+	require.Contains(t, buf.String(), "sync: <dir>index.js</dir> to <dir>/app/src</dir> on <service>worker</service>")
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, map[string]int{"pid1": 1, "pid2": 1}, uploads)
+}
+
+func TestStart2SyncEvents(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1"}, {Id: "pid2"}}, nil)
+	p.On("ProcessExec", "app1", "pid1", "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("FilesUpload", "app1", "pid2", mock.Anything, structs.FileTransferOptions{}).Return(fmt.Errorf("upload failed")).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	events := make(chan start.Event)
+
+	var lock sync.Mutex
+	received := map[string]start.Event{}
+
+	go func() {
+		for e := range events {
+			lock.Lock()
+			received[e.Data["process"]] = e
+			if len(received) == 2 {
+				cancel()
+			}
+			lock.Unlock()
+		}
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Events:   events,
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Len(t, received, 2)
+
+	for pid, status := range map[string]string{"pid1": "success", "pid2": "error"} {
+		e := received[pid]
+
+		require.Equal(t, "sync:file", e.Action)
+		require.Equal(t, map[string]string{"path": "index.js", "process": pid, "remote": "/app/src/index.js", "service": "web"}, e.Data)
+		require.Equal(t, status, e.Status)
+		require.False(t, e.Time.IsZero())
+	}
+
+	require.Equal(t, "upload failed", received["pid2"].Error)
+}
+
+func TestStart2Status(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1"}, {Id: "pid2"}}, nil)
+	p.On("ProcessExec", "app1", "pid1", "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("FilesUpload", "app1", "pid2", mock.Anything, structs.FileTransferOptions{}).Return(fmt.Errorf("upload failed")).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+
+	status := &start.Status{}
+
+	require.Empty(t, status.Watches())
+
+	waiting := make(chan []start.WatchStatus, 1)
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		waiting <- status.Watches()
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	events := make(chan start.Event)
+
+	go func() {
+		for e := range events {
+			if e.Data["process"] == "pid2" {
+				cancel()
+			}
+		}
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Events:   events,
+		Provider: p,
+		Status:   status,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t, []start.WatchStatus{{Local: "src", Remote: "/app/src", Services: []string{"web"}, Targets: []start.SyncTarget{}, Watching: false}}, <-waiting)
+
+	wss := status.Watches()
+
+	require.Len(t, wss, 1)
+	require.Equal(t, "src", wss[0].Local)
+	require.Equal(t, "/app/src", wss[0].Remote)
+	require.Equal(t, []string{"web"}, wss[0].Services)
+	require.True(t, wss[0].Watching)
+
+	// the failed batch to pid2 is not recorded
+	require.Len(t, wss[0].Targets, 1)
+	require.Equal(t, "pid1", wss[0].Targets[0].Process)
+	require.Equal(t, "/app/src", wss[0].Targets[0].Remote)
+	require.Equal(t, "web", wss[0].Targets[0].Service)
+	require.False(t, wss[0].Targets[0].LastSync.IsZero())
+}
+
+func TestStart2RemoteWorkdir(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var names []string
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			lock.Lock()
+			names = append(names, h.Name)
+			lock.Unlock()
+
+			cancel()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:           "app1",
+		Provider:      p,
+		RemoteWorkdir: map[string]string{"web": "/srv/app"},
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{"/srv/app/src/index.js"}, names)
+
+	p.AssertNotCalled(t, "ProcessExec", "app1", "pid1", "pwd", mock.Anything, structs.ProcessExecOptions{})
+}
+
+func TestStart2WorkdirFallback(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src src\nWORKDIR /srv/app\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var names []string
+
+	p.On("ProcessExec", "app1", "pid1", "pwd", mock.Anything, structs.ProcessExecOptions{}).Return(0, fmt.Errorf("exec: \"pwd\": executable file not found in $PATH"))
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			lock.Lock()
+			names = append(names, h.Name)
+			lock.Unlock()
+
+			cancel()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{"/srv/app/src/index.js"}, names)
+
+	require.Equal(t, 1, strings.Count(buf.String(), "sync: could not run pwd in <service>web</service> pid1, using the Dockerfile working directory <dir>/srv/app</dir>"))
+}
+
+func TestStart2WorkdirRoot(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM example/private\nCOPY src .\n"), 0644))
+
+	p := &structs.MockProvider{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var names []string
+	var pwds int
+
+	uploaded := make(chan bool, 10)
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("WithContext", mock.Anything).Return(p)
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1"}}, nil)
+	p.On("ProcessExec", "app1", "pid1", "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("ProcessExec", "app1", "pid1", "pwd", mock.Anything, structs.ProcessExecOptions{}).Return(0, fmt.Errorf("exec: \"pwd\": executable file not found in $PATH")).Run(func(args mock.Arguments) {
+		lock.Lock()
+		pwds++
+		lock.Unlock()
+	})
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		n := len(names)
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			names = append(names, h.Name)
+		}
+
+		// the support probe uploads an empty archive
+		if len(names) > n {
+			uploaded <- true
+		}
+	})
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "example/private", "--format", "{{json .Config.Env}}").Return(nil, fmt.Errorf("no such image"))
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+		<-uploaded
+
+		// inotify events are shared by every watcher in the process so keep
+		// writing until one reaches this one
+		for i := 0; ; i++ {
+			os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte(fmt.Sprintf("index%d", i)), 0644)
+
+			select {
+			case <-uploaded:
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+
+	buf := bytes.Buffer{}
+
+	err = start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p})
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.GreaterOrEqual(t, len(names), 2)
+
+	for _, name := range names {
+		require.Equal(t, "/index.js", name)
+	}
+
+	require.Equal(t, 1, strings.Count(buf.String(), "sync: could not run pwd in <service>web</service> pid1 and the Dockerfile has no working directory, using <dir>/</dir>, set --remote-workdir if this is wrong"))
+
+	require.Equal(t, 1, pwds)
+}
+
+func TestStart2SyncVerify(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	download := func(name, data string) io.Reader {
+		var buf bytes.Buffer
+
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write([]byte(data))
+		tw.Close()
+
+		return &buf
+	}
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("FilesDownload", "app1", "pid1", "/app/src/index.js").Return(download("app/src/index.js", "index"), nil)
+	p.On("FilesDownload", "app1", "pid1", "/app/src/other.js").Return(download("app/src/other.js", "truncat"), nil).Run(func(args mock.Arguments) {
+		time.AfterFunc(500*time.Millisecond, cancel)
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.WriteFile(filepath.Join(tmp, "other.js"), []byte("truncated"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:        "app1",
+		Provider:   p,
+		VerifySync: true,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Contains(t, buf.String(), "sync verify error: <dir>other.js</dir> on <service>web</service>: md5 is 98b9de03f177e93b47d18c5d5af01140, expected ac273a9aa2a7a6e63ef477fa7f6d1980")
+	require.Contains(t, buf.String(), "sync: verified 1 files on <service>web</service>")
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2SyncExport(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var download bytes.Buffer
+
+	tw := tar.NewWriter(&download)
+	tw.WriteHeader(&tar.Header{Name: "app/src/", Mode: 0755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "app/src/index.js", Mode: 0644, Size: 5})
+	tw.Write([]byte("index"))
+	tw.Close()
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("FilesDownload", "app1", "pid1", "/app/src").Return(&download, nil).Run(func(args mock.Arguments) {
+		time.AfterFunc(500*time.Millisecond, cancel)
+	})
+
+	rp, wp := io.Pipe()
+	defer wp.Close()
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		wp.Write([]byte("export synced.tar\n"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Input:    rp,
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Contains(t, buf.String(), "export: wrote 1 files to <dir>synced.tar</dir>")
+
+	fd, err := os.Open(filepath.Join(dir, "synced.tar"))
+	require.NoError(t, err)
+	defer fd.Close()
+
+	files := map[string]string{}
+
+	tr := tar.NewReader(fd)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		files[h.Name] = string(data)
+	}
+
+	require.Equal(t, map[string]string{
+		"web/app/src/":         "",
+		"web/app/src/index.js": "index",
+	}, files)
+}
+
+func TestStart2SyncMirror(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var deleted []string
+
+	p.On("ProcessExec", "app1", "pid1", "find /app/src -type f", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil).Run(func(args mock.Arguments) {
+		fmt.Fprintf(args.Get(3).(io.Writer), "/app/src/index.js\n/app/src/stale.js\n/app/src/node_modules/lib.js\n")
+	})
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("FilesDelete", "app1", "pid1", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		lock.Lock()
+		deleted = append(deleted, args.Get(2).([]string)...)
+		lock.Unlock()
+
+		time.AfterFunc(500*time.Millisecond, cancel)
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:               "app1",
+		Provider:          p,
+		SyncMirror:        true,
+		SyncMirrorExclude: []string{"node_modules"},
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{"/app/src/stale.js"}, deleted)
+	require.Contains(t, buf.String(), "sync mirror: removing <dir>/app/src/stale.js</dir> on <service>web</service>")
+}
+
+func TestStart2SyncRestart(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	interval := *start.RestartPollInterval
+	*start.RestartPollInterval = 2 * time.Second
+	defer func() { *start.RestartPollInterval = interval }()
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte("index"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	lists := 0
+	synced := -1
+	var first time.Time
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(func(app string, opts structs.ProcessListOptions) structs.Processes {
+		lock.Lock()
+		defer lock.Unlock()
+
+		lists++
+
+		if first.IsZero() {
+			first = time.Now()
+		}
+
+		// the container restarts between the second and third tick of the
+		// watcher, which lists the processes only on the first and third
+		if time.Since(first) > 2500*time.Millisecond {
+			return structs.Processes{{Id: "pid2"}}
+		}
+
+		return structs.Processes{{Id: "pid1"}}
+	}, nil)
+	p.On("ProcessExec", "app1", mock.Anything, "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", mock.Anything, mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			io.Copy(io.Discard, tr)
+
+			if args.String(1) == "pid2" && h.Name == "/app/src/index.js" {
+				lock.Lock()
+				synced = lists
+				lock.Unlock()
+
+				cancel()
+			}
+		}
+	})
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p})
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// without changes the processes are not listed on every tick
+	require.Equal(t, 3, synced)
+	require.Contains(t, buf.String(), "restart detected: <service>web</service> is now running as pid2, resyncing <dir>src</dir>")
+}
+
+func TestStart2SyncDeletes(t *testing.T) {
+	tests := []struct {
+		Name          string
+		NoSyncDeletes bool
+		Deleted       []string
+	}{
+		{
+			Name:    "default",
+			Deleted: []string{"old.js"},
+		},
+		{
+			Name:          "no sync deletes",
+			NoSyncDeletes: true,
+			Deleted:       []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			common.ProviderWaitDuration = 1
+
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+			p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+
+			var lock sync.Mutex
+			deleted := []string{}
+			removed := 0
+
+			p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+				tr := tar.NewReader(args.Get(2).(io.Reader))
+
+				for {
+					h, err := tr.Next()
+					if err != nil {
+						break
+					}
+
+					io.Copy(io.Discard, tr)
+
+					if h.Name != "/app/src/old.js" {
+						continue
+					}
+
+					// recreated after being removed twice
+					lock.Lock()
+					if tt.NoSyncDeletes && removed >= 2 {
+						cancel()
+					}
+					lock.Unlock()
+				}
+			})
+			p.On("FilesDelete", "app1", "pid1", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				lock.Lock()
+				deleted = append(deleted, args.Get(2).([]string)...)
+				lock.Unlock()
+
+				cancel()
+			})
+
+			go func() {
+				time.Sleep(1500 * time.Millisecond)
+				tmp := filepath.Join(dir, "tmp")
+				os.MkdirAll(tmp, 0755)
+				os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+				os.Rename(tmp, filepath.Join(dir, "src"))
+
+				// events can be missed by the shared watcher, so keep touching
+				// files to trigger scans while old.js is created and deleted
+				for i := 0; ; i++ {
+					if (i/15)%2 == 0 {
+						os.WriteFile(filepath.Join(dir, "src", "old.js"), []byte("old"), 0644)
+					} else {
+						if i%15 == 0 {
+							lock.Lock()
+							removed++
+							lock.Unlock()
+						}
+
+						os.Remove(filepath.Join(dir, "src", "old.js"))
+						os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte("index"), 0644)
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(100 * time.Millisecond):
+					}
+				}
+			}()
+
+			buf := bytes.Buffer{}
+
+			opts := start.Options2{
+				App:           "app1",
+				NoSyncDeletes: tt.NoSyncDeletes,
+				Provider:      p,
+			}
+
+			err := start.New().Start2(ctx, &buf, opts)
+			require.NoError(t, err)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			require.Equal(t, tt.Deleted, deleted)
+		})
+	}
+}
+
+func TestDockerConfigAuth(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	config := `{
+		"auths": {
+			"inline.example.com": {"auth": "dXNlcjE6cGFzczE="},
+			"store.example.com": {},
+			"token.example.com": {}
+		},
+		"credHelpers": {"helper.example.com": "ecr-login"},
+		"credsStore": "desktop"
+	}`
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	creds := map[string]string{
+		"helper.example.com": `{"Username":"user2","Secret":"pass2"}`,
+		"store.example.com":  `{"Username":"user3","Secret":"pass3"}`,
+		"token.example.com":  `{"Username":"<token>","Secret":"token1"}`,
+	}
+
+	for _, helper := range []string{"docker-credential-desktop", "docker-credential-ecr-login"} {
+		e.On("Stream", mock.Anything, mock.Anything, helper, "get").Return(nil).Run(func(args mock.Arguments) {
+			host, err := io.ReadAll(args.Get(1).(io.Reader))
+			require.NoError(t, err)
+			fmt.Fprint(args.Get(0).(io.Writer), creds[string(host)])
+		})
+	}
+
+	var buf bytes.Buffer
+	pw := prefix.NewWriter(&buf, map[string]string{})
+
+	auth, err := start.DockerConfigAuth(&pw)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]start.RegistryAuth{
+		"helper.example.com": {Username: "user2", Password: "pass2"},
+		"inline.example.com": {Username: "user1", Password: "pass1"},
+		"store.example.com":  {Username: "user3", Password: "pass3"},
+	}, auth)
+}
+
+func TestStart2RunningTimeout(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n  worker:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testApp(&structs.App{Name: "app1", Generation: "2", Status: "updating"}))
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{}).Return(structs.Processes{
+		{Id: "pid1", Name: "web", Status: "crashed"},
+		{Id: "pid2", Name: "worker", Status: "running"},
+	}, nil)
+	p.On("ProcessLogs", "app1", "pid1", structs.LogsOptions{Follow: options.Bool(false), Previous: options.Bool(true), Tail: options.Int(20)}).Return(ioutil.NopCloser(strings.NewReader("listening\npanic: no database\n")), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Provider: p, RunningTimeout: 1 * time.Second})
+	require.EqualError(t, err, "timeout waiting for app to be running")
+
+	out := buf.String()
+
+	require.Contains(t, out, "<system>convox</system> | <error>app is not running after 1s</error>\n")
+	require.Contains(t, out, "<system>convox</system> | <service>web</service> pid1 is crashed\n")
+	require.Contains(t, out, "<system>convox</system> | <service>worker</service> pid2 is running\n")
+	require.Contains(t, out, "<system>convox</system> | last logs of <service>web</service> pid1:\n")
+	require.Contains(t, out, "<color3>web   </color3> | panic: no database\n")
+
+	p.AssertNotCalled(t, "ProcessLogs", "app1", "pid2", mock.Anything)
+}
+
+func TestStart2LogBuffer(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Status string
+		Dumped []string
+	}{
+		{
+			Name:   "none failing",
+			Status: "running",
+			Dumped: []string{
+				"<system>convox</system> | recent logs of <service>web</service>:",
+				"<color3>web   </color3> | web3",
+				"<color3>web   </color3> | web4",
+				"<system>convox</system> | recent logs of <service>worker</service>:",
+				"<color15>worker</color15> | worker1",
+			},
+		},
+		{
+			Name:   "worker failing",
+			Status: "crashed",
+			Dumped: []string{
+				"<system>convox</system> | recent logs of <service>worker</service>:",
+				"<color15>worker</color15> | worker1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			common.ProviderWaitDuration = 1
+
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n  worker:\n    image: httpd\n"), 0644))
+
+			cwd, err := os.Getwd()
+			require.NoError(t, err)
+			os.Chdir(dir)
+			defer os.Chdir(cwd)
+
+			logs := ""
+
+			for i := 1; i <= 4; i++ {
+				logs += fmt.Sprintf("0000-00-00T00:00:00Z service/web/pid1 web%d\n", i)
+			}
+
+			logs += "0000-00-00T00:00:00Z service/worker/pid2 worker1\n"
+
+			p := &structs.MockProvider{}
+
+			p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "updating"}, nil)
+			p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+			p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+			p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader(logs)), nil).Once()
+			p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+			p.On("ProcessList", "app1", structs.ProcessListOptions{}).Return(structs.Processes{
+				{Id: "pid1", Name: "web", Status: "running"},
+				{Id: "pid2", Name: "worker", Status: tt.Status},
+			}, nil)
+			p.On("ProcessLogs", "app1", "pid2", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			buf := bytes.Buffer{}
+
+			err = start.New().Start2(ctx, &buf, start.Options2{App: "app1", LogBuffer: 2, Provider: p, RunningTimeout: 2 * time.Second})
+			require.EqualError(t, err, "timeout waiting for app to be running")
+
+			out := buf.String()
+
+			i := strings.Index(out, "<system>convox</system> | recent logs of ")
+			require.GreaterOrEqual(t, i, 0)
+
+			require.Equal(t, strings.Join(append(tt.Dumped, ""), "\n"), out[i:])
+		})
+	}
+}
+
+func TestStart2CrashLoop(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	interval := *start.CrashSampleInterval
+	*start.CrashSampleInterval = 100 * time.Millisecond
+	defer func() { *start.CrashSampleInterval = interval }()
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n  worker:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testApp(&structs.App{Name: "app1", Generation: "2", Status: "updating"}))
+
+	var samples atomic.Int32
+
+	// web comes back up after each crash only to crash again
+	p.On("ProcessList", "app1", structs.ProcessListOptions{}).Return(func(string, structs.ProcessListOptions) structs.Processes {
+		status := "running"
+
+		if samples.Add(1)%2 == 1 {
+			status = "crashed"
+		}
+
+		return structs.Processes{
+			{Id: "pid1", Name: "web", Status: status},
+			{Id: "pid2", Name: "worker", Status: "running"},
+		}
+	}, nil)
+	p.On("ProcessLogs", "app1", "pid1", structs.LogsOptions{Follow: options.Bool(false), Previous: options.Bool(true), Tail: options.Int(20)}).Return(func(app, pid string, opts structs.LogsOptions) io.ReadCloser {
+		return ioutil.NopCloser(strings.NewReader("listening\nlisten tcp :3000: bind: address already in use\n"))
+	}, nil)
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", Provider: p, RunningTimeout: 1 * time.Second})
+	require.EqualError(t, err, "timeout waiting for app to be running")
+
+	out := buf.String()
+
+	require.Equal(t, 1, strings.Count(out, "<system>convox</system> | <error><service>web</service> is repeatedly restarting</error>\n"))
+	require.Contains(t, out, "<color3>web   </color3> | listen tcp :3000: bind: address already in use\n")
+	require.Contains(t, out, "<system>convox</system> | hint: the port may already be in use, check that only one process listens on each port\n")
+	require.NotContains(t, out, "<service>worker</service> is repeatedly restarting")
+}
+
+func TestStart2CrashLong(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	interval := *start.CrashSampleInterval
+	*start.CrashSampleInterval = 100 * time.Millisecond
+	defer func() { *start.CrashSampleInterval = interval }()
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testApp(&structs.App{Name: "app1", Generation: "2", Status: "updating"}))
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{}).Return(structs.Processes{{Id: "pid1", Name: "web", Status: "crashed"}}, nil)
+	p.On("ProcessLogs", "app1", "pid1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", Provider: p, RunningTimeout: 1 * time.Second})
+	require.EqualError(t, err, "timeout waiting for app to be running")
+
+	// a process that stays crashed over many samples has crashed only once
+	require.NotContains(t, buf.String(), "is repeatedly restarting")
+}
+
+func TestCrashHint(t *testing.T) {
+	tests := []struct {
+		Lines []string
+		Hint  string
+	}{
+		{[]string{"exec /app/bin/web: no such file or directory"}, "the command could not be run, check the entrypoint and command of the service"},
+		{[]string{"starting", "panic: DATABASE_URL is not set"}, "an environment variable may be missing, check convox env and the environment of the service in convox.yml"},
+		{[]string{"Error: listen EADDRINUSE: address already in use :::3000"}, "the port may already be in use, check that only one process listens on each port"},
+		{[]string{"panic: runtime error: index out of range"}, ""},
+		{nil, ""},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.Hint, start.CrashHint(test.Lines))
+	}
+}
+
+func TestStart2ValidationError(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n    deployment:\n      minimum: -1\n    resources:\n      - database\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir))
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", Provider: p})
+	require.EqualError(t, err, "validation errors:\nconvox.yml:5:7: service web deployment minimum can not be less than 0\nconvox.yml:6:5: service web references a resource that does not exist: database")
+}
+
+func TestStart2ManifestEncoding(t *testing.T) {
+	tests := []struct {
+		Name string
+		Data []byte
+		Err  string
+	}{
+		{
+			Name: "bom",
+			Data: []byte("\xef\xbb\xbfservices:\n  web:\n    image: httpd\n    deployment:\n      minimum: -1\n"),
+			Err:  "validation errors:\nconvox.yml:5:7: service web deployment minimum can not be less than 0",
+		},
+		{
+			Name: "binary",
+			Data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+			Err:  "convox.yml doesn't look like valid YAML, it has binary or non utf-8 content, check that it is the right file and that it is saved as utf-8",
+		},
+		{
+			Name: "utf16",
+			Data: []byte("\xff\xfes\x00e\x00r\x00"),
+			Err:  "convox.yml doesn't look like valid YAML, it has binary or non utf-8 content, check that it is the right file and that it is saved as utf-8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), tt.Data, 0644))
+
+			p, _ := testStart2Provider(t)
+
+			err := start.New().Start2(context.Background(), io.Discard, start.Options2{App: "app1", Dir: dir, Provider: p})
+			require.EqualError(t, err, tt.Err)
+		})
+	}
+}
+
+func TestStart2StrictEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd:${TAG}\n    environment:\n      - URL=${SCHEME}://${HOST}\n"), 0644))
+
+	p, _ := testStart2Provider(t, testRelease(&structs.Release{Env: "SCHEME=https"}))
+
+	err := start.New().Start2(context.Background(), io.Discard, start.Options2{App: "app1", Dir: dir, Provider: p, StrictEnv: true})
+	require.EqualError(t, err, "convox.yml references undefined environment variables: HOST, TAG")
+}
+
+func TestStart2SyncContainer(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var names []string
+
+	sidecar := options.String("sidecar")
+
+	p.On("ProcessList", "app1", structs.ProcessListOptions{Service: options.String("web")}).Return(structs.Processes{{Id: "pid1"}}, nil)
+	p.On("ProcessExec", "app1", "pid1", "true", mock.Anything, structs.ProcessExecOptions{Container: sidecar}).Return(0, nil)
+	p.On("ProcessExec", "app1", "pid1", "md5sum /app/src/index.js", mock.Anything, structs.ProcessExecOptions{Container: sidecar}).Return(0, nil).Run(func(args mock.Arguments) {
+		fmt.Fprintf(args.Get(3).(io.Writer), "%x  /app/src/index.js\n", md5.Sum([]byte("index")))
+		time.AfterFunc(500*time.Millisecond, cancel)
+	})
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{Container: sidecar}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			lock.Lock()
+			names = append(names, h.Name)
+			lock.Unlock()
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		os.WriteFile(filepath.Join(tmp, "index.js"), []byte("index"), 0644)
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:           "app1",
+		Provider:      p,
+		SyncContainer: map[string]string{"web": "sidecar"},
+		VerifySync:    true,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{"/app/src/index.js"}, names)
+	require.Contains(t, buf.String(), "sync: verified 1 files on <service>web</service>")
+
+	p.AssertNotCalled(t, "FilesDownload", "app1", "pid1", mock.Anything)
+}
+
+func TestStart2NoLogs(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", NoLogs: true, Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t, "<system>convox</system> | stopping\n", buf.String())
+
+	p.AssertNotCalled(t, "AppLogs", "app1", mock.Anything)
+}
+
+func TestStart2Attach(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testApp(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}), testRelease(&structs.Release{Build: "build1"}))
+
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Development: true}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(ctx, &buf, start.Options2{App: "app1", Attach: true, Dir: dir, NoLogs: true, Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t, "", buf.String())
+
+	p.AssertNotCalled(t, "ReleasePromote", "app1", mock.Anything, mock.Anything)
+}
+
+func TestStart2AutoReclaim(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	interval := *start.ReclaimInterval
+	*start.ReclaimInterval = 100 * time.Millisecond
+	defer func() { *start.ReclaimInterval = interval }()
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testApp(&structs.App{Name: "app1", Generation: "2", Release: "release3", Status: "running"}))
+
+	development := structs.ReleasePromoteOptions{Development: options.Bool(true), Force: options.Bool(true), Idle: options.Bool(false), Min: options.Int(0), Timeout: options.Int(300)}
+
+	p.On("ObjectStore", "app1", "", mock.Anything, structs.ObjectStoreOptions{}).Return(&structs.Object{Url: "object://app1/object1.tgz"}, nil)
+	p.On("BuildCreate", "app1", "object://app1/object1.tgz", structs.BuildCreateOptions{Development: options.Bool(true), External: options.Bool(false)}).Return(&structs.Build{Id: "build1"}, nil)
+	p.On("BuildLogs", "app1", "build1", structs.LogsOptions{}).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+	p.On("BuildGet", "app1", "build1").Return(&structs.Build{Id: "build1", Release: "release2", Status: "complete"}, nil)
+	p.On("ReleasePromote", "app1", "release2", development).Return(nil)
+	p.On("ReleasePromote", "app1", "release3", structs.ReleasePromoteOptions{Development: options.Bool(false), Force: options.Bool(true)}).Return(nil)
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:         "app1",
+		AutoReclaim: 2,
+		Build:       true,
+		Dir:         dir,
+		MaxDuration: 2 * time.Second,
+		NoLogs:      true,
+		Provider:    p,
+	}
+
+	err := start.New().Start2(context.Background(), &buf, opts)
+	require.NoError(t, err)
+
+	out := buf.String()
+
+	require.Contains(t, out, "<system>convox</system> | <error>app is running release release3 instead of development release release2, promoting it again (1/2)</error>\n")
+	require.Contains(t, out, "<system>convox</system> | <error>app is running release release3 instead of development release release2, promoting it again (2/2)</error>\n")
+	require.Contains(t, out, "<system>convox</system> | <error>app is still running release release3 after reclaiming development release release2 2 times, leaving it in place</error>\n")
+
+	p.AssertNumberOfCalls(t, "ReleasePromote", 4)
+}
+
+func TestStart2MaxDuration(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	p, _ := testStart2Provider(t, testApp(&structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"}))
+
+	p.On("ReleasePromote", "app1", "release1", structs.ReleasePromoteOptions{Development: options.Bool(false), Force: options.Bool(true)}).Return(nil)
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", Dir: dir, MaxDuration: 500 * time.Millisecond, NoLogs: true, Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<system>convox</system> | maximum duration of 500ms reached",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	p.AssertExpectations(t)
+}
+
+func TestStart2Outputs(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	logs := "0000-00-00T00:00:00Z service/web/pid1 log1\n0000-00-00T00:00:00Z service/web/pid1 log2\n"
+
+	p := &structs.MockProvider{}
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil).Once()
+	p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	buf := bytes.Buffer{}
+	web := bytes.Buffer{}
+
+	err := start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", Dir: dir, MaxDuration: 500 * time.Millisecond, Outputs: map[string]io.Writer{"web": &web}, Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<system>convox</system> | maximum duration of 500ms reached",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+
+	require.Equal(t,
+		[]string{
+			"<color3>web   </color3> | log1",
+			"<color3>web   </color3> | log2",
+		},
+		strings.Split(strings.TrimSuffix(web.String(), "\n"), "\n"),
+	)
+}
+
+func TestStart2CompressLogs(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    image: httpd\n"), 0644))
+
+	var gzlogs bytes.Buffer
+
+	gz := gzip.NewWriter(&gzlogs)
+	gz.Write([]byte("0000-00-00T00:00:00Z service/web/pid1 log1\n"))
+	gz.Close()
+
+	p := &structs.MockProvider{}
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2", Status: "running"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Compress: options.Bool(true), Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(&gzlogs), nil).Once()
+	p.On("AppLogs", "app1", structs.LogsOptions{Compress: options.Bool(true), Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader("0000-00-00T00:00:00Z service/web/pid1 log2\n")), nil).Once()
+	p.On("AppLogs", "app1", mock.Anything).Return(ioutil.NopCloser(strings.NewReader("")), nil)
+
+	buf := bytes.Buffer{}
+
+	err := start.New().Start2(context.Background(), &buf, start.Options2{App: "app1", CompressLogs: true, Dir: dir, MaxDuration: 1500 * time.Millisecond, Provider: p})
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<color3>web   </color3> | log1",
+			"<color3>web   </color3> | log2",
+			"<system>convox</system> | maximum duration of 1.5s reached",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+}
+
+func TestStart2AttachInvalid(t *testing.T) {
+	tests := []struct {
+		Name    string
+		App     *structs.App
+		Error   error
+		Build   bool
+		Release *structs.Release
+		Err     string
+	}{
+		{
+			Name:  "missing",
+			Error: fmt.Errorf("no such app: app1"),
+			Err:   "can not attach to app1: no such app: app1",
+		},
+		{
+			Name: "no release",
+			App:  &structs.App{Name: "app1", Generation: "2", Status: "running"},
+			Err:  "can not attach to app1: it has no release, run start with a build first",
+		},
+		{
+			Name: "updating",
+			App:  &structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "updating"},
+			Err:  "can not attach to app1: it is updating, wait for it to be running",
+		},
+		{
+			Name:  "build",
+			App:   &structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"},
+			Build: true,
+			Err:   "attach can not be used with a build",
+		},
+		{
+			Name:    "deployed",
+			App:     &structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"},
+			Release: &structs.Release{Id: "release1", Build: "build2"},
+			Err:     "can not attach to app1: release release1 is not in development mode, run start with a build first",
+		},
+		{
+			Name:    "no build",
+			App:     &structs.App{Name: "app1", Generation: "2", Release: "release1", Status: "running"},
+			Release: &structs.Release{Id: "release1"},
+			Err:     "can not attach to app1: release release1 is not in development mode, run start with a build first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			p := &structs.MockProvider{}
+
+			p.On("AppGet", "app1").Return(tt.App, tt.Error)
+			p.On("ReleaseGet", "app1", "release1").Return(tt.Release, nil)
+			// only the development flag of the build counts, not its description
+			p.On("BuildGet", "app1", "build2").Return(&structs.Build{Id: "build2", Description: "convox start"}, nil)
+
+			err := start.New().Start2(context.Background(), io.Discard, start.Options2{App: "app1", Attach: true, Build: tt.Build, Provider: p})
+			require.EqualError(t, err, tt.Err)
+
+			p.AssertNotCalled(t, "AppCreate", "app1", mock.Anything)
+			p.AssertNotCalled(t, "AppLogs", "app1", mock.Anything)
+		})
+	}
+}
+
+func TestStart2PreserveMtime(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		io.Copy(ioutil.Discard, args.Get(2).(io.Reader))
+	})
+	p.On("ProcessExec", "app1", "pid1", fmt.Sprintf("touch -c -d @%d /app/src/a.js /app/src/b.js", mtime.Unix()), mock.Anything, structs.ProcessExecOptions{}).Return(0, nil).Run(func(args mock.Arguments) {
+		time.AfterFunc(500*time.Millisecond, cancel)
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		os.MkdirAll(tmp, 0755)
+		for _, name := range []string{"a.js", "b.js"} {
+			os.WriteFile(filepath.Join(tmp, name), []byte(name), 0644)
+			os.Chtimes(filepath.Join(tmp, name), mtime, mtime)
+		}
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:           "app1",
+		PreserveMtime: true,
+		Provider:      p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	p.AssertCalled(t, "ProcessExec", "app1", "pid1", fmt.Sprintf("touch -c -d @%d /app/src/a.js /app/src/b.js", mtime.Unix()), mock.Anything, structs.ProcessExecOptions{})
+}
+
+func TestStart2Develop(t *testing.T) {
+	dir := t.TempDir()
+
+	mf := "develop:\n  logLineRegex: '^\\[(?P<service>[^:]+):(?P<process>[^\\]]+)\\] (?P<message>.*)$'\n  remoteWorkdir:\n    web: app\nservices:\n  web:\n    build: .\n"
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte(mf), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\n"), 0644))
+
+	p := &structs.MockProvider{}
+
+	logs := "[web:pid1] log1\n[web:pid1] log2\n"
+
+	p.On("AppGet", "app1").Return(&structs.App{Name: "app1", Generation: "2"}, nil)
+	p.On("ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}).Return(structs.Releases{{Id: "release1"}}, nil)
+	p.On("ReleaseGet", "app1", "release1").Return(&structs.Release{}, nil)
+	p.On("AppLogs", "app1", structs.LogsOptions{Prefix: options.Bool(true), Since: options.Duration(1 * time.Second)}).Return(ioutil.NopCloser(strings.NewReader(logs)), nil)
+
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}").Return([]byte(`[]`), nil)
+	e.On("Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}").Return([]byte(`/app/foo`), nil)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	err = start.New().Start2(context.Background(), &bytes.Buffer{}, start.Options2{App: "app1", Provider: p, Test: true})
+	require.EqualError(t, err, "remote workdir for web must be absolute: app")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:           "app1",
+		Provider:      p,
+		RemoteWorkdir: map[string]string{"web": "/srv/app"},
+		Test:          true,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"<color3>web   </color3> | log1",
+			"<color3>web   </color3> | log2",
+			"<system>convox</system> | stopping",
+		},
+		strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+	)
+}
+
+func TestStart2PreStart(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+
+	p, e := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	p.On("ProcessList", "app1", mock.Anything).Return(structs.Processes{}, nil)
+
+	e.On("Run", mock.Anything, "sh", "-c", "make Dockerfile").Return(nil).Run(func(args mock.Arguments) {
+		fmt.Fprintf(args.Get(0).(io.Writer), "generated\n")
+		os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app/src\n"), 0644)
+	})
+	e.On("Run", mock.Anything, "sh", "-c", "false").Return(fmt.Errorf("exit status 1"))
+
+	err := start.New().Start2(context.Background(), &bytes.Buffer{}, start.Options2{App: "app1", PreStart: "false", Provider: p})
+	require.EqualError(t, err, "pre-start failed: exit status 1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		PreStart: "make Dockerfile",
+		Provider: p,
+		Sync:     true,
+		Verbose:  true,
+	}
+
+	err = start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	require.Contains(t, buf.String(), "<system>convox</system> | running pre-start: <dir>make Dockerfile</dir>\n<system>convox</system> | generated\n")
+	require.Contains(t, buf.String(), "sync: sources for <service>web</service>")
+	require.NotContains(t, buf.String(), "sync error")
+}
+
+func TestStart2SyncBatches(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src/a /app/a\nCOPY src/b /app/b\n"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testProcesses("web", "pid1"), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var uploading, overlapped, uploads int
+
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		// the sync probe uploads an empty archive
+		if _, err := tar.NewReader(args.Get(2).(io.Reader)).Next(); err != nil {
+			return
+		}
+
+		io.Copy(io.Discard, args.Get(2).(io.Reader))
+
+		lock.Lock()
+		if uploading++; uploading > 1 {
+			overlapped++
+		}
+		lock.Unlock()
+
+		time.Sleep(2500 * time.Millisecond)
+
+		lock.Lock()
+		uploading--
+		if uploads++; uploads == 2 {
+			time.AfterFunc(500*time.Millisecond, cancel)
+		}
+		lock.Unlock()
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp")
+		for _, name := range []string{"a", "b"} {
+			os.MkdirAll(filepath.Join(tmp, name), 0755)
+			os.WriteFile(filepath.Join(tmp, name, "index.js"), []byte(name), 0644)
+		}
+		os.Rename(tmp, filepath.Join(dir, "src"))
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:      "app1",
+		Provider: p,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, 2, uploads)
+	require.Equal(t, 0, overlapped)
+	require.Contains(t, buf.String(), "sync: queueing changes to <service>web</service> behind the batch being uploaded")
+}
+
+func TestStart2Reconnect(t *testing.T) {
+	common.ProviderWaitDuration = 1
+
+	// an idle watcher only notices the outage when it lists the processes
+	interval := *start.RestartPollInterval
+	*start.RestartPollInterval = 1 * time.Second
+	defer func() { *start.RestartPollInterval = interval }()
+
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "convox.yml"), []byte("services:\n  web:\n    build: .\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM httpd\nCOPY src /app\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte("index"), 0644))
+
+	p, _ := testStart2Provider(t, testDir(dir), testWorkdir(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var down atomic.Bool
+	var lock sync.Mutex
+	var uploaded []string
+
+	p.On("ProcessList", "app1", mock.Anything).Return(
+		func(string, structs.ProcessListOptions) structs.Processes {
+			if down.Load() {
+				return nil
+			}
+			return structs.Processes{{Id: "pid1"}}
+		},
+		func(string, structs.ProcessListOptions) error {
+			if down.Load() {
+				return fmt.Errorf("dial tcp: connection refused")
+			}
+			return nil
+		},
+	)
+	p.On("ProcessExec", "app1", "pid1", "true", mock.Anything, structs.ProcessExecOptions{}).Return(0, nil)
+	p.On("FilesUpload", "app1", "pid1", mock.Anything, structs.FileTransferOptions{}).Return(nil).Run(func(args mock.Arguments) {
+		tr := tar.NewReader(args.Get(2).(io.Reader))
+
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				break
+			}
+
+			lock.Lock()
+			uploaded = append(uploaded, h.Name)
+			lock.Unlock()
+
+			time.AfterFunc(500*time.Millisecond, cancel)
+		}
+	})
+
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		down.Store(true)
+		time.Sleep(4 * time.Second)
+		down.Store(false)
+	}()
+
+	buf := bytes.Buffer{}
+
+	opts := start.Options2{
+		App:            "app1",
+		Provider:       p,
+		ReconnectAfter: 1 * time.Second,
+	}
+
+	err := start.New().Start2(ctx, &buf, opts)
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, []string{"/app/index.js"}, uploaded)
+	require.Equal(t, 1, strings.Count(buf.String(), "connection lost, reconnecting"))
+	require.Contains(t, buf.String(), "connection restored, resyncing")
+}
+
+var reBuildDuration = regexp.MustCompile(`completed in [0-9]+s$`)
+
+// buildLines splits output into lines, with the build duration that depends on
+// how long the test ran replaced by 1s
+func buildLines(out string) []string {
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+
+	for i := range lines {
+		lines[i] = reBuildDuration.ReplaceAllString(lines[i], "completed in 1s")
+	}
+
+	return lines
+}
+
+// testProvider is the app mocked by testStart2Provider, a running generation 2
+// app on release1 whose services are built from httpd unless changed by a
+// testProviderOption
+type testProvider struct {
+	app       *structs.App
+	dir       string
+	env       string
+	logs      string
+	processes map[string][]string
+	release   *structs.Release
+	services  []string
+	setup     func(p *structs.MockProvider, e *exec.MockInterface)
+	workdir   string
+}
+
+type testProviderOption func(*testProvider)
+
+// testApp replaces the app returned by AppGet
+func testApp(a *structs.App) testProviderOption {
+	return func(tp *testProvider) { tp.app = a }
+}
+
+// testDir changes to the app directory until the test ends
+func testDir(dir string) testProviderOption {
+	return func(tp *testProvider) { tp.dir = dir }
+}
+
+// testEnv sets the environment of the httpd image as docker inspect shows it
+func testEnv(env string) testProviderOption {
+	return func(tp *testProvider) { tp.env = env }
+}
+
+// testLogs sets the app logs
+func testLogs(logs string) testProviderOption {
+	return func(tp *testProvider) { tp.logs = logs }
+}
+
+// testProcesses lists pids as the processes of a service, each of which
+// answers the support probe that start runs before the first sync
+func testProcesses(service string, pids ...string) testProviderOption {
+	return func(tp *testProvider) {
+		tp.services = append(tp.services, service)
+		tp.processes[service] = pids
+	}
+}
+
+// testRelease replaces release1
+func testRelease(r *structs.Release) testProviderOption {
+	return func(tp *testProvider) { tp.release = r }
+}
+
+// testSetup registers the mocks of a test ahead of the shared ones so that
+// they are matched first, shared mocks of the same calls are left out
+func testSetup(fn func(p *structs.MockProvider, e *exec.MockInterface)) testProviderOption {
+	return func(tp *testProvider) { tp.setup = fn }
+}
+
+// testWorkdir sets the working directory of the httpd image
+func testWorkdir(wd string) testProviderOption {
+	return func(tp *testProvider) { tp.workdir = wd }
+}
+
+// testStart2Provider mocks what every start test needs, the app with its
+// latest release and logs, the docker inspect of httpd and the processes of
+// its services, so that a test only sets up what differs. the first matching
+// call is used, so a test that mocks one of these calls differently does so
+// with testSetup
+func testStart2Provider(t *testing.T, opts ...testProviderOption) (*structs.MockProvider, *exec.MockInterface) {
+	tp := &testProvider{
+		app:       &structs.App{Name: "app1", Generation: "2", Status: "running"},
+		env:       "[]",
+		processes: map[string][]string{},
+		release:   &structs.Release{},
+		workdir:   "/usr/local/apache2",
+	}
+
+	for _, opt := range opts {
+		opt(tp)
+	}
+
+	p := &structs.MockProvider{}
+	e := &exec.MockInterface{}
+	start.Exec = e
+
+	if tp.setup != nil {
+		tp.setup(p, e)
+	}
+
+	shared := func(m *mock.Mock, method string, args ...interface{}) *mock.Call {
+		for _, c := range m.ExpectedCalls {
+			if c.Method == method && len(c.Arguments) >= len(args) && mock.Arguments(args).Is(c.Arguments[:len(args)]...) {
+				return nil
+			}
+		}
+
+		return m.On(method, args...)
+	}
+
+	if c := shared(&p.Mock, "AppGet", "app1"); c != nil {
+		c.Return(tp.app, nil)
+	}
+	if c := shared(&p.Mock, "ReleaseList", "app1", structs.ReleaseListOptions{Limit: options.Int(1)}); c != nil {
+		c.Return(structs.Releases{{Id: "release1"}}, nil)
+	}
+	if c := shared(&p.Mock, "ReleaseGet", "app1", "release1"); c != nil {
+		c.Return(tp.release, nil)
+	}
+	if c := shared(&p.Mock, "AppLogs", "app1", mock.Anything); c != nil {
+		c.Return(ioutil.NopCloser(strings.NewReader(tp.logs)), nil).Maybe()
+	}
+	if c := shared(&p.Mock, "WithContext", mock.Anything); c != nil {
+		c.Return(p).Maybe()
+	}
+
+	for _, service := range tp.services {
+		ps := structs.Processes{}
+
+		for _, pid := range tp.processes[service] {
+			ps = append(ps, structs.Process{Id: pid})
+
+			if c := shared(&p.Mock, "ProcessExec", "app1", pid, "true", mock.Anything, structs.ProcessExecOptions{}); c != nil {
+				c.Return(0, nil)
+			}
+		}
+
+		if c := shared(&p.Mock, "ProcessList", "app1", structs.ProcessListOptions{Service: options.String(service)}); c != nil {
+			c.Return(ps, nil)
+		}
+	}
+
+	if c := shared(&e.Mock, "Execute", "docker", "inspect", "httpd", "--format", "{{json .Config.Env}}"); c != nil {
+		c.Return([]byte(tp.env), nil)
+	}
+	if c := shared(&e.Mock, "Execute", "docker", "inspect", "httpd", "--format", "{{.Config.WorkingDir}}"); c != nil {
+		c.Return([]byte(tp.workdir), nil)
+	}
+
+	if tp.dir != "" {
+		cwd, err := os.Getwd()
+		require.NoError(t, err)
+		require.NoError(t, os.Chdir(tp.dir))
+		t.Cleanup(func() { os.Chdir(cwd) })
+	}
+
+	return p, e
+}
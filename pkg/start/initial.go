@@ -0,0 +1,75 @@
+package start
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/prefix"
+)
+
+// initialSync uploads the files of every watched source to each process when
+// sync starts, a bounded number of processes at a time across all services,
+// with the progress counted over all of them
+type initialSync struct {
+	done  int
+	lock  sync.Mutex
+	slots chan struct{}
+	total int
+}
+
+func newInitialSync(concurrency int) *initialSync {
+	return &initialSync{slots: make(chan struct{}, concurrency)}
+}
+
+// sync uploads adds to a process in the background once a slot is free and
+// the process has had the sync startup delay to settle since it appeared,
+// holding the batch of its service like any other sync
+func (is *initialSync) sync(ctx context.Context, pw *prefix.Writer, opts Options2, w *watcher, service, pid, remote string, appeared time.Time, adds []changes.Change) {
+	if len(adds) == 0 {
+		return
+	}
+
+	is.lock.Lock()
+	is.total += len(adds)
+	is.lock.Unlock()
+
+	go func() {
+		select {
+		case <-time.After(time.Until(appeared.Add(opts.SyncStartupDelay))):
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case is.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		defer func() { <-is.slots }()
+
+		if !opts.batches.acquire(ctx, pw, service) {
+			return
+		}
+
+		err := opts.handleAdds(ctx, pid, remote, adds)
+		if err != nil {
+			pw.Writef("convox", "initial sync error: <service>%s</service> %s: %s\n", service, pid, err)
+		} else {
+			opts.Status.synced(w, service, pid, remote)
+		}
+
+		opts.syncEvents(ctx, service, pid, remote, adds, err)
+
+		opts.batches.release(service)
+
+		is.lock.Lock()
+		is.done += len(adds)
+		done, total := is.done, is.total
+		is.lock.Unlock()
+
+		pw.Writef("convox", "initial sync: %d/%d files\n", done, total)
+	}()
+}
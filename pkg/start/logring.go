@@ -0,0 +1,105 @@
+package start
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/convox/convox/pkg/prefix"
+)
+
+// maxLogBuffer bounds the number of log lines kept for each service
+const maxLogBuffer = 10000
+
+// logRing keeps the last lines logged by each service, keyed by their log
+// prefix, so that they can be shown again when start fails after they have
+// scrolled off
+type logRing struct {
+	lock     sync.Mutex
+	lines    map[string][]string
+	next     map[string]int
+	services []string
+	size     int
+}
+
+func newLogRing(size int) *logRing {
+	if size > maxLogBuffer {
+		size = maxLogBuffer
+	}
+
+	return &logRing{lines: map[string][]string{}, next: map[string]int{}, size: size}
+}
+
+func (r *logRing) add(service, line string) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	lines, ok := r.lines[service]
+	if !ok {
+		r.services = append(r.services, service)
+	}
+
+	if len(lines) < r.size {
+		r.lines[service] = append(lines, line)
+		return
+	}
+
+	lines[r.next[service]] = line
+	r.next[service] = (r.next[service] + 1) % r.size
+}
+
+// recent returns the buffered lines of a service from oldest to newest
+func (r *logRing) recent(service string) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	lines := r.lines[service]
+	n := r.next[service]
+
+	return append(append([]string{}, lines[n:]...), lines[:n]...)
+}
+
+// dump writes the buffered lines of the failing services, or of every service
+// that has logged when none of them has
+func (r *logRing) dump(pw *prefix.Writer, failing []string) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	services := append([]string{}, r.services...)
+	r.lock.Unlock()
+
+	sort.Strings(services)
+
+	named := map[string]bool{}
+
+	for _, s := range failing {
+		named[s] = true
+	}
+
+	dumped := []string{}
+
+	for _, s := range services {
+		if named[s] {
+			dumped = append(dumped, s)
+		}
+	}
+
+	if len(dumped) == 0 {
+		dumped = services
+	}
+
+	for _, s := range dumped {
+		lines := r.recent(s)
+
+		pw.Writef("convox", "recent logs of <service>%s</service>:\n", s)
+
+		for _, line := range lines {
+			pw.Writef(s, "%s\n", line)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package start
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/docker/engine/pkg/fileutils"
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// mirrorSync deletes the files under remote in a process that do not exist
+// locally, files that are ignored or match SyncMirrorExclude are kept
+func (opts Options2) mirrorSync(ctx context.Context, pw *prefix.Writer, service, pid, remote string, locals []changes.Change, ignores []string) {
+	if path.Clean(remote) == "/" {
+		pw.Writef("convox", "sync mirror: not mirroring / on <service>%s</service>\n", service)
+		return
+	}
+
+	stale, err := opts.staleFiles(ctx, pid, remote, locals, ignores)
+	if err != nil {
+		pw.Writef("convox", "sync mirror error: %s\n", err)
+		return
+	}
+
+	switch {
+	case len(stale) == 0:
+		return
+	case len(stale) > 3:
+		pw.Writef("convox", "sync mirror: removing %d files from <dir>%s</dir> on <service>%s</service>\n", len(stale), remote, service)
+	default:
+		for _, s := range stale {
+			pw.Writef("convox", "sync mirror: removing <dir>%s</dir> on <service>%s</service>\n", s, service)
+		}
+	}
+
+	if err := opts.deleteFiles(ctx, pid, stale); err != nil {
+		pw.Writef("convox", "sync mirror error: %s\n", err)
+	}
+}
+
+// staleFiles lists the files under remote in a process and returns those with
+// no local counterpart
+func (opts Options2) staleFiles(ctx context.Context, pid, remote string, locals []changes.Change, ignores []string) ([]string, error) {
+	var buf bytes.Buffer
+
+	code, err := opts.Provider.WithContext(ctx).ProcessExec(opts.App, pid, shellquote.Join("find", remote, "-type", "f"), &buf, opts.execOptions())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("could not list %s: exit %d", remote, code)
+	}
+
+	local := map[string]bool{}
+
+	for _, l := range locals {
+		local[filepath.ToSlash(l.Path)] = true
+	}
+
+	excludes := append(append([]string{}, ignores...), opts.SyncMirrorExclude...)
+
+	stale := []string{}
+
+	s := bufio.NewScanner(&buf)
+
+	for s.Scan() {
+		rel := strings.TrimPrefix(strings.TrimPrefix(s.Text(), strings.TrimSuffix(remote, "/")), "/")
+
+		if rel == "" || local[rel] {
+			continue
+		}
+
+		if match, _ := fileutils.Matches(rel, excludes); match {
+			continue
+		}
+
+		stale = append(stale, remoteJoin(remote, rel))
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return stale, nil
+}
@@ -0,0 +1,62 @@
+package start
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/structs"
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// touchFiles sets the modification time of uploaded files to that of their
+// local counterparts as FilesUpload is not guaranteed to keep it, files that
+// share a modification time are touched together
+func (opts Options2) touchFiles(p structs.Provider, pid, remote string, adds []changes.Change) error {
+	files := map[int64][]string{}
+
+	for _, add := range adds {
+		stat, err := os.Stat(filepath.Join(add.Base, add.Path))
+		if err != nil {
+			// removed since the upload
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return errors.WithStack(err)
+		}
+
+		mtime := stat.ModTime().Unix()
+
+		files[mtime] = append(files[mtime], remoteJoin(remote, add.Path))
+	}
+
+	mtimes := []int64{}
+
+	for mtime := range files {
+		mtimes = append(mtimes, mtime)
+	}
+
+	sort.Slice(mtimes, func(i, j int) bool { return mtimes[i] < mtimes[j] })
+
+	for _, mtime := range mtimes {
+		var buf bytes.Buffer
+
+		cmd := shellquote.Join(append([]string{"touch", "-c", "-d", fmt.Sprintf("@%d", mtime)}, files[mtime]...)...)
+
+		code, err := p.ProcessExec(opts.App, pid, cmd, &buf, opts.execOptions())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if code != 0 {
+			return errors.WithStack(fmt.Errorf("touch exited with %d: %s", code, strings.TrimSpace(buf.String())))
+		}
+	}
+
+	return nil
+}
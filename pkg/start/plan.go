@@ -0,0 +1,148 @@
+package start
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/manifest"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/pkg/errors"
+)
+
+// plan describes what start is going to do so that tools can inspect the
+// development setup of an app
+type plan struct {
+	App      string        `json:"app"`
+	Build    planBuild     `json:"build"`
+	Logs     planLogs      `json:"logs"`
+	Manifest string        `json:"manifest"`
+	Services []planService `json:"services"`
+	Sync     bool          `json:"sync"`
+}
+
+type planBuild struct {
+	Concurrency int  `json:"concurrency,omitempty"`
+	Enabled     bool `json:"enabled"`
+	External    bool `json:"external"`
+	Promote     bool `json:"promote"`
+}
+
+type planLogs struct {
+	Enabled           bool   `json:"enabled"`
+	JSON              bool   `json:"json"`
+	LineRegex         string `json:"line-regex,omitempty"`
+	MaxLinesPerSecond int    `json:"max-lines-per-second,omitempty"`
+	Raw               bool   `json:"raw"`
+	Since             string `json:"since,omitempty"`
+}
+
+type planService struct {
+	Container     string       `json:"container,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	Name          string       `json:"name"`
+	PostSync      string       `json:"post-sync,omitempty"`
+	RemoteWorkdir string       `json:"remote-workdir,omitempty"`
+	Sources       []planSource `json:"sources"`
+}
+
+type planSource struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	Skip   string `json:"skip,omitempty"`
+}
+
+// writePlan writes the plan of start as json to PlanOutput, or to w when it
+// is -
+func (opts Options2) writePlan(w io.Writer, pw *prefix.Writer, m *manifest.Manifest, root string, services map[string]bool) error {
+	p := opts.plan(pw, m, root, services)
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	data = append(data, '\n')
+
+	if opts.PlanOutput == "-" {
+		_, err := w.Write(data)
+		return errors.WithStack(err)
+	}
+
+	if err := os.WriteFile(opts.PlanOutput, data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (opts Options2) plan(pw *prefix.Writer, m *manifest.Manifest, root string, services map[string]bool) plan {
+	p := plan{
+		App: opts.App,
+		Build: planBuild{
+			Concurrency: opts.BuildConcurrency,
+			Enabled:     opts.Build,
+			External:    opts.External,
+			Promote:     opts.Build,
+		},
+		Logs: planLogs{
+			Enabled:           !opts.NoLogs,
+			JSON:              opts.ParseJSONLogs,
+			LineRegex:         opts.LogLineRegex,
+			MaxLinesPerSecond: opts.LogMaxLinesPerSecond,
+			Raw:               opts.RawLogs,
+		},
+		Manifest: opts.Manifest,
+		Services: []planService{},
+		Sync:     opts.Sync,
+	}
+
+	if opts.LogsSince > 0 {
+		p.Logs.Since = opts.LogsSince.String()
+	}
+
+	for i := range m.Services {
+		s := &m.Services[i]
+
+		if !services[s.Name] {
+			continue
+		}
+
+		ps := planService{
+			Container:     opts.SyncContainer[s.Name],
+			Name:          s.Name,
+			PostSync:      opts.PostSyncExec[s.Name],
+			RemoteWorkdir: opts.RemoteWorkdir[s.Name],
+			Sources:       []planSource{},
+		}
+
+		if s.Build.Path != "" || len(volumeSources(s, root)) > 0 {
+			bss, err := buildSources(pw, m, root, s.Name)
+			if err != nil {
+				ps.Error = err.Error()
+			}
+
+			for _, bs := range bss {
+				src := planSource{Local: relativePath(root, bs.Local), Remote: common.CoalesceString(bs.Remote, ".")}
+
+				if wd := ps.RemoteWorkdir; wd != "" && !remoteIsAbs(src.Remote) {
+					src.Remote = remoteJoin(wd, src.Remote)
+				}
+
+				switch {
+				case bs.Extract:
+					src.Skip = "extracted by ADD"
+				case opts.StrictSync && outsideRoot(root, bs.Local):
+					src.Skip = "outside of app directory"
+				}
+
+				ps.Sources = append(ps.Sources, src)
+			}
+		}
+
+		p.Services = append(p.Services, ps)
+	}
+
+	return p
+}
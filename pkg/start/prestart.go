@@ -0,0 +1,28 @@
+package start
+
+import (
+	"fmt"
+
+	"github.com/convox/convox/pkg/prefix"
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// preStart runs the PreStart command locally before the build and before the
+// Dockerfiles are read for sync, so that generated Dockerfiles are found
+func (opts Options2) preStart(pw *prefix.Writer) error {
+	pw.Writef("convox", "running pre-start: <dir>%s</dir>\n", opts.PreStart)
+
+	cmd := opts.PreStart
+
+	// exec has no working directory so the command changes into Dir itself
+	if opts.Dir != "" {
+		cmd = fmt.Sprintf("cd %s && %s", shellquote.Join(opts.Dir), cmd)
+	}
+
+	if err := Exec.Run(pw.Writer("convox"), "sh", "-c", cmd); err != nil {
+		return errors.WithStack(fmt.Errorf("pre-start failed: %s", err))
+	}
+
+	return nil
+}
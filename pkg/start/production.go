@@ -0,0 +1,63 @@
+package start
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+	"github.com/pkg/errors"
+)
+
+// validateProduction checks that a production deploy has a build to promote
+// and nothing that needs the app in development mode
+func (opts Options2) validateProduction() error {
+	if !opts.Build {
+		return errors.WithStack(fmt.Errorf("production requires a build"))
+	}
+
+	if opts.Attach {
+		return errors.WithStack(fmt.Errorf("production can not be used with attach"))
+	}
+
+	if opts.Test {
+		return errors.WithStack(fmt.Errorf("production can not be used with test"))
+	}
+
+	return nil
+}
+
+// production promotes a regular release built from bopts and waits for the
+// app to be running, the release is left in place and no watchers are started
+func (opts Options2) production(ctx context.Context, pw *prefix.Writer, bopts structs.BuildCreateOptions) error {
+	b, err := opts.buildCreate(ctx, pw, bopts)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	default:
+	}
+
+	pw.Writef("convox", "promoting %s\n", b.Release)
+
+	_, span := opts.startSpan(ctx, "start.promote", map[string]interface{}{"app": opts.App, "release": b.Release})
+
+	err = opts.Provider.ReleasePromote(opts.App, b.Release, structs.ReleasePromoteOptions{})
+
+	span.End(err)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := opts.waitForRunning(ctx, pw); err != nil {
+		return errors.WithStack(err)
+	}
+
+	pw.Writef("convox", "deployed %s\n", b.Release)
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package start
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/convox/convox/pkg/prefix"
+)
+
+var reReadOnly = regexp.MustCompile(`(?i)read-only file ?system`)
+
+// syncRootPath moves a sync destination of a service with a sync root into
+// it, a relative destination is resolved against the sync root and one inside
+// the working directory of the Dockerfile keeps its place relative to it, so
+// that a container with a read-only root filesystem is synced into the
+// writable volume that holds its code
+func (opts Options2) syncRootPath(service string, bs buildSource) (string, bool) {
+	root, ok := opts.SyncRoot[service]
+	if !ok {
+		return "", false
+	}
+
+	if !remoteIsAbs(bs.Remote) {
+		return remoteJoin(root, bs.Remote), true
+	}
+
+	if bs.Workdir != "" && remoteWithin(bs.Workdir, bs.Remote) {
+		rel := strings.TrimPrefix(bs.Remote[len(strings.TrimSuffix(bs.Workdir, "/")):], "/")
+		return remoteJoin(root, rel), true
+	}
+
+	return bs.Remote, true
+}
+
+// readOnlyHint points at --sync-root once per service when a sync failed
+// because the container has a read-only filesystem
+func (opts Options2) readOnlyHint(pw *prefix.Writer, service, remote string, err error) {
+	if err == nil || !reReadOnly.MatchString(err.Error()) {
+		return
+	}
+
+	if _, hinted := opts.readOnly.LoadOrStore(service, true); hinted {
+		return
+	}
+
+	pw.Writef("convox", "hint: <dir>%s</dir> is read-only in <service>%s</service>, use --sync-root %s=<path> to sync into a writable volume instead\n", remote, service, service)
+}
@@ -0,0 +1,60 @@
+package start
+
+import (
+	"context"
+	"time"
+
+	"github.com/convox/convox/pkg/prefix"
+)
+
+// reclaimInterval is how often the release of the app is checked against the
+// release that start promoted when AutoReclaim is set
+var reclaimInterval = 30 * time.Second
+
+// reclaimRelease promotes the development release again when the app has
+// been deployed over, up to AutoReclaim times. a release is only reclaimed
+// once the app is running it and it is seen on two checks in a row, so that
+// a deploy in progress or a rebuild of start itself is not fought over
+func (opts Options2) reclaimRelease(ctx context.Context, pw *prefix.Writer) {
+	drifted := ""
+	reclaims := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reclaimInterval):
+		}
+
+		promoted, _ := opts.promoted.Load().(string)
+		if promoted == "" {
+			continue
+		}
+
+		a, err := opts.Provider.AppGet(opts.App)
+		if err != nil || a.Status != "running" || a.Release == "" || a.Release == promoted {
+			drifted = ""
+			continue
+		}
+
+		if a.Release != drifted {
+			drifted = a.Release
+			continue
+		}
+
+		if reclaims >= opts.AutoReclaim {
+			pw.Writef("convox", "<error>app is still running release %s after reclaiming development release %s %d times, leaving it in place</error>\n", a.Release, promoted, reclaims)
+			return
+		}
+
+		reclaims++
+
+		pw.Writef("convox", "<error>app is running release %s instead of development release %s, promoting it again (%d/%d)</error>\n", a.Release, promoted, reclaims, opts.AutoReclaim)
+
+		if err := opts.Provider.ReleasePromote(opts.App, promoted, opts.promoteOptions(true)); err != nil {
+			pw.Writef("convox", "could not promote %s: %s\n", promoted, err)
+		}
+
+		drifted = ""
+	}
+}
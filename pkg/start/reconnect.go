@@ -0,0 +1,103 @@
+package start
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+)
+
+const reconnectAfter = 10 * time.Second
+
+// connection tracks how long the rack has been failing to answer the
+// watchers so that a dropped connection pauses sync instead of erroring on
+// every batch
+type connection struct {
+	lock    sync.Mutex
+	failing time.Time
+	lost    bool
+}
+
+func (c *connection) failed() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.failing.IsZero() {
+		c.failing = time.Now()
+	}
+}
+
+func (c *connection) ok() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failing = time.Time{}
+}
+
+func (c *connection) isLost() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.lost
+}
+
+// lose marks the connection as lost once it has been failing for at least
+// after, returning true only for the call that does so
+func (c *connection) lose(after time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.lost || c.failing.IsZero() || time.Since(c.failing) < after {
+		return false
+	}
+
+	c.lost = true
+
+	return true
+}
+
+func (c *connection) restore() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failing = time.Time{}
+	c.lost = false
+}
+
+// superviseConnection holds the watchers while the rack is unreachable and
+// resumes them with a full resync once it answers again
+func (opts Options2) superviseConnection(ctx context.Context, pw *prefix.Writer) {
+	after := opts.ReconnectAfter
+
+	if after <= 0 {
+		after = reconnectAfter
+	}
+
+	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if !opts.connection.isLost() {
+				if opts.connection.lose(after) {
+					pw.Writef("convox", "<error>connection lost, reconnecting</error>\n")
+				}
+				continue
+			}
+
+			if _, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{}); err != nil {
+				continue
+			}
+
+			opts.connection.restore()
+			opts.control.resyncAll()
+
+			pw.Writef("convox", "connection restored, resyncing\n")
+		}
+	}
+}
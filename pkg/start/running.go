@@ -0,0 +1,283 @@
+package start
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/options"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+	"github.com/pkg/errors"
+)
+
+const (
+	crashRestarts   = 3
+	runningLogLines = 20
+)
+
+// crashSampleInterval is how often the processes of an app that is not yet
+// running are checked for crash loops
+var crashSampleInterval = 10 * time.Second
+
+// crashHints are the likely causes of a crash loop, matched against the last
+// logs of the crashing process
+var crashHints = []struct {
+	match *regexp.Regexp
+	hint  string
+}{
+	{regexp.MustCompile(`(?i)exec format error|executable file not found|no such file or directory|command not found|permission denied`), "the command could not be run, check the entrypoint and command of the service"},
+	{regexp.MustCompile(`(?i)environment variable|env var|is not set|must be set|missing required|keyerror`), "an environment variable may be missing, check convox env and the environment of the service in convox.yml"},
+	{regexp.MustCompile(`(?i)address already in use|eaddrinuse|bind: `), "the port may already be in use, check that only one process listens on each port"},
+}
+
+// waitForRunning waits for the app to be running, after RunningTimeout it
+// reports the state of each process and the last logs of any that are not
+// running instead of waiting on
+func (opts Options2) waitForRunning(ctx context.Context, pw *prefix.Writer) (err error) {
+	ctx, span := opts.startSpan(ctx, "start.wait", map[string]interface{}{"app": opts.App})
+	defer func() { span.End(err) }()
+
+	cctx, ccancel := context.WithCancel(ctx)
+	defer ccancel()
+
+	go opts.watchCrashes(cctx, pw)
+
+	if opts.RunningTimeout <= 0 {
+		return common.WaitForAppRunningContext(ctx, opts.Provider, opts.App)
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, opts.RunningTimeout)
+	defer cancel()
+
+	if err := common.WaitForAppRunningContext(wctx, opts.Provider, opts.App); err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil || wctx.Err() == nil {
+		return nil
+	}
+
+	pw.Writef("convox", "<error>app is not running after %s</error>\n", opts.RunningTimeout)
+
+	failing := opts.runningDiagnostics(pw)
+
+	return servicesError{err: fmt.Errorf("timeout waiting for app to be running"), services: failing}
+}
+
+// servicesError is an error caused by the processes of some services, which
+// are named so that their recent logs can be shown without parsing the error
+type servicesError struct {
+	err      error
+	services []string
+}
+
+func (e servicesError) Error() string {
+	return e.err.Error()
+}
+
+func (e servicesError) Unwrap() error {
+	return e.err
+}
+
+// failingServices returns the log prefixes of the services named by err
+func (opts Options2) failingServices(err error) []string {
+	var se servicesError
+
+	if !errors.As(err, &se) {
+		return nil
+	}
+
+	prefixes := []string{}
+
+	for _, s := range se.services {
+		prefixes = append(prefixes, opts.logPrefix(s))
+	}
+
+	return prefixes
+}
+
+// runningDiagnostics writes the status of each process of the app along with
+// the logs of the previous container of those that are not running, and
+// returns the services of those
+func (opts Options2) runningDiagnostics(pw *prefix.Writer) []string {
+	pss, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{})
+	if err != nil {
+		pw.Writef("convox", "could not list processes: %s\n", err)
+		return nil
+	}
+
+	for _, ps := range pss {
+		pw.Writef("convox", "<service>%s</service> %s is %s\n", ps.Name, ps.Id, ps.Status)
+	}
+
+	failing := []string{}
+	seen := map[string]bool{}
+
+	for _, ps := range pss {
+		if ps.Status == "running" {
+			continue
+		}
+
+		opts.previousLogs(pw, ps)
+
+		if !seen[ps.Name] {
+			seen[ps.Name] = true
+			failing = append(failing, ps.Name)
+		}
+	}
+
+	return failing
+}
+
+// previousLogs writes the last logs of the previous container of a process
+// and returns them
+func (opts Options2) previousLogs(pw *prefix.Writer, ps structs.Process) []string {
+	lopts := structs.LogsOptions{
+		Follow:   options.Bool(false),
+		Previous: options.Bool(true),
+		Tail:     options.Int(runningLogLines),
+	}
+
+	r, err := opts.Provider.ProcessLogs(opts.App, ps.Id, lopts)
+	if err != nil {
+		pw.Writef("convox", "could not get logs for %s: %s\n", ps.Id, err)
+		return nil
+	}
+	defer r.Close()
+
+	pw.Writef("convox", "last logs of <service>%s</service> %s:\n", ps.Name, ps.Id)
+
+	lines := []string{}
+
+	s := bufio.NewScanner(r)
+
+	s.Buffer(make([]byte, ScannerStartSize), ScannerMaxSize)
+
+	for s.Scan() {
+		pw.Writef(ps.Name, "%s\n", s.Text())
+		lines = append(lines, s.Text())
+	}
+
+	return lines
+}
+
+// watchCrashes samples the processes of the app until ctx is done and reports
+// each service that keeps crashing or being replaced with the last logs of
+// its process and a hint at the likely cause, so that a crash loop does not
+// look like a slow deploy
+func (opts Options2) watchCrashes(ctx context.Context, pw *prefix.Writer) {
+	down := map[string]string{}
+	restarts := map[string]int{}
+	reported := map[string]bool{}
+	statuses := map[string]string{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(crashSampleInterval):
+		}
+
+		pss, err := opts.Provider.ProcessList(opts.App, structs.ProcessListOptions{})
+		if err != nil {
+			continue
+		}
+
+		current := map[string]bool{}
+
+		for _, ps := range pss {
+			current[ps.Id] = true
+		}
+
+		// processes that were not running and are gone are being replaced
+		replaced := map[string]int{}
+
+		for id, service := range down {
+			if !current[id] {
+				replaced[service]++
+				delete(down, id)
+			}
+		}
+
+		for id := range statuses {
+			if !current[id] {
+				delete(statuses, id)
+			}
+		}
+
+		for _, ps := range pss {
+			previous, seen := statuses[ps.Id]
+			statuses[ps.Id] = ps.Status
+
+			crashed := ps.Status == "crashed" || ps.Status == "failed"
+
+			// a restart is counted once, either when a new process replaces one
+			// that was down or when a process goes on to crash, however many
+			// samples it stays crashed for
+			switch {
+			case !seen && replaced[ps.Name] > 0:
+				replaced[ps.Name]--
+				restarts[ps.Name]++
+			case crashed && previous != "crashed" && previous != "failed":
+				restarts[ps.Name]++
+			}
+
+			if ps.Status == "running" || ps.Status == "complete" {
+				delete(down, ps.Id)
+				continue
+			}
+
+			down[ps.Id] = ps.Name
+
+			if reported[ps.Name] || restarts[ps.Name] < crashRestarts || ctx.Err() != nil {
+				continue
+			}
+
+			reported[ps.Name] = true
+
+			pw.Writef("convox", "<error><service>%s</service> is repeatedly restarting</error>\n", ps.Name)
+
+			if hint := crashHint(opts.previousLogs(pw, ps)); hint != "" {
+				pw.Writef("convox", "hint: %s\n", hint)
+			}
+		}
+	}
+}
+
+// crashHint returns the likely cause of a crash loop from the last logs of the
+// crashing process, or an empty string when there is no telling
+func crashHint(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, h := range crashHints {
+			if h.match.MatchString(lines[i]) {
+				return h.hint
+			}
+		}
+	}
+
+	return ""
+}
+
+// checkRelease warns when the app is running a release other than the one
+// that start promoted and the one it replaced, which the app can still report
+// while the promote rolls out, e.g. when a teammate has deployed over the
+// development release
+func (opts Options2) checkRelease(pw *prefix.Writer) {
+	promoted, _ := opts.promoted.Load().(string)
+	if promoted == "" {
+		return
+	}
+
+	a, err := opts.Provider.AppGet(opts.App)
+	if err != nil {
+		return
+	}
+
+	if a.Release != "" && a.Release != promoted && a.Release != opts.replaced {
+		pw.Writef("convox", "<error>app is running release %s instead of development release %s, it may have been deployed over</error>\n", a.Release, promoted)
+	}
+}
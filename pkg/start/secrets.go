@@ -0,0 +1,84 @@
+package start
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var reBuildSecretId = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// buildSecrets resolves BuildSecrets to id=value pairs for the rack builder,
+// a secret given as an id is read from the environment variable of that name
+// and one given as id=path from the file at path relative to the app
+func (opts Options2) buildSecrets() ([]string, error) {
+	root, err := opts.root()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []string{}
+
+	for _, s := range opts.BuildSecrets {
+		id, path, file := strings.Cut(s, "=")
+
+		if !reBuildSecretId.MatchString(id) {
+			return nil, errors.WithStack(fmt.Errorf("invalid build secret id: %s", id))
+		}
+
+		if !file {
+			v, ok := os.LookupEnv(id)
+			if !ok {
+				return nil, errors.WithStack(fmt.Errorf("build secret %s is not set", id))
+			}
+
+			secrets = append(secrets, fmt.Sprintf("%s=%s", id, v))
+			continue
+		}
+
+		data, err := os.ReadFile(rootPath(root, path))
+		if err != nil {
+			return nil, errors.WithStack(fmt.Errorf("could not read build secret %s: %s", id, err))
+		}
+
+		secrets = append(secrets, fmt.Sprintf("%s=%s", id, data))
+	}
+
+	return secrets, nil
+}
+
+// secretExcludes returns the files of BuildSecrets that are inside the app as
+// patterns that keep them out of the uploaded build source
+func (opts Options2) secretExcludes() ([]string, error) {
+	root, err := opts.root()
+	if err != nil {
+		return nil, err
+	}
+
+	excludes := []string{}
+
+	for _, s := range opts.BuildSecrets {
+		if _, path, ok := strings.Cut(s, "="); ok && !outsideRoot(root, rootPath(root, path)) {
+			excludes = append(excludes, filepath.ToSlash(relativePath(root, rootPath(root, path))))
+		}
+	}
+
+	return excludes, nil
+}
+
+// secretValues returns the values of id=value secrets
+func secretValues(secrets []string) []string {
+	values := []string{}
+
+	for _, s := range secrets {
+		if _, v, ok := strings.Cut(s, "="); ok {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
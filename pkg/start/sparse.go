@@ -0,0 +1,197 @@
+package start
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sparseBlockSize = 512
+	sparseMinSize   = 1024 * 1024
+	sparseMaxStored = 1<<33 - 1
+)
+
+type sparseSegment struct {
+	Offset int64
+	Length int64
+}
+
+// sparseSegments returns the data segments of a file that has holes, or nil
+// when the file is too small to bother or has no holes
+func sparseSegments(fd *os.File, size int64) ([]sparseSegment, error) {
+	if size < sparseMinSize {
+		return nil, nil
+	}
+
+	segs, err := dataSegments(fd, size)
+	if err != nil || segs == nil {
+		return nil, err
+	}
+
+	stored := int64(0)
+
+	for _, s := range segs {
+		stored += s.Length
+	}
+
+	if stored >= size {
+		return nil, nil
+	}
+
+	// gnu tar marks a trailing hole with an empty segment at the end
+	if n := len(segs); n == 0 || segs[n-1].Offset+segs[n-1].Length < size {
+		segs = append(segs, sparseSegment{Offset: size})
+	}
+
+	return segs, nil
+}
+
+// writeSparse writes fd as a pax 1.0 sparse entry so that its holes are not
+// sent, archive/tar can read these but does not write them so the extended
+// header is written to w directly after flushing tw, w must be the writer that
+// tw writes to. records, such as xattrs, go into the same extended header
+func writeSparse(w io.Writer, tw *tar.Writer, name string, stat os.FileInfo, fd *os.File, segs []sparseSegment, records map[string]string, buf []byte) (bool, error) {
+	var smap bytes.Buffer
+
+	fmt.Fprintf(&smap, "%d\n", len(segs))
+
+	stored := int64(0)
+
+	for _, s := range segs {
+		fmt.Fprintf(&smap, "%d\n%d\n", s.Offset, s.Length)
+		stored += s.Length
+	}
+
+	smap.Write(make([]byte, sparsePad(int64(smap.Len()))))
+
+	// leave anything that needs its own extended headers to archive/tar
+	if stored+int64(smap.Len()) > sparseMaxStored {
+		return false, nil
+	}
+
+	dir, base := path.Split(name)
+
+	sname := path.Join(dir, "GNUSparseFile.0", base)
+	xname := path.Join(dir, "PaxHeaders.0", base)
+
+	// both placeholder names must fit the ustar name field without a prefix
+	if len(sname) > 100 || len(xname) > 100 {
+		return false, nil
+	}
+
+	var pax bytes.Buffer
+
+	for _, kv := range [][2]string{
+		{"GNU.sparse.major", "1"},
+		{"GNU.sparse.minor", "0"},
+		{"GNU.sparse.name", name},
+		{"GNU.sparse.realsize", strconv.FormatInt(stat.Size(), 10)},
+	} {
+		pax.WriteString(paxRecord(kv[0], kv[1]))
+	}
+
+	keys := make([]string, 0, len(records))
+
+	for k := range records {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		pax.WriteString(paxRecord(k, records[k]))
+	}
+
+	if int64(pax.Len()) > sparseMaxStored {
+		return false, nil
+	}
+
+	if err := tw.Flush(); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	// archive/tar rounds the mtime of the regular entries the same way
+	mtime := stat.ModTime().Round(time.Second)
+
+	header := ustarHeader(xname, tar.TypeXHeader, 0644, int64(pax.Len()), mtime.Unix())
+
+	pax.Write(make([]byte, sparsePad(int64(pax.Len()))))
+
+	if _, err := w.Write(append(header, pax.Bytes()...)); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	err := tw.WriteHeader(&tar.Header{
+		Format:   tar.FormatUSTAR,
+		Name:     sname,
+		Mode:     int64(stat.Mode().Perm()),
+		Size:     stored + int64(smap.Len()),
+		ModTime:  mtime,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	if _, err := tw.Write(smap.Bytes()); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for _, s := range segs {
+		if _, err := io.CopyBuffer(tw, io.NewSectionReader(fd, s.Offset, s.Length), buf); err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+
+	return true, nil
+}
+
+func sparsePad(n int64) int64 {
+	return -n & (sparseBlockSize - 1)
+}
+
+// paxRecord formats a record whose length prefix includes its own digits
+func paxRecord(k, v string) string {
+	n := len(k) + len(v) + 3
+
+	for size := n + len(strconv.Itoa(n)); ; size++ {
+		if len(strconv.Itoa(size))+n == size {
+			return fmt.Sprintf("%d %s=%s\n", size, k, v)
+		}
+	}
+}
+
+// ustarHeader builds the header block of an entry whose name fits in the 100
+// bytes of the ustar name field
+func ustarHeader(name string, typeflag byte, mode, size, mtime int64) []byte {
+	h := make([]byte, sparseBlockSize)
+
+	copy(h[0:], name)
+	copy(h[100:], fmt.Sprintf("%07o\x00", mode))
+	copy(h[108:], fmt.Sprintf("%07o\x00", 0))
+	copy(h[116:], fmt.Sprintf("%07o\x00", 0))
+	copy(h[124:], fmt.Sprintf("%011o\x00", size))
+	copy(h[136:], fmt.Sprintf("%011o\x00", mtime))
+	copy(h[148:], "        ")
+	h[156] = typeflag
+	copy(h[257:], "ustar\x0000")
+
+	sum := 0
+
+	for _, b := range h {
+		sum += int(b)
+	}
+
+	copy(h[148:], fmt.Sprintf("%06o\x00 ", sum))
+
+	return h
+}
@@ -0,0 +1,199 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package start_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/start"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteArchiveSparse(t *testing.T) {
+	dir := t.TempDir()
+
+	size := int64(8 * 1024 * 1024)
+
+	fd, err := os.Create(filepath.Join(dir, "data.db"))
+	require.NoError(t, err)
+	require.NoError(t, fd.Truncate(size))
+	_, err = fd.WriteAt([]byte("head"), 0)
+	require.NoError(t, err)
+	_, err = fd.WriteAt([]byte("middle"), 4*1024*1024)
+	require.NoError(t, err)
+	require.NoError(t, fd.Sync())
+
+	if hole, err := unix.Seek(int(fd.Fd()), 0, unix.SEEK_HOLE); err != nil || hole >= size {
+		fd.Close()
+		t.Skip("filesystem does not report holes")
+	}
+
+	fd.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small.txt"), []byte("small"), 0644))
+
+	adds := []changes.Change{
+		{Base: dir, Path: "small.txt"},
+		{Base: dir, Path: "data.db"},
+		{Base: dir, Path: "small.txt"},
+	}
+
+	var buf bytes.Buffer
+
+	require.NoError(t, start.WriteArchive(&buf, "/app", adds, false, true, false, 32*1024))
+	require.Less(t, buf.Len(), 1024*1024)
+
+	local, err := os.ReadFile(filepath.Join(dir, "data.db"))
+	require.NoError(t, err)
+
+	tr := tar.NewReader(&buf)
+
+	for _, name := range []string{"/app/small.txt", "/app/data.db", "/app/small.txt"} {
+		h, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, name, h.Name)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		if name == "/app/data.db" {
+			require.Equal(t, size, h.Size)
+			require.Equal(t, local, data)
+		} else {
+			require.Equal(t, "small", string(data))
+		}
+	}
+
+	_, err = tr.Next()
+	require.Equal(t, io.EOF, err)
+
+	buf.Reset()
+
+	require.NoError(t, start.WriteArchive(&buf, "/app", adds, false, false, false, 32*1024))
+	require.Greater(t, buf.Len(), int(size))
+}
+
+func TestWriteArchiveSparseExtract(t *testing.T) {
+	out, err := exec.Command("tar", "--version").CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "GNU tar") {
+		t.Skip("gnu tar is not available")
+	}
+
+	dir := t.TempDir()
+
+	size := int64(8 * 1024 * 1024)
+
+	long := strings.Repeat("d", 90)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, long), 0755))
+
+	testSparseFile(t, filepath.Join(dir, "data.db"), size)
+	testSparseFile(t, filepath.Join(dir, long, "data.db"), size)
+
+	adds := []changes.Change{
+		{Base: dir, Path: "data.db"},
+		{Base: dir, Path: filepath.Join(long, "data.db")},
+	}
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+
+		require.NoError(t, start.WriteArchive(&buf, "/app", adds, compress, true, false, 32*1024))
+
+		dst := t.TempDir()
+
+		args := []string{"-C", dst, "-xf", "-"}
+
+		if compress {
+			args = append(args, "-z")
+		}
+
+		cmd := exec.Command("tar", args...)
+		cmd.Stdin = &buf
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		for _, add := range adds {
+			local := filepath.Join(dir, add.Path)
+			remote := filepath.Join(dst, "app", add.Path)
+
+			ldata, err := os.ReadFile(local)
+			require.NoError(t, err)
+
+			rdata, err := os.ReadFile(remote)
+			require.NoError(t, err)
+			require.Equal(t, ldata, rdata)
+
+			lstat, err := os.Stat(local)
+			require.NoError(t, err)
+
+			rstat, err := os.Stat(remote)
+			require.NoError(t, err)
+			require.Equal(t, lstat.ModTime().Round(time.Second).Unix(), rstat.ModTime().Unix())
+			require.Equal(t, lstat.Mode(), rstat.Mode())
+
+			// the entry that fits the ustar name field is sparse, the long one
+			// falls back to a regular entry that gnu tar extracts densely
+			if add.Path == "data.db" {
+				require.Less(t, rstat.Sys().(*syscall.Stat_t).Blocks*512, size/2)
+			}
+		}
+	}
+}
+
+func TestWriteArchiveSparseXattrs(t *testing.T) {
+	dir := t.TempDir()
+
+	size := int64(8 * 1024 * 1024)
+
+	testSparseFile(t, filepath.Join(dir, "data.db"), size)
+
+	if err := unix.Setxattr(filepath.Join(dir, "data.db"), "user.convox.test", []byte("value"), 0); err != nil {
+		t.Skipf("filesystem does not support user xattrs: %s", err)
+	}
+
+	var buf bytes.Buffer
+
+	require.NoError(t, start.WriteArchive(&buf, "/app", []changes.Change{{Base: dir, Path: "data.db"}}, false, true, true, 32*1024))
+	require.Less(t, buf.Len(), 1024*1024)
+
+	tr := tar.NewReader(&buf)
+
+	h, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "/app/data.db", h.Name)
+	require.Equal(t, size, h.Size)
+	require.Equal(t, "value", h.PAXRecords["SCHILY.xattr.user.convox.test"])
+}
+
+// testSparseFile writes a file of size with data at its start and middle and
+// holes elsewhere, skipping the test when the filesystem does not report holes
+func testSparseFile(t *testing.T, name string, size int64) {
+	fd, err := os.Create(name)
+	require.NoError(t, err)
+	defer fd.Close()
+
+	require.NoError(t, fd.Truncate(size))
+	_, err = fd.WriteAt([]byte("head"), 0)
+	require.NoError(t, err)
+	_, err = fd.WriteAt([]byte("middle"), size/2)
+	require.NoError(t, err)
+	require.NoError(t, fd.Sync())
+
+	if hole, err := unix.Seek(int(fd.Fd()), 0, unix.SEEK_HOLE); err != nil || hole >= size {
+		t.Skip("filesystem does not report holes")
+	}
+}
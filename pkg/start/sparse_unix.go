@@ -0,0 +1,49 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package start
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// dataSegments asks the filesystem where the data in fd is, filesystems that
+// do not track holes report the whole file as data
+func dataSegments(fd *os.File, size int64) ([]sparseSegment, error) {
+	segs := []sparseSegment{}
+
+	for off := int64(0); off < size; {
+		data, err := fd.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		hole, err := fd.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if hole > size {
+			hole = size
+		}
+
+		if hole > data {
+			segs = append(segs, sparseSegment{Offset: data, Length: hole - data})
+		}
+
+		off = hole
+	}
+
+	if _, err := fd.Seek(0, 0); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return segs, nil
+}
@@ -17,6 +17,7 @@ var (
 
 type Interface interface {
 	Start2(context.Context, io.Writer, Options2) error
+	Validate2(context.Context, io.Writer, Options2) error
 }
 
 type Start struct{}
@@ -35,17 +36,18 @@ func prefixHash(prefix string) int {
 	return sum % 18
 }
 
-func prefixWriter(w io.Writer, services map[string]bool) prefix.Writer {
+func prefixWriter(w io.Writer, services map[string]bool, outputs map[string]io.Writer) prefix.Writer {
 	prefixes := map[string]string{
 		"build":  "system",
 		"convox": "system",
+		"test":   "system",
 	}
 
 	for s := range services {
 		prefixes[s] = fmt.Sprintf("color%d", prefixHash(s))
 	}
 
-	return prefix.NewWriter(w, prefixes)
+	return prefix.NewRoutedWriter(w, prefixes, outputs)
 }
 
 func handleInterrupt(fn func()) {
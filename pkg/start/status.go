@@ -0,0 +1,135 @@
+package start
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status holds the live state of the syncs of a running start so that tools
+// such as dashboards can render it without parsing the output, set it on
+// Options2.Status and read it with Watches from any goroutine
+type Status struct {
+	lock    sync.Mutex
+	watches map[*watcher]*watchStatus
+}
+
+// WatchStatus is the state of a local path watched for changes
+type WatchStatus struct {
+	Local    string
+	Remote   string
+	Services []string
+	Targets  []SyncTarget
+	Watching bool
+}
+
+// SyncTarget is a process that changes to a watched path are synced to with
+// the time of the last batch synced to it
+type SyncTarget struct {
+	LastSync time.Time
+	Process  string
+	Remote   string
+	Service  string
+}
+
+type watchStatus struct {
+	local    string
+	remote   string
+	targets  map[string]SyncTarget
+	watcher  *watcher
+	watching bool
+}
+
+// Watches returns a copy of the state of every watched path ordered by path
+func (s *Status) Watches() []WatchStatus {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	wss := []WatchStatus{}
+
+	for _, w := range s.watches {
+		ws := WatchStatus{
+			Local:    w.local,
+			Remote:   w.remote,
+			Services: w.watcher.list(),
+			Targets:  []SyncTarget{},
+			Watching: w.watching,
+		}
+
+		for _, t := range w.targets {
+			ws.Targets = append(ws.Targets, t)
+		}
+
+		sort.Slice(ws.Targets, func(i, j int) bool { return ws.Targets[i].Process < ws.Targets[j].Process })
+
+		wss = append(wss, ws)
+	}
+
+	sort.Slice(wss, func(i, j int) bool {
+		if wss[i].Local != wss[j].Local {
+			return wss[i].Local < wss[j].Local
+		}
+
+		return wss[i].Remote < wss[j].Remote
+	})
+
+	return wss
+}
+
+// watch registers a watcher of the local path rel, watching is false while
+// start waits for the path to be created
+func (s *Status) watch(w *watcher, rel, remote string, watching bool) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.watches == nil {
+		s.watches = map[*watcher]*watchStatus{}
+	}
+
+	if ws, ok := s.watches[w]; ok {
+		ws.watching = watching
+		return
+	}
+
+	s.watches[w] = &watchStatus{local: rel, remote: remote, targets: map[string]SyncTarget{}, watcher: w, watching: watching}
+}
+
+// synced records a batch synced to a process of a watcher
+func (s *Status) synced(w *watcher, service, pid, remote string) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if ws, ok := s.watches[w]; ok {
+		ws.targets[pid] = SyncTarget{LastSync: time.Now(), Process: pid, Remote: remote, Service: service}
+	}
+}
+
+// prune forgets the processes of a watcher that are no longer running
+func (s *Status) prune(w *watcher, current map[string]bool) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if ws, ok := s.watches[w]; ok {
+		for pid := range ws.targets {
+			if !current[pid] {
+				delete(ws.targets, pid)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package start
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+const summaryLargestLayers = 3
+
+type imageLayer struct {
+	Command string
+	Size    uint64
+}
+
+type imageSummary struct {
+	Largest []imageLayer
+	Layers  int
+	Size    uint64
+}
+
+// buildSummary prints the size and largest layers of the images built
+// locally by an external build, rack builds do not expose their images
+func (opts Options2) buildSummary(pw *prefix.Writer, rack string, b *structs.Build) {
+	repo := fmt.Sprintf("%s/%s", rack, b.App)
+
+	data, err := Exec.Execute("docker", "image", "ls", "--filter", fmt.Sprintf("reference=%s:*.%s", repo, b.Id), "--format", "{{.Tag}}")
+	if err != nil {
+		pw.Writef("build", "could not list built images: %s\n", err)
+		return
+	}
+
+	tags := strings.Fields(string(data))
+
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		service := strings.TrimSuffix(tag, "."+b.Id)
+
+		s, err := inspectImageSummary(fmt.Sprintf("%s:%s", repo, tag))
+		if err != nil {
+			pw.Writef("build", "could not inspect the image of <service>%s</service>: %s\n", service, err)
+			continue
+		}
+
+		pw.Writef("build", "<service>%s</service> image is %s in %d layers\n", service, humanize.Bytes(s.Size), s.Layers)
+
+		for _, l := range s.Largest {
+			pw.Writef("build", "  %s %s\n", humanize.Bytes(l.Size), l.Command)
+		}
+	}
+}
+
+func inspectImageSummary(image string) (*imageSummary, error) {
+	data, err := Exec.Execute("docker", "image", "inspect", image, "--format", "{{.Size}} {{len .RootFS.Layers}}")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fields := strings.Fields(string(data))
+
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unexpected inspect output: %s", strings.TrimSpace(string(data)))
+	}
+
+	s := &imageSummary{}
+
+	if s.Size, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if s.Layers, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	data, err = Exec.Execute("docker", "history", image, "--human=false", "--no-trunc", "--format", "{{.Size}}\t{{.CreatedBy}}")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		size, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil || size == 0 {
+			continue
+		}
+
+		s.Largest = append(s.Largest, imageLayer{Command: layerCommand(parts[1]), Size: size})
+	}
+
+	sort.SliceStable(s.Largest, func(i, j int) bool { return s.Largest[i].Size > s.Largest[j].Size })
+
+	if len(s.Largest) > summaryLargestLayers {
+		s.Largest = s.Largest[:summaryLargestLayers]
+	}
+
+	return s, nil
+}
+
+// layerCommand shortens the instruction that created a layer for display
+func layerCommand(cmd string) string {
+	cmd = strings.TrimPrefix(cmd, "/bin/sh -c ")
+	cmd = strings.TrimPrefix(cmd, "#(nop) ")
+	cmd = strings.Join(strings.Fields(cmd), " ")
+
+	if len(cmd) > 60 {
+		cmd = cmd[:57] + "..."
+	}
+
+	return cmd
+}
@@ -0,0 +1,107 @@
+package start
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/manifest"
+	"github.com/convox/convox/pkg/options"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+	"github.com/pkg/errors"
+)
+
+// testFailure is returned when a test command exits non-zero, its Code is
+// used by the cli as the exit status
+type testFailure struct {
+	code    int
+	service string
+}
+
+func (f testFailure) Code() int {
+	return f.code
+}
+
+func (f testFailure) Error() string {
+	return fmt.Sprintf("test failed on %s: exit %d", f.service, f.code)
+}
+
+// runTests runs the test command of each selected service that has one in
+// the promoted release, it returns false if there were no tests to run
+func (opts Options2) runTests(ctx context.Context, pw *prefix.Writer, m *manifest.Manifest, services map[string]bool) (bool, error) {
+	ran := false
+
+	for _, s := range m.Services {
+		if !services[s.Name] || s.Test == "" {
+			continue
+		}
+
+		if !ran {
+			if err := opts.waitForRunning(ctx, pw); err != nil {
+				return true, err
+			}
+
+			opts.checkRelease(pw)
+		}
+
+		ran = true
+
+		if err := opts.runTest(ctx, pw, s); err != nil {
+			return true, err
+		}
+	}
+
+	return ran, nil
+}
+
+func (opts Options2) runTest(ctx context.Context, pw *prefix.Writer, s manifest.Service) error {
+	pw.Writef("test", "running <command>%s</command> on <service>%s</service>\n", s.Test, s.Name)
+
+	ps, err := opts.Provider.ProcessRun(opts.App, s.Name, structs.ProcessRunOptions{Command: options.String("sleep 3600")})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer opts.Provider.ProcessStop(opts.App, ps.Id)
+
+	if err := common.WaitForProcessRunning(opts.Provider, nil, opts.App, ps.Id); err != nil {
+		return err
+	}
+
+	rr, ww := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		pw.Write("test", rr)
+		close(done)
+	}()
+
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(""), ww}
+
+	eopts := structs.ProcessExecOptions{
+		Entrypoint: options.Bool(true),
+		Tty:        options.Bool(false),
+	}
+
+	code, err := opts.Provider.WithContext(ctx).ProcessExec(opts.App, ps.Id, s.Test, rw, eopts)
+
+	ww.Close()
+	<-done
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if code != 0 {
+		pw.Writef("test", "<service>%s</service> exited with %d\n", s.Name, code)
+		return testFailure{code: code, service: s.Name}
+	}
+
+	return nil
+}
@@ -0,0 +1 @@
+seed
@@ -0,0 +1,58 @@
+package start
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/convox/changes"
+)
+
+// Tracer starts the spans that time the phases of Start2 and each sync batch,
+// it is shaped so that an OpenTelemetry tracer can be adapted to it without
+// this package depending on one
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a phase started by a Tracer, End is called once with the error the
+// phase ended with, if any
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End(err error)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) End(err error) {}
+
+// startSpan starts a span with attrs when a Tracer is set
+func (opts Options2) startSpan(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, Span) {
+	if opts.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := opts.Tracer.Start(ctx, name)
+
+	for k, v := range attrs {
+		span.SetAttribute(k, v)
+	}
+
+	return ctx, span
+}
+
+// changesSize returns the total size in bytes of the files of chgs that still
+// exist locally
+func changesSize(chgs []changes.Change) int64 {
+	size := int64(0)
+
+	for _, c := range chgs {
+		if fi, err := os.Stat(filepath.Join(c.Base, c.Path)); err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+	}
+
+	return size
+}
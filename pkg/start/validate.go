@@ -0,0 +1,140 @@
+package start
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/convox/convox/pkg/common"
+	"github.com/convox/convox/pkg/manifest"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/pkg/errors"
+)
+
+// Validate2 cross checks the services of the manifest against the app
+// directory before anything is built or synced and writes a warning for each
+// build path, Dockerfile and COPY source that does not exist, such as a
+// directory renamed without updating the Dockerfile, the warnings do not fail
+// it so that generated sources that are yet to be created are allowed
+func (*Start) Validate2(ctx context.Context, w io.Writer, opts Options2) error {
+	root, err := opts.root()
+	if err != nil {
+		return err
+	}
+
+	mf := common.CoalesceString(opts.Manifest, "convox.yml")
+
+	data, err := os.ReadFile(rootPath(root, mf))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	data, err = manifestData(mf, data)
+	if err != nil {
+		return err
+	}
+
+	env, err := opts.environment(nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	m, err := manifest.Load(data, env)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	services := map[string]bool{}
+
+	if opts.Services == nil {
+		for i := range m.Services {
+			services[m.Services[i].Name] = true
+		}
+	} else if err := expandServices(m, opts.Services, services); err != nil {
+		return err
+	}
+
+	pw := prefixWriter(w, services, opts.Outputs)
+
+	names := []string{}
+
+	for s := range services {
+		names = append(names, s)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		s, err := m.Service(name)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if s.Image != "" {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(root, s.Build.Path)); os.IsNotExist(err) {
+			pw.Writef("convox", "warning: build path <dir>%s</dir> of <service>%s</service> does not exist\n", s.Build.Path, name)
+			continue
+		}
+
+		bss, err := buildSources(nil, m, root, name)
+		if err != nil {
+			pw.Writef("convox", "warning: could not read the Dockerfile of <service>%s</service>: %s\n", name, err)
+			continue
+		}
+
+		warnMissingSources(&pw, s, root, bss)
+	}
+
+	return nil
+}
+
+// missingSources returns the COPY and ADD sources of a service that do not
+// exist relative to root, volumes are left out as docker creates them
+func missingSources(s *manifest.Service, root string, bss []buildSource) []string {
+	volumes := map[string]bool{}
+
+	for _, vs := range volumeSources(s, root) {
+		volumes[filepath.Clean(vs.Local)] = true
+	}
+
+	missing := []string{}
+
+	for _, bs := range bss {
+		local := filepath.Clean(bs.Local)
+
+		if volumes[local] || sourceExists(local) {
+			continue
+		}
+
+		missing = append(missing, relativePath(root, local))
+	}
+
+	return missing
+}
+
+// sourceExists returns true when a source exists or, for a source with
+// wildcards, when it matches anything
+func sourceExists(local string) bool {
+	if strings.ContainsAny(local, "*?[") {
+		matches, err := filepath.Glob(local)
+		return err == nil && len(matches) > 0
+	}
+
+	_, err := os.Stat(local)
+
+	return err == nil
+}
+
+// warnMissingSources writes a warning for each source of a service that does
+// not exist before its sync starts
+func warnMissingSources(pw *prefix.Writer, s *manifest.Service, root string, bss []buildSource) {
+	for _, local := range missingSources(s, root, bss) {
+		pw.Writef("convox", "warning: <dir>%s</dir> copied by the Dockerfile of <service>%s</service> does not exist, check that the Dockerfile matches the app directory\n", local, s.Name)
+	}
+}
@@ -0,0 +1,110 @@
+package start
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/prefix"
+	"github.com/convox/convox/pkg/structs"
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// verifySync reads a synced batch back from a process and compares each file
+// with the local copy, reporting any that did not arrive intact
+func (opts Options2) verifySync(ctx context.Context, pw *prefix.Writer, service, pid, remote string, adds []changes.Change) {
+	p := opts.Provider.WithContext(ctx)
+
+	verified := 0
+
+	for _, add := range adds {
+		err := opts.verifyFile(p, pid, remoteJoin(remote, add.Path), filepath.Join(add.Base, add.Path))
+		if os.IsNotExist(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			pw.Writef("convox", "sync verify error: <dir>%s</dir> on <service>%s</service>: %s\n", add.Path, service, err)
+			continue
+		}
+
+		verified++
+	}
+
+	if verified > 0 {
+		pw.Writef("convox", "sync: verified %d files on <service>%s</service>\n", verified, service)
+	}
+}
+
+// verifyFile compares the md5 of a local file with the copy in a process
+func (opts Options2) verifyFile(p structs.Provider, pid, remote, local string) error {
+	fd, err := os.Open(local)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer fd.Close()
+
+	lh := md5.New()
+
+	if _, err := io.Copy(lh, fd); err != nil {
+		return errors.WithStack(err)
+	}
+
+	rs, err := opts.remoteMD5(p, pid, remote)
+	if err != nil {
+		return err
+	}
+
+	if ls := fmt.Sprintf("%x", lh.Sum(nil)); ls != rs {
+		return errors.WithStack(fmt.Errorf("md5 is %s, expected %s", rs, ls))
+	}
+
+	return nil
+}
+
+// remoteMD5 returns the md5 of a file in a process, FilesDownload only reaches
+// the main container so md5sum is run in any other
+func (opts Options2) remoteMD5(p structs.Provider, pid, remote string) (string, error) {
+	if opts.container != "" {
+		var buf bytes.Buffer
+
+		code, err := p.ProcessExec(opts.App, pid, shellquote.Join("md5sum", remote), &buf, opts.execOptions())
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		fields := strings.Fields(buf.String())
+
+		if code != 0 || len(fields) == 0 {
+			return "", errors.WithStack(fmt.Errorf("could not read %s: %s", remote, strings.TrimSpace(buf.String())))
+		}
+
+		return fields[0], nil
+	}
+
+	r, err := p.FilesDownload(opts.App, pid, remote)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	tr := tar.NewReader(r)
+
+	if _, err := tr.Next(); err != nil {
+		return "", errors.WithStack(fmt.Errorf("could not read %s: %s", remote, err))
+	}
+
+	rh := md5.New()
+
+	if _, err := io.Copy(rh, tr); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return fmt.Sprintf("%x", rh.Sum(nil)), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package start
+
+// fileXattrs can not read extended attributes on this platform so none are
+// synced
+func fileXattrs(path string) map[string]string {
+	return nil
+}
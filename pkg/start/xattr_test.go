@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package start_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/convox/changes"
+	"github.com/convox/convox/pkg/start"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteArchiveXattrs(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "labeled"), []byte("labeled"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plain"), []byte("plain"), 0644))
+
+	if err := unix.Setxattr(filepath.Join(dir, "labeled"), "user.convox.test", []byte("value"), 0); err != nil {
+		t.Skipf("filesystem does not support user xattrs: %s", err)
+	}
+
+	adds := []changes.Change{
+		{Operation: "add", Base: dir, Path: "labeled"},
+		{Operation: "add", Base: dir, Path: "plain"},
+	}
+
+	records := func(xattrs bool) map[string]map[string]string {
+		var buf bytes.Buffer
+
+		require.NoError(t, start.WriteArchive(&buf, "/app", adds, false, false, xattrs, 32*1024))
+
+		found := map[string]map[string]string{}
+
+		tr := tar.NewReader(&buf)
+
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			found[h.Name] = h.PAXRecords
+		}
+
+		return found
+	}
+
+	require.Equal(t, map[string]map[string]string{
+		"/app/labeled": {"SCHILY.xattr.user.convox.test": "value"},
+		"/app/plain":   nil,
+	}, records(true))
+
+	require.Equal(t, map[string]map[string]string{
+		"/app/labeled": nil,
+		"/app/plain":   nil,
+	}, records(false))
+}
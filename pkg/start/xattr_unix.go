@@ -0,0 +1,69 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package start
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileXattrs returns the extended attributes of a file as pax records,
+// attributes that can not be read are left out
+func fileXattrs(path string) map[string]string {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+
+	names := make([]byte, size)
+
+	size, err = unix.Listxattr(path, names)
+	if err != nil {
+		return nil
+	}
+
+	records := map[string]string{}
+
+	for _, name := range strings.Split(string(names[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		value, err := getXattr(path, name)
+		if err != nil {
+			continue
+		}
+
+		records[xattrRecord+name] = value
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	return records
+}
+
+func getXattr(path, name string) (string, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return "", err
+	}
+
+	value := make([]byte, size)
+
+	// the attribute may have grown since its size was read
+	size, err = unix.Getxattr(path, name, value)
+	if errors.Is(err, syscall.ERANGE) {
+		return getXattr(path, name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(value[:size]), nil
+}
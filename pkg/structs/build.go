@@ -8,6 +8,7 @@ type Build struct {
 	Id          string `json:"id"`
 	App         string `json:"app"`
 	Description string `json:"description"`
+	Development bool   `json:"development"`
 	Entrypoint  string `json:"entrypoint"`
 	GitSha      string `json:"git-sha"`
 	Logs        string `json:"logs"`
@@ -33,6 +34,7 @@ type BuildCreateOptions struct {
 	External       *bool     `flag:"external" param:"external"`
 	Manifest       *string   `flag:"manifest,m" param:"manifest"`
 	NoCache        *bool     `flag:"no-cache" param:"no-cache"`
+	Secrets        *[]string `param:"secrets"`
 	WildcardDomain *bool     `flag:"wildcard-domain" param:"wildcard-domain"`
 
 	GitSha *string `param:"git-sha"`
@@ -1,5 +1,12 @@
 package structs
 
-type FileTransterOptions struct {
+type FileTransferOptions struct {
+	Container     *string `query:"container"`
 	TarExtraFlags *string `flag:"tar-extra" query:"tar-extra"`
+	TempDir       *string `query:"temp-dir"`
 }
+
+// FileTransterOptions is the original misspelled name of FileTransferOptions.
+//
+// Deprecated: use FileTransferOptions.
+type FileTransterOptions = FileTransferOptions
@@ -3,6 +3,7 @@ package structs
 import "time"
 
 type LogsOptions struct {
+	Compress *bool          `header:"Compress"`
 	Filter   *string        `flag:"filter" header:"Filter"`
 	Follow   *bool          `header:"Follow"`
 	Prefix   *bool          `header:"Prefix"`
@@ -3,6 +3,7 @@ package k8s
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -20,9 +21,14 @@ import (
 	"github.com/convox/convox/pkg/structs"
 	ca "github.com/convox/convox/provider/k8s/pkg/apis/convox/v1"
 	"github.com/pkg/errors"
+	ac "k8s.io/api/core/v1"
 	am "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// buildSecretsPath is where the build secrets are mounted in the build process
+const buildSecretsPath = "/var/run/convox/build-secrets"
+
 func (p *Provider) buildImage(provider string) string {
 	img := fmt.Sprintf("%s-build", p.Image)
 	if p.buildPrivileged(provider) {
@@ -43,6 +49,7 @@ func (p *Provider) BuildCreate(app, url string, opts structs.BuildCreateOptions)
 	b := structs.NewBuild(app)
 
 	b.Description = common.DefaultString(opts.Description, "")
+	b.Development = common.DefaultBool(opts.Development, false)
 	b.GitSha = common.DefaultString(opts.GitSha, "")
 	b.Started = time.Now()
 
@@ -100,6 +107,15 @@ func (p *Provider) BuildCreate(app, url string, opts structs.BuildCreateOptions)
 		}
 	}
 
+	secrets, err := buildSecrets(opts.Secrets)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(secrets) > 0 {
+		env["BUILD_SECRETS"] = buildSecretsPath
+	}
+
 	psOpts := structs.ProcessRunOptions{
 		Command:     options.String(buildCmd),
 		Cpu:         options.Int(512),
@@ -115,11 +131,32 @@ func (p *Provider) BuildCreate(app, url string, opts structs.BuildCreateOptions)
 		}
 	}
 
-	ps, err := p.ProcessRun(app, "build", psOpts)
+	s, err := p.podSpecFromRunOptions(app, "build", psOpts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(secrets) > 0 {
+		if err := p.buildSecretsCreate(app, b.Id, secrets, s); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	ps, err := p.processRun(app, "build", psOpts, s)
 	if err != nil {
+		if len(secrets) > 0 {
+			p.buildSecretsDelete(app, b.Id)
+		}
 		return nil, errors.WithStack(err)
 	}
 
+	if len(secrets) > 0 {
+		if err := p.buildSecretsOwner(app, b.Id, ps.Id); err != nil {
+			p.buildSecretsDelete(app, b.Id)
+			return nil, errors.WithStack(err)
+		}
+	}
+
 	b, err = p.BuildGet(app, b.Id)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -135,6 +172,103 @@ func (p *Provider) BuildCreate(app, url string, opts structs.BuildCreateOptions)
 	return b, nil
 }
 
+// buildSecrets parses id=value build secrets into the data of a secret
+func buildSecrets(secrets *[]string) (map[string][]byte, error) {
+	data := map[string][]byte{}
+
+	if secrets == nil {
+		return data, nil
+	}
+
+	for _, v := range *secrets {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || len(validation.IsConfigMapKey(parts[0])) > 0 {
+			return nil, errors.New("invalid build secret: " + parts[0])
+		}
+		data[parts[0]] = []byte(parts[1])
+	}
+
+	return data, nil
+}
+
+func buildSecretsName(id string) string {
+	return fmt.Sprintf("build-%s-secrets", strings.ToLower(id))
+}
+
+// buildSecretsCreate stores the build secrets in a secret that is mounted into
+// the build so that they are not visible in its pod spec
+func (p *Provider) buildSecretsCreate(app, id string, secrets map[string][]byte, s *ac.PodSpec) error {
+	secret := &ac.Secret{
+		ObjectMeta: am.ObjectMeta{
+			Name: buildSecretsName(id),
+			Labels: map[string]string{
+				"app":    app,
+				"rack":   p.Name,
+				"system": "convox",
+				"type":   "build-secrets",
+			},
+		},
+		Data: secrets,
+		Type: ac.SecretTypeOpaque,
+	}
+
+	if _, err := p.Cluster.CoreV1().Secrets(p.AppNamespace(app)).Create(context.TODO(), secret, am.CreateOptions{}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.Volumes = append(s.Volumes, ac.Volume{
+		Name: "build-secrets",
+		VolumeSource: ac.VolumeSource{
+			Secret: &ac.SecretVolumeSource{
+				SecretName: secret.Name,
+			},
+		},
+	})
+
+	s.Containers[0].VolumeMounts = append(s.Containers[0].VolumeMounts, ac.VolumeMount{
+		Name:      "build-secrets",
+		MountPath: buildSecretsPath,
+		ReadOnly:  true,
+	})
+
+	return nil
+}
+
+// buildSecretsDelete removes the secret of the build secrets when the build
+// process that would own it could not be set up
+func (p *Provider) buildSecretsDelete(app, id string) {
+	p.Cluster.CoreV1().Secrets(p.AppNamespace(app)).Delete(context.TODO(), buildSecretsName(id), am.DeleteOptions{})
+}
+
+// buildSecretsOwner makes the build process own the secret of its build
+// secrets so that the secret is removed along with it
+func (p *Provider) buildSecretsOwner(app, id, pid string) error {
+	ns := p.AppNamespace(app)
+
+	pd, err := p.Cluster.CoreV1().Pods(ns).Get(context.TODO(), pid, am.GetOptions{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	secret, err := p.Cluster.CoreV1().Secrets(ns).Get(context.TODO(), buildSecretsName(id), am.GetOptions{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	secret.OwnerReferences = append(secret.OwnerReferences, am.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pd.Name,
+		UID:        pd.UID,
+	})
+
+	if _, err := p.Cluster.CoreV1().Secrets(ns).Update(context.TODO(), secret, am.UpdateOptions{}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
 func (p *Provider) BuildExport(app, id string, w io.Writer) error {
 	build, err := p.BuildGet(app, id)
 	if err != nil {
@@ -563,7 +697,8 @@ func (p *Provider) buildMarshal(b *structs.Build) *ca.Build {
 	return &ca.Build{
 		ObjectMeta: am.ObjectMeta{
 			Annotations: map[string]string{
-				"git-sha": b.GitSha,
+				"development": fmt.Sprintf("%t", b.Development),
+				"git-sha":     b.GitSha,
 			},
 			Namespace: p.AppNamespace(b.App),
 			Name:      strings.ToLower(b.Id),
@@ -602,6 +737,7 @@ func (p *Provider) buildUnmarshal(kb *ca.Build) (*structs.Build, error) {
 	b := &structs.Build{
 		App:         kb.ObjectMeta.Labels["app"],
 		Description: kb.Spec.Description,
+		Development: kb.ObjectMeta.Annotations["development"] == "true",
 		Ended:       ended,
 		Entrypoint:  kb.Spec.Entrypoint,
 		GitSha:      kb.ObjectMeta.Annotations["git-sha"],
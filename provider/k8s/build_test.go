@@ -1,6 +1,7 @@
 package k8s_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/convox/convox/pkg/atom"
+	"github.com/convox/convox/pkg/options"
 	"github.com/convox/convox/pkg/structs"
 	"github.com/convox/convox/provider/k8s"
 	ca "github.com/convox/convox/provider/k8s/pkg/apis/convox/v1"
@@ -16,8 +18,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	yaml "gopkg.in/yaml.v2"
+	ac "k8s.io/api/core/v1"
 	am "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
 )
 
 func TestBuildList(t *testing.T) {
@@ -261,6 +266,127 @@ func TestBuildCreate(t *testing.T) {
 	})
 }
 
+func TestBuildCreateSecrets(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Secrets []string
+		Reactor func(kk *fake.Clientset)
+		Err     string
+		Secret  map[string][]byte
+	}{
+		{
+			Name:    "Success",
+			Secrets: []string{"npm=token1", "other=a=b"},
+			Secret:  map[string][]byte{"npm": []byte("token1"), "other": []byte("a=b")},
+		},
+		{
+			Name:    "invalid",
+			Secrets: []string{"npm"},
+			Err:     "invalid build secret: npm",
+		},
+		{
+			Name:    "invalid id",
+			Secrets: []string{"bad id=token1"},
+			Err:     "invalid build secret: bad id",
+		},
+		{
+			Name:    "run fails",
+			Secrets: []string{"npm=token1"},
+			Reactor: func(kk *fake.Clientset) {
+				kk.PrependReactor("create", "pods", func(action kt.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("err1")
+				})
+			},
+			Err: "err1",
+		},
+		{
+			Name:    "owner fails",
+			Secrets: []string{"npm=token1"},
+			Reactor: func(kk *fake.Clientset) {
+				kk.PrependReactor("update", "secrets", func(action kt.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("err1")
+				})
+			},
+			Err: "err1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			testProvider(t, func(p *k8s.Provider) {
+				kk := p.Cluster.(*fake.Clientset)
+
+				aa := p.Atom.(*atom.MockInterface)
+				aa.On("Status", "rack1-app1", "app").Return("Running", "R1234567", nil)
+
+				require.NoError(t, appCreate(kk, "rack1", "app1"))
+
+				// the fake clientset does not name pods from their generate name
+				kk.PrependReactor("create", "pods", func(action kt.Action) (bool, runtime.Object, error) {
+					pd := action.(kt.CreateAction).GetObject().(*ac.Pod)
+					if pd.Name == "" {
+						pd.Name = pd.GenerateName + "1"
+					}
+					return false, nil, nil
+				})
+
+				if test.Reactor != nil {
+					test.Reactor(kk)
+				}
+
+				b, err := p.BuildCreate("app1", "", structs.BuildCreateOptions{Secrets: &test.Secrets})
+				if test.Err != "" {
+					require.EqualError(t, err, test.Err)
+
+					ss, err := kk.CoreV1().Secrets("rack1-app1").List(context.TODO(), am.ListOptions{})
+					require.NoError(t, err)
+					require.Empty(t, ss.Items)
+
+					return
+				}
+				require.NoError(t, err)
+
+				s, err := kk.CoreV1().Secrets("rack1-app1").Get(context.TODO(), fmt.Sprintf("build-%s-secrets", strings.ToLower(b.Id)), am.GetOptions{})
+				require.NoError(t, err)
+				require.Equal(t, test.Secret, s.Data)
+				require.Equal(t, "build-secrets", s.Labels["type"])
+
+				require.Len(t, s.OwnerReferences, 1)
+				require.Equal(t, "Pod", s.OwnerReferences[0].Kind)
+				require.Equal(t, b.Process, s.OwnerReferences[0].Name)
+
+				pd, err := kk.CoreV1().Pods("rack1-app1").Get(context.TODO(), b.Process, am.GetOptions{})
+				require.NoError(t, err)
+				require.Equal(t, s.Name, pd.Spec.Volumes[len(pd.Spec.Volumes)-1].Secret.SecretName)
+				require.NotContains(t, fmt.Sprintf("%v", pd.Spec.Containers[0].Env), "token1")
+			})
+		})
+	}
+}
+
+func TestBuildCreateDevelopment(t *testing.T) {
+	for _, development := range []bool{false, true} {
+		t.Run(fmt.Sprintf("%t", development), func(t *testing.T) {
+			testProvider(t, func(p *k8s.Provider) {
+				kk := p.Cluster.(*fake.Clientset)
+
+				aa := p.Atom.(*atom.MockInterface)
+				aa.On("Status", "rack1-app1", "app").Return("Running", "R1234567", nil)
+
+				require.NoError(t, appCreate(kk, "rack1", "app1"))
+
+				b, err := p.BuildCreate("app1", "", structs.BuildCreateOptions{Development: &development, External: options.Bool(true)})
+				require.NoError(t, err)
+				require.Equal(t, development, b.Development)
+
+				b, err = p.BuildGet("app1", b.Id)
+				require.NoError(t, err)
+				require.Equal(t, development, b.Development)
+			})
+		})
+	}
+}
+
 func buildCreate(kc cv.Interface, ns, id, fixture string) error {
 	spec, err := buildFixture(fixture)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"strings"
 
+	"github.com/convox/convox/pkg/common"
 	"github.com/convox/convox/pkg/structs"
 	"github.com/pkg/errors"
 	ac "k8s.io/api/core/v1"
@@ -64,18 +65,38 @@ func (p *Provider) FilesDownload(app, pid, file string) (io.Reader, error) {
 	return r, nil
 }
 
+// filesUploadStaged extracts the upload into a scratch dir under $1 with the tar command that follows,
+// then moves the files to their absolute paths, leaving the attributes of existing directories alone
+const filesUploadStaged = `set -e
+dir=$(mktemp -d "$1/.convox-upload-XXXXXX")
+trap 'rm -rf "$dir"' EXIT
+shift
+"$@" -C "$dir" -xf -
+cd "$dir"
+find . ! -type d | while IFS= read -r f; do mkdir -p "/${f%/*}" && mv -f "$f" "/$f" || exit 1; done`
+
 func (p *Provider) FilesUpload(app, pid string, r io.Reader, opts structs.FileTransterOptions) error {
-	req := p.Cluster.CoreV1().RESTClient().Post().Resource("pods").Name(pid).Namespace(p.AppNamespace(app)).SubResource("exec").Param("container", app)
+	container := common.DefaultString(opts.Container, app)
+
+	req := p.Cluster.CoreV1().RESTClient().Post().Resource("pods").Name(pid).Namespace(p.AppNamespace(app)).SubResource("exec").Param("container", container)
 
 	cmd := []string{"tar"}
+
+	// extract into a scratch dir under the temp dir and move the files into place from there
+	if opts.TempDir != nil {
+		cmd = []string{"sh", "-c", filesUploadStaged, "sh", *opts.TempDir, "tar"}
+	}
+
 	if opts.TarExtraFlags != nil {
 		cmd = append(cmd, strings.Split(*opts.TarExtraFlags, ",")...)
 	}
 
-	cmd = append(cmd, []string{"-C", "/", "-xf", "-"}...)
+	if opts.TempDir == nil {
+		cmd = append(cmd, []string{"-C", "/", "-xf", "-"}...)
+	}
 
 	eo := &ac.PodExecOptions{
-		Container: app,
+		Container: container,
 		Command:   cmd,
 		Stdin:     true,
 	}
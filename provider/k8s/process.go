@@ -50,7 +50,9 @@ func (p *Provider) ProcessExec(app, pid, command string, rw io.ReadWriter, opts
 		pid = runningPs[rand.Intn(len(runningPs))].Id
 	}
 
-	req := p.Cluster.CoreV1().RESTClient().Post().Resource("pods").Name(pid).Namespace(p.AppNamespace(app)).SubResource("exec").Param("container", app)
+	container := common.DefaultString(opts.Container, app)
+
+	req := p.Cluster.CoreV1().RESTClient().Post().Resource("pods").Name(pid).Namespace(p.AppNamespace(app)).SubResource("exec").Param("container", container)
 
 	cp, err := shellquote.Split(command)
 	if err != nil {
@@ -84,7 +86,7 @@ func (p *Provider) ProcessExec(app, pid, command string, rw io.ReadWriter, opts
 	}
 
 	eo := &ac.PodExecOptions{
-		Container: app,
+		Container: container,
 		Command:   cp,
 		Stdin:     true,
 		Stdout:    true,
@@ -302,6 +304,12 @@ func (p *Provider) ProcessRun(app, service string, opts structs.ProcessRunOption
 		return nil, errors.WithStack(err)
 	}
 
+	return p.processRun(app, service, opts, s)
+}
+
+// processRun creates the pod of a process from a pod spec that the caller can
+// change before it is run
+func (p *Provider) processRun(app, service string, opts structs.ProcessRunOptions, s *ac.PodSpec) (*structs.Process, error) {
 	release := common.DefaultString(opts.Release, "")
 
 	if release == "" {